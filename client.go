@@ -1,59 +1,252 @@
 package dns
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 	"time"
 )
 
 type Client interface {
-	Do(network string, address string, question Question, rec bool, edns bool, dnssec bool) (*Response, error)
+	Exchange(network string, address string, req *Msg) (*Msg, error)
 }
 
+// newQuery makes a query message for question.
+func newQuery(question Question, rec bool, edns bool, dnssec bool) (*Msg, error) {
+	req, err := new(Msg).SetQuestion(question.Name, question.Type)
+	if err != nil {
+		return nil, err
+	}
+	req.Questions[0].Class = question.Class
+	req.Header.SetFlag(RD, rec)
+	if edns {
+		req.SetEDNS0(UDPSize, dnssec)
+	}
+	return req, nil
+}
+
+// query sends a query for question to address with client.
+func query(client Client, network string, address string, question Question, rec bool, edns bool, dnssec bool) (*Msg, error) {
+	req, err := newQuery(question, rec, edns, dnssec)
+	if err != nil {
+		return nil, err
+	}
+	return client.Exchange(network, address, req)
+}
+
+const DefaultTimeout = 5 * time.Second
+
+// BasicClient sends DNS cookies (RFC 7873) in EDNS queries unless NoCookie
+// is set, and remembers the server cookies per server address. Timeout is
+// DefaultTimeout if zero.
 type BasicClient struct {
-	Limit int
-	count int
+	Limit    int
+	Timeout  time.Duration
+	NoCookie bool
+	count    int
+	mu       sync.Mutex
+	cookies  map[string]EDNS0Cookie
+}
+
+func (c *BasicClient) Do(network string, address string, question Question, rec bool, edns bool, dnssec bool) (*Msg, error) {
+	return query(c, network, address, question, rec, edns, dnssec)
+}
+
+// cookie returns the cookie to send to address.
+func (c *BasicClient) cookie(address string) (EDNS0Cookie, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cookie, ok := c.cookies[address]; ok {
+		return cookie, nil
+	}
+	cookie := EDNS0Cookie{Client: make([]byte, 8)}
+	_, err := rand.Read(cookie.Client)
+	if err != nil {
+		return cookie, err
+	}
+	if c.cookies == nil {
+		c.cookies = make(map[string]EDNS0Cookie)
+	}
+	c.cookies[address] = cookie
+	return cookie, nil
+}
+
+// setServerCookie remembers the server cookie of address.
+func (c *BasicClient) setServerCookie(address string, server []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cookie := c.cookies[address]
+	cookie.Server = server
+	c.cookies[address] = cookie
+}
+
+// withCookie returns a copy of req with cookie in the OPT record.
+func withCookie(req *Msg, cookie EDNS0Cookie) *Msg {
+	copied := *req
+	copied.AdditionalResourceRecords = append([]ResourceRecord{}, req.AdditionalResourceRecords...)
+	for i, rr := range copied.AdditionalResourceRecords {
+		if opt, ok := rr.RData.(*OPT); ok {
+			opt := *opt
+			opt.Options = append([]EDNS0Option{}, opt.Options...)
+			opt.SetOption(cookie)
+			copied.AdditionalResourceRecords[i].RData = &opt
+		}
+	}
+	return &copied
+}
+
+// Exchange sends req to address and returns the response. If req is an
+// EDNS query without a cookie, a cookie is added, and the query is sent
+// again once with the new server cookie on BADCOOKIE.
+func (c *BasicClient) Exchange(network string, address string, req *Msg) (*Msg, error) {
+	opt := req.IsEDNS0()
+	if c.NoCookie || opt == nil || opt.Option(EDNS0CodeCookie) != nil {
+		return c.exchange(network, address, req)
+	}
+	for retry := 0; ; retry++ {
+		cookie, err := c.cookie(address)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.exchange(network, address, withCookie(req, cookie))
+		if err != nil {
+			return res, err
+		}
+		resOpt := res.IsEDNS0()
+		if resOpt == nil || resOpt.Option(EDNS0CodeCookie) == nil {
+			// server does not support cookies
+			return res, nil
+		}
+		resCookie, ok := resOpt.Option(EDNS0CodeCookie).(EDNS0Cookie)
+		if !ok || !bytes.Equal(resCookie.Client, cookie.Client) {
+			return res, fmt.Errorf("client cookie mismatch")
+		}
+		if len(resCookie.Server) != 0 {
+			c.setServerCookie(address, resCookie.Server)
+		}
+		if res.Rcode() != BADCOOKIE || 0 < retry {
+			return res, nil
+		}
+	}
 }
 
-func (c *BasicClient) Do(network string, address string, question Question, rec bool, edns bool, dnssec bool) (*Response, error) {
+// exchange sends req over network once, and over TCP again if the UDP
+// response is truncated.
+func (c *BasicClient) exchange(network string, address string, req *Msg) (*Msg, error) {
+	c.mu.Lock()
 	c.count++
-	if 1 <= c.Limit && c.Limit < c.count {
+	count := c.count
+	c.mu.Unlock()
+	if 1 <= c.Limit && c.Limit < count {
 		return nil, fmt.Errorf("exceed count")
 	}
-	reqMsg, err := MakeReqMsg(question, rec, edns, dnssec)
+	var reqMsg []byte
+	var err error
+	if network == "tcp" {
+		reqMsg, err = req.PackBuffer([]byte{0, 0})
+		if err == nil && 0xFFFF < len(reqMsg)-2 {
+			err = fmt.Errorf("message length")
+		}
+		if err == nil {
+			binary.BigEndian.PutUint16(reqMsg, uint16(len(reqMsg)-2))
+		}
+	} else {
+		reqMsg, err = req.Pack()
+	}
 	if err != nil {
 		return nil, err
 	}
-	if network == "tcp" {
-		reqMsg = append([]byte{0, 0}, reqMsg...)
-		binary.BigEndian.PutUint16(reqMsg, uint16(len(reqMsg)-2))
-	}
 
 	timeSent := time.Now()
 	conn, err := net.Dial(network, address)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	conn.SetDeadline(timeSent.Add(timeout))
 	_, err = conn.Write(reqMsg)
 	if err != nil {
 		return nil, err
 	}
-	var buf [UDPSize]byte
-	len, err := conn.Read(buf[:])
-	if err != nil {
-		return nil, err
+	var res *Msg
+	for {
+		var buf []byte
+		if network == "tcp" {
+			buf, err = ReadTCPMsg(conn)
+		} else {
+			buf = make([]byte, 0xFFFF)
+			var n int
+			n, err = conn.Read(buf)
+			buf = buf[:n]
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = new(Msg)
+		err = res.Unpack(buf)
+		if err != nil && 2 <= len(buf) && binary.BigEndian.Uint16(buf) == req.Header.ID {
+			return &Msg{RawMsg: buf}, err
+		}
+		if err == nil && isResponseTo(res, req) {
+			break
+		}
+		if network == "udp" {
+			// not the response, possibly spoofed, so wait for the next one
+			Log.Debugf("exchange: ignore the unexpected response from %v", address)
+			continue
+		}
+		if err != nil {
+			return &Msg{RawMsg: buf}, err
+		}
+		return nil, fmt.Errorf("response mismatch: id %v, question %v", res.Header.ID, res.Questions)
 	}
 	queryTime := time.Since(timeSent)
-	offset := 0
-	if network == "tcp" {
-		offset = 2
+	res.QueryTime = queryTime
+	if network == "udp" && res.Header.Flag(TC) {
+		Log.Debugf("exchange: truncated, retry over TCP: %v", address)
+		return c.exchange("tcp", address, req)
+	}
+	return res, nil
+}
+
+// isResponseTo reports whether res is the response to req, with the ID and
+// the question of req. The question may be omitted in error responses. The
+// response to a request without a question has no question either.
+func isResponseTo(res *Msg, req *Msg) bool {
+	if !res.Header.Flag(QR) || res.Header.ID != req.Header.ID {
+		return false
+	}
+	if len(req.Questions) == 0 {
+		return len(res.Questions) == 0
 	}
-	res, err := ParseResMsg(buf[offset:len])
+	if len(res.Questions) == 0 {
+		return res.Rcode() != NOERROR
+	}
+	question, reqQuestion := res.Questions[0], req.Questions[0]
+	return len(res.Questions) == 1 && question.Name.Equal(reqQuestion.Name) &&
+		question.Type == reqQuestion.Type && question.Class == reqQuestion.Class
+}
+
+// ReadTCPMsg reads a message with the two-byte length prefix (RFC 1035
+// Section 4.2.2).
+func ReadTCPMsg(r io.Reader) ([]byte, error) {
+	var prefix [2]byte
+	_, err := io.ReadFull(r, prefix[:])
 	if err != nil {
-		res = &Response{RawMsg: buf[offset:len]}
-		return res, err
+		return nil, err
 	}
-	res.QueryTime = queryTime
-	return res, nil
+	buf := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
 }
@@ -0,0 +1,231 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+// listenUDP serves the responses of answer over UDP, and returns the
+// address.
+func listenUDP(t *testing.T, answer func(req *Msg) *Msg) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := new(Msg)
+			if req.Unpack(buf[:n]) != nil {
+				continue
+			}
+			if res := answer(req); res != nil {
+				b, _ := res.Pack()
+				conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestBasicClientTCPRetry(t *testing.T) {
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	listener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+
+	answer := func(req *Msg) *Msg {
+		res := new(Msg).SetReply(req)
+		for i := 0; i < 100; i++ {
+			res.AnswerResourceRecords = append(res.AnswerResourceRecords,
+				ResourceRecord{req.Questions[0].Name, TypeA, ClassIN, 300, A(netip.AddrFrom4([4]byte{192, 0, 2, byte(i)}))})
+		}
+		return res
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := new(Msg)
+			if req.Unpack(buf[:n]) != nil {
+				continue
+			}
+			res := answer(req)
+			res.Truncate(512)
+			b, _ := res.Pack()
+			udpConn.WriteTo(b, addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b, err := ReadTCPMsg(conn)
+			req := new(Msg)
+			if err == nil && req.Unpack(b) == nil {
+				b, _ = answer(req).PackBuffer([]byte{0, 0})
+				binary.BigEndian.PutUint16(b, uint16(len(b)-2))
+				// written in two parts to check the framing
+				conn.Write(b[:3])
+				conn.Write(b[3:])
+			}
+			conn.Close()
+		}
+	}()
+
+	client := &BasicClient{}
+	question := Question{"example.com.", TypeA, ClassIN}
+	res, err := client.Do("udp", udpConn.LocalAddr().String(), question, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.Flag(TC) || len(res.AnswerResourceRecords) != 100 {
+		t.Error(res.Header.Flag(TC), len(res.AnswerResourceRecords))
+	}
+}
+
+func TestBasicClientLimit(t *testing.T) {
+	address := listenUDP(t, func(req *Msg) *Msg {
+		return new(Msg).SetReply(req)
+	})
+	client := &BasicClient{Limit: 10}
+	question := Question{"example.com.", TypeA, ClassIN}
+	var wg sync.WaitGroup
+	for i := 0; i < client.Limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Do("udp", address, question, false, false, false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := client.Do("udp", address, question, false, false, false); err == nil {
+		t.Error("no error over the limit")
+	}
+}
+
+func TestBasicClientMismatch(t *testing.T) {
+	// the spoofed responses come first
+	address := listenUDP(t, func(req *Msg) *Msg {
+		return new(Msg).SetReply(req)
+	})
+	spoofed := func(req *Msg) []*Msg {
+		otherID := new(Msg).SetReply(req)
+		otherID.Header.ID++
+		otherQuestion := new(Msg).SetReply(req)
+		otherQuestion.Questions[0].Name = "example.org."
+		otherType := new(Msg).SetReply(req)
+		otherType.Questions[0].Type = TypeAAAA
+		query := *req
+		return []*Msg{otherID, otherQuestion, otherType, &query}
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := new(Msg)
+			if req.Unpack(buf[:n]) != nil {
+				continue
+			}
+			for _, v := range append(spoofed(req), new(Msg).SetReply(req)) {
+				b, _ := v.Pack()
+				conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	question := Question{"Example.com.", TypeA, ClassIN}
+	for _, v := range []string{address, conn.LocalAddr().String()} {
+		req, err := newQuery(question, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := (&BasicClient{}).Exchange("udp", v, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isResponseTo(res, req) {
+			t.Error(res)
+		}
+	}
+
+	// over TCP, a mismatched response is an error
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b, err := ReadTCPMsg(conn)
+			req := new(Msg)
+			if err == nil && req.Unpack(b) == nil {
+				b, _ = spoofed(req)[0].PackBuffer([]byte{0, 0})
+				binary.BigEndian.PutUint16(b, uint16(len(b)-2))
+				conn.Write(b)
+			}
+			conn.Close()
+		}
+	}()
+	if _, err := (&BasicClient{}).Do("tcp", listener.Addr().String(), question, false, false, false); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestIsResponseTo(t *testing.T) {
+	req, err := new(Msg).SetQuestion("example.com.", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noQuestion := &Msg{Header: Header{ID: req.Header.ID, Fields: MakeHeaderFields(OpcodeQuery, 0)}}
+	data := []struct {
+		res      *Msg
+		req      *Msg
+		expected bool
+	}{
+		{new(Msg).SetReply(req), req, true},
+		{new(Msg).SetRcode(req, FORMERR), req, true},
+		{new(Msg).SetReply(noQuestion), req, false},
+		{new(Msg).SetRcode(noQuestion, FORMERR), req, true},
+		{new(Msg).SetReply(noQuestion), noQuestion, true},
+		{new(Msg).SetReply(req), noQuestion, false},
+		{req, req, false},
+	}
+	for i, v := range data {
+		if actual := isResponseTo(v.res, v.req); actual != v.expected {
+			t.Error(i, actual)
+		}
+	}
+}
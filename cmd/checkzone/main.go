@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"try/dns"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// problem is a problem found in a zone. Code is a stable identifier for
+// tools.
+type problem struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Message  string `json:"message"`
+}
+
+// result is the output of -json.
+type result struct {
+	File     string    `json:"file"`
+	Origin   string    `json:"origin"`
+	Records  int       `json:"records"`
+	OK       bool      `json:"ok"`
+	Problems []problem `json:"problems"`
+}
+
+type rrSetKey struct {
+	name  dns.Name
+	type_ dns.Type
+}
+
+// checker holds the RRsets of a zone by the canonical names.
+type checker struct {
+	origin   dns.Name
+	keys     []rrSetKey
+	rrSets   map[rrSetKey][]dns.ResourceRecord
+	types    map[dns.Name][]dns.Type
+	cuts     []dns.Name // the delegation points
+	problems []problem
+}
+
+func (c *checker) add(severity string, code string, name dns.Name, type_ dns.Type, format string, a ...any) {
+	p := problem{severity, code, name.String(), "", fmt.Sprintf(format, a...)}
+	if type_ != 0 {
+		p.Type = type_.String()
+	}
+	c.problems = append(c.problems, p)
+}
+
+func (c *checker) has(name dns.Name, type_ dns.Type) bool {
+	return len(c.rrSets[rrSetKey{name.Canonical(), type_}]) != 0
+}
+
+// delegated reports whether name is at or below a delegation point.
+func (c *checker) delegated(name dns.Name) bool {
+	for _, cut := range c.cuts {
+		if name.IsSubdomainOf(cut) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkZone returns the problems of zone whose origin is origin. The RRSIGs
+// are checked at now.
+func checkZone(zone *dns.Zone, origin dns.Name, now time.Time) []problem {
+	c := &checker{
+		origin: origin.Canonical(),
+		rrSets: make(map[rrSetKey][]dns.ResourceRecord),
+		types:  make(map[dns.Name][]dns.Type),
+	}
+	for _, rr := range zone.Records {
+		name := rr.Name.Canonical()
+		if !name.IsSubdomainOf(c.origin) {
+			c.add(severityError, "out-of-zone", rr.Name, rr.Type, "not in %v", origin)
+			continue
+		}
+		key := rrSetKey{name, rr.Type}
+		if _, ok := c.rrSets[key]; !ok {
+			c.keys = append(c.keys, key)
+			c.types[name] = append(c.types[name], rr.Type)
+		}
+		c.rrSets[key] = append(c.rrSets[key], rr)
+	}
+	sort.SliceStable(c.keys, func(i, j int) bool {
+		if cmp := c.keys[i].name.CompareCanonical(c.keys[j].name); cmp != 0 {
+			return cmp < 0
+		}
+		return c.keys[i].type_ < c.keys[j].type_
+	})
+	for _, key := range c.keys {
+		if key.type_ == dns.TypeNS && key.name != c.origin {
+			c.cuts = append(c.cuts, key.name)
+		}
+	}
+
+	c.checkApex()
+	for _, key := range c.keys {
+		c.checkRRSet(key, now)
+	}
+	return c.problems
+}
+
+func (c *checker) checkApex() {
+	switch soas := c.rrSets[rrSetKey{c.origin, dns.TypeSOA}]; {
+	case len(soas) == 0:
+		c.add(severityError, "missing-soa", c.origin, dns.TypeSOA, "no SOA record at the apex")
+	case 1 < len(soas):
+		c.add(severityError, "multiple-soa", c.origin, dns.TypeSOA, "%v SOA records at the apex", len(soas))
+	}
+	if !c.has(c.origin, dns.TypeNS) {
+		c.add(severityError, "missing-apex-ns", c.origin, dns.TypeNS, "no NS records at the apex")
+	}
+	for _, key := range c.keys {
+		if key.type_ == dns.TypeSOA && key.name != c.origin {
+			c.add(severityError, "soa-not-at-apex", key.name, dns.TypeSOA, "SOA record below the apex")
+		}
+	}
+}
+
+func (c *checker) checkRRSet(key rrSetKey, now time.Time) {
+	rrs := c.rrSets[key]
+	name := rrs[0].Name
+
+	if c.occluded(key) {
+		c.add(severityWarning, "occluded", name, key.type_, "at or below a delegation point")
+	}
+
+	switch key.type_ {
+	case dns.TypeCNAME:
+		if 1 < len(rrs) {
+			c.add(severityError, "multiple-cname", name, key.type_, "%v CNAME records", len(rrs))
+		}
+		for _, v := range c.types[key.name] {
+			// DNSSEC records may coexist (RFC 4035 Section 2.5)
+			if v != dns.TypeCNAME && v != dns.TypeRRSIG && v != dns.TypeNSEC {
+				c.add(severityError, "cname-and-other-data", name, key.type_, "CNAME and %v at the same name", v)
+			}
+		}
+	case dns.TypeNS:
+		for _, rr := range rrs {
+			target, ok := rr.RData.(dns.Name)
+			if !ok {
+				continue
+			}
+			if c.checkTarget("ns-cname", name, key.type_, target) {
+				continue
+			}
+			if !target.IsSubdomainOf(c.origin) || c.has(target, dns.TypeA) || c.has(target, dns.TypeAAAA) {
+				continue
+			}
+			if c.delegated(target) {
+				c.add(severityError, "missing-glue", name, key.type_, "no glue for %v", target)
+			} else {
+				c.add(severityError, "missing-address", name, key.type_, "no address records for %v", target)
+			}
+		}
+	case dns.TypeMX:
+		for _, rr := range rrs {
+			if mx, ok := rr.RData.(dns.MX); ok {
+				c.checkTarget("mx-cname", name, key.type_, dns.Name(mx.Exchange))
+			}
+		}
+	case dns.TypeRRSIG:
+		for _, rr := range rrs {
+			rrsig, ok := rr.RData.(dns.RRSIG)
+			if !ok {
+				continue
+			}
+			err := rrsig.CheckValidityPeriod(now)
+			switch {
+			case errors.Is(err, dns.ErrSignatureExpired):
+				c.add(severityError, "rrsig-expired", name, key.type_, "RRSIG of %v: %v", rrsig.TypeCovered, err)
+			case errors.Is(err, dns.ErrSignatureNotYetValid):
+				c.add(severityWarning, "rrsig-not-yet-valid", name, key.type_, "RRSIG of %v: %v", rrsig.TypeCovered, err)
+			}
+		}
+	}
+
+	var rdatas [][]byte
+	for i, rr := range rrs {
+		// RRSIGs of different types have their own TTLs
+		if key.type_ != dns.TypeRRSIG && rr.TTL != rrs[0].TTL {
+			c.add(severityWarning, "ttl-mismatch", name, key.type_, "TTL %v differs from %v in the RRset", rr.TTL, rrs[0].TTL)
+		}
+		data, err := dns.CanonicalRData(rr.RData).MarshalBinary(nil)
+		if err != nil {
+			c.add(severityError, "invalid-rdata", name, key.type_, "%v", err)
+			continue
+		}
+		for _, v := range rdatas {
+			if bytes.Equal(v, data) {
+				c.add(severityWarning, "duplicate-record", name, key.type_, "duplicate record: %v", rrs[i])
+				break
+			}
+		}
+		rdatas = append(rdatas, data)
+	}
+}
+
+// checkTarget reports target of a NS or MX record that is a CNAME (RFC 2181
+// Section 10.3), and returns whether it is.
+func (c *checker) checkTarget(code string, name dns.Name, type_ dns.Type, target dns.Name) bool {
+	if !c.has(target, dns.TypeCNAME) {
+		return false
+	}
+	c.add(severityError, code, name, type_, "%v is a CNAME", target)
+	return true
+}
+
+// occluded reports whether the RRset of key is data that is not
+// authoritative, at or below a delegation point other than the delegation
+// and glue.
+func (c *checker) occluded(key rrSetKey) bool {
+	for _, cut := range c.cuts {
+		switch {
+		case key.name == cut:
+			switch key.type_ {
+			case dns.TypeNS, dns.TypeDS, dns.TypeNSEC, dns.TypeRRSIG:
+			default:
+				return true
+			}
+		case key.name.IsSubdomainOf(cut):
+			if key.type_ != dns.TypeA && key.type_ != dns.TypeAAAA {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("checkzone", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var origin, at string
+	var jsonOutput bool
+	flags.StringVar(&origin, "origin", "", "")
+	flags.StringVar(&at, "time", "", "")
+	flags.BoolVar(&jsonOutput, "json", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: checkzone [-origin name] [-time YYYYMMDDHHmmSS] [-json] zonefile")
+		return 2
+	}
+	path := flags.Arg(0)
+
+	now := time.Now()
+	if at != "" {
+		t, err := time.Parse(dns.TimeLayout, at)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		now = t
+	}
+
+	res := result{File: path, Problems: []problem{}}
+	zone, err := dns.ReadZonefileWithOrigin(path, origin)
+	if err != nil {
+		res.Problems = append(res.Problems, problem{severityError, "load", "", "", err.Error()})
+	} else {
+		origin = zone.Origin
+		if origin == "" {
+			res.Problems = append(res.Problems, problem{severityError, "missing-origin", "", "", "no origin: use -origin"})
+		} else {
+			res.Origin = dns.Name(origin).Canonical().String()
+			res.Records = len(zone.Records)
+			res.Problems = append(res.Problems, checkZone(zone, dns.Name(origin), now)...)
+		}
+	}
+	res.OK = true
+	for _, v := range res.Problems {
+		if v.Severity == severityError {
+			res.OK = false
+		}
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(res)
+	} else {
+		for _, v := range res.Problems {
+			if v.Type != "" {
+				fmt.Fprintf(stdout, "%v: %v: %v %v: %v (%v)\n", path, v.Severity, v.Name, v.Type, v.Message, v.Code)
+			} else {
+				fmt.Fprintf(stdout, "%v: %v: %v (%v)\n", path, v.Severity, v.Message, v.Code)
+			}
+		}
+		if res.OK {
+			fmt.Fprintf(stdout, "zone %v: loaded %v records\nOK\n", res.Origin, res.Records)
+		}
+	}
+	if !res.OK {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
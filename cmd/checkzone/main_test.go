@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"try/dns"
+)
+
+func writeZone(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckZone(t *testing.T) {
+	zone, err := dns.ReadZonefile(writeZone(t, `$ORIGIN example.com.
+$TTL 3600
+@        SOA   ns1 hostmaster 1 7200 1800 1209600 86400
+@        NS    ns1
+@        NS    ns2
+@        NS    alias
+@        MX    10 alias
+ns1      A     192.0.2.1
+www      CNAME @
+www      A     192.0.2.2
+alias    CNAME www
+dup      A     192.0.2.3
+dup  600 A     192.0.2.3
+sub      NS    ns.sub
+sub      NS    ns.other.example.
+sub      TXT   "occluded"
+x.sub    TXT   "occluded"
+@        RRSIG SOA 15 2 3600 20150819220000 20150729220000 3613 example.com. AAAA
+@        RRSIG NS 15 2 3600 20250819220000 20250729220000 3613 example.com. AAAA
+other.example. A 192.0.2.4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := checkZone(zone, "example.com.", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var actual []string
+	for _, v := range problems {
+		actual = append(actual, strings.Join([]string{v.Severity, v.Code, v.Name, v.Type}, " "))
+	}
+	expected := []string{
+		"error out-of-zone other.example. A",
+		"error missing-address example.com. NS",
+		"error ns-cname example.com. NS",
+		"error mx-cname example.com. MX",
+		"error rrsig-expired example.com. RRSIG",
+		"warning rrsig-not-yet-valid example.com. RRSIG",
+		"warning ttl-mismatch dup.example.com. A",
+		"warning duplicate-record dup.example.com. A",
+		"error missing-glue sub.example.com. NS",
+		"warning occluded sub.example.com. TXT",
+		"warning occluded x.sub.example.com. TXT",
+		"error cname-and-other-data www.example.com. CNAME",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Error(strings.Join(actual, "\n"))
+	}
+}
+
+func TestCheckZoneApex(t *testing.T) {
+	zone, err := dns.ReadZonefile(writeZone(t, `$ORIGIN example.com.
+$TTL 3600
+www A 192.0.2.1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for _, v := range checkZone(zone, "example.com.", time.Now()) {
+		codes = append(codes, v.Code)
+	}
+	if !reflect.DeepEqual(codes, []string{"missing-soa", "missing-apex-ns"}) {
+		t.Error(codes)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var stdout, stderr strings.Builder
+	if status := run([]string{"-origin", "2.0.192.in-addr.arpa", "../../testdata/zones/2.0.192.in-addr.arpa.zone"}, &stdout, &stderr); status != 0 {
+		t.Error(status, stdout.String(), stderr.String())
+	}
+	if !strings.HasSuffix(stdout.String(), "\nOK\n") {
+		t.Error(stdout.String())
+	}
+
+	stdout.Reset()
+	if status := run([]string{"-json", "-origin", "2.0.192.in-addr.arpa", "../../testdata/zones/2.0.192.in-addr.arpa.zone"}, &stdout, &stderr); status != 0 {
+		t.Error(status, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), `"problems": []`) {
+		t.Error(stdout.String())
+	}
+
+	path := writeZone(t, `$ORIGIN example.com.
+$TTL 3600
+@ SOA ns1 hostmaster 1 7200 1800 1209600 86400
+`)
+	stdout.Reset()
+	if status := run([]string{"-json", path}, &stdout, &stderr); status != 1 {
+		t.Error(status)
+	}
+	var res result
+	if err := json.Unmarshal([]byte(stdout.String()), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.OK || res.Origin != "example.com." || res.Records != 1 || len(res.Problems) != 1 || res.Problems[0].Code != "missing-apex-ns" {
+		t.Error(res)
+	}
+
+	stdout.Reset()
+	if status := run([]string{"-json", writeZone(t, "www A 192.0.2.1\n")}, &stdout, &stderr); status != 1 {
+		t.Error(status)
+	}
+	if err := json.Unmarshal([]byte(stdout.String()), &res); err != nil || res.Problems[0].Code != "load" {
+		t.Error(stdout.String(), err)
+	}
+
+	if status := run(nil, &stdout, &stderr); status != 2 {
+		t.Error(status)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"try/dns"
+	"try/dns/idna"
 )
 
 func arpaName(ipaddr string) (string, error) {
@@ -37,15 +38,38 @@ func arpaName(ipaddr string) (string, error) {
 }
 
 type opts struct {
-	server  string
-	port    string
-	reverse bool
-	name    string
-	type_   string
-	short   bool
-	tcp     bool
-	rec     bool
-	raw     bool
+	server   string
+	port     string
+	reverse  bool
+	name     string
+	type_    string
+	short    bool
+	tcp      bool
+	rec      bool
+	raw      bool
+	noCookie bool
+	subnet   netip.Prefix
+	idnout   bool
+}
+
+// parseSubnet parses the +subnet value, an address with an optional prefix
+// length. "0" is the subnet 0.0.0.0/0 opting out of client subnet.
+func parseSubnet(s string) (netip.Prefix, error) {
+	if s == "0" {
+		return netip.PrefixFrom(netip.IPv4Unspecified(), 0), nil
+	}
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return prefix, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
 }
 
 func getOpts(args []string) (*opts, error) {
@@ -80,11 +104,27 @@ func getOpts(args []string) (*opts, error) {
 				opts.rec = false
 			case "+raw":
 				opts.raw = true
+			case "+nocookie":
+				opts.noCookie = true
+			case "+idnout":
+				opts.idnout = true
 			default:
+				if strings.HasPrefix(args[i], "+subnet=") {
+					subnet, err := parseSubnet(strings.TrimPrefix(args[i], "+subnet="))
+					if err != nil {
+						return nil, fmt.Errorf("invalid arg: %v: %w", args[i], err)
+					}
+					opts.subnet = subnet
+					continue
+				}
 				return nil, fmt.Errorf("invalid arg: %v", args[i])
 			}
 		case !name_flg:
-			opts.name = strings.ToLower(args[i])
+			name, err := idna.ToASCII(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid name: %v: %w", args[i], err)
+			}
+			opts.name = strings.ToLower(name)
 			name_flg = true
 			if !type_flg {
 				opts.type_ = "A"
@@ -122,18 +162,38 @@ func defaultNameServer() (string, error) {
 	}
 }
 
-func print(res *dns.Response, opts *opts) {
+// toUnicode returns line with the A-labels in the names converted to
+// U-labels. Quoted strings are kept.
+func toUnicode(line string) string {
+	fields := strings.Split(line, " ")
+	for i, field := range fields {
+		if strings.HasPrefix(field, `"`) || !strings.Contains(strings.ToLower(field), "xn--") {
+			continue
+		}
+		if converted, err := idna.ToUnicode(field); err == nil {
+			fields[i] = converted
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func print(res *dns.Msg, opts *opts) {
+	printLine := func(a any) {
+		line := fmt.Sprint(a)
+		if opts.idnout {
+			line = toUnicode(line)
+		}
+		fmt.Println(line)
+	}
 	if opts.short {
 		for i := 0; i < len(res.AnswerResourceRecords); i++ {
-			fmt.Println(res.AnswerResourceRecords[i].RData)
+			printLine(res.AnswerResourceRecords[i].RData)
 		}
 	} else {
-		var opt *dns.ResourceRecord
+		opt := res.IsEDNS0()
 		additionals := make([]dns.ResourceRecord, 0, len(res.AdditionalResourceRecords))
 		for i := 0; i < len(res.AdditionalResourceRecords); i++ {
-			if res.AdditionalResourceRecords[i].Type.String() == "OPT" {
-				opt = &res.AdditionalResourceRecords[i]
-			} else {
+			if res.AdditionalResourceRecords[i].Type != dns.TypeOPT {
 				additionals = append(additionals, res.AdditionalResourceRecords[i])
 			}
 		}
@@ -143,16 +203,22 @@ func print(res *dns.Response, opts *opts) {
 
 		if opt != nil {
 			fmt.Println(";; OPT PSEUDOSECTION:")
-			fmt.Printf("; %v\n", opt.String())
+			for _, line := range strings.Split(opt.String(), "\n") {
+				fmt.Printf("; %v\n", line)
+			}
+			fmt.Println()
 		}
 
 		fmt.Println(";; QUESTION SECTION:")
-		fmt.Printf(";%v\n\n", res.Question)
+		for _, question := range res.Questions {
+			printLine(";" + question.String())
+		}
+		fmt.Println()
 
 		if 0 < len(res.AnswerResourceRecords) {
 			fmt.Println(";; ANSWER SECTION:")
 			for i := 0; i < len(res.AnswerResourceRecords); i++ {
-				fmt.Println(res.AnswerResourceRecords[i])
+				printLine(res.AnswerResourceRecords[i])
 			}
 			fmt.Println()
 		}
@@ -160,7 +226,7 @@ func print(res *dns.Response, opts *opts) {
 		if 0 < len(res.AuthorityResourceRecords) {
 			fmt.Println(";; AUTHORITY SECTION:")
 			for i := 0; i < len(res.AuthorityResourceRecords); i++ {
-				fmt.Println(res.AuthorityResourceRecords[i])
+				printLine(res.AuthorityResourceRecords[i])
 			}
 			fmt.Println()
 		}
@@ -168,7 +234,7 @@ func print(res *dns.Response, opts *opts) {
 		if 0 < len(additionals) {
 			fmt.Println(";; ADDITIONAL SECTION:")
 			for i := 0; i < len(additionals); i++ {
-				fmt.Println(additionals[i])
+				printLine(additionals[i])
 			}
 			fmt.Println()
 		}
@@ -219,12 +285,21 @@ func main() {
 	if opts.tcp {
 		network = "tcp"
 	}
-	client := dns.BasicClient{}
+	client := dns.BasicClient{NoCookie: opts.noCookie}
 	question, err := dns.NewQuestionFromString(opts.name, opts.type_, "IN")
 	if err != nil {
 		die(err)
 	}
-	res, err := client.Do(network, opts.server+":"+opts.port, question, opts.rec, true, false)
+	req, err := new(dns.Msg).SetQuestion(question.Name, question.Type)
+	if err != nil {
+		die(err)
+	}
+	req.Header.SetFlag(dns.RD, opts.rec)
+	req.SetEDNS0(dns.UDPSize, false)
+	if opts.subnet.IsValid() {
+		req.IsEDNS0().SetOption(dns.NewEDNS0ClientSubnet(opts.subnet))
+	}
+	res, err := client.Exchange(network, opts.server+":"+opts.port, req)
 	if err != nil {
 		if res != nil {
 			printBytes(res.RawMsg)
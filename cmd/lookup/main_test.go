@@ -25,3 +25,47 @@ func TestDefaultNameServer(t *testing.T) {
 		t.Error(server)
 	}
 }
+
+func TestGetOptsSubnet(t *testing.T) {
+	data := []struct {
+		arg      string
+		expected netip.Prefix
+	}{
+		{"+subnet=192.0.2.1/24", netip.MustParsePrefix("192.0.2.0/24")},
+		{"+subnet=192.0.2.1", netip.MustParsePrefix("192.0.2.1/32")},
+		{"+subnet=2001:db8::1/56", netip.MustParsePrefix("2001:db8::/56")},
+		{"+subnet=0", netip.MustParsePrefix("0.0.0.0/0")},
+	}
+	for _, v := range data {
+		opts, err := getOpts([]string{"example.com", v.arg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.subnet != v.expected {
+			t.Error(v.arg, opts.subnet)
+		}
+	}
+	if _, err := getOpts([]string{"example.com", "+subnet=foo"}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestGetOptsIDN(t *testing.T) {
+	opts, err := getOpts([]string{"日本語.ＪＰ", "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.name != "xn--wgv71a119e.jp" {
+		t.Error(opts.name)
+	}
+	if _, err := getOpts([]string{"日 本.jp"}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	line := `xn--wgv71a119e.jp. 300 IN TXT "xn--wgv71a119e"`
+	if actual := toUnicode(line); actual != `日本語.jp. 300 IN TXT "xn--wgv71a119e"` {
+		t.Error(actual)
+	}
+}
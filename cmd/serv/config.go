@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"try/dns"
+)
+
+// config is the configuration file of serv. The format follows nsd.conf: a
+// "server:" section and "zone:" sections of "key: value" lines, with comments
+// from "#".
+//
+//	server:
+//	  ip-address: 127.0.0.1@8053
+//	  mode: authoritative
+//	  zonesdir: "zones"
+//	zone:
+//	  name: example.com
+//	  zonefile: example.com.zone
+type config struct {
+	Addresses      []string // host:port
+	Mode           string
+	RootHints      string
+	RootAnchorsXML string
+	Zones          []zoneConfig
+}
+
+// zoneConfig is a "zone:" section.
+type zoneConfig struct {
+	Name             string // the origin, or empty to take it from File
+	File             string
+	MinimalResponses bool // omit the additional records
+}
+
+// ignoredSections are the nsd.conf sections which serv does not use.
+var ignoredSections = map[string]bool{
+	"key":            true,
+	"pattern":        true,
+	"remote-control": true,
+	"tls-auth":       true,
+	"verify":         true,
+	"dnstap":         true,
+}
+
+// readConfig reads the configuration file path. The relative paths are
+// relative to zonesdir, which is relative to the directory of path. The
+// other options and sections of nsd.conf are skipped with a warning.
+func readConfig(path string) (*config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	conf := new(config)
+	var ips []string
+	port := "53"
+	zonesDir := filepath.Dir(path)
+	var section string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i != -1 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("%v:%v: missing \":\"", path, line)
+		}
+		key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"`)
+		if value == "" {
+			switch key {
+			case "server", "zone":
+				section = key
+				if key == "zone" {
+					conf.Zones = append(conf.Zones, zoneConfig{})
+				}
+				continue
+			}
+			if ignoredSections[key] {
+				section = key
+				dns.Log.Warnf("%v:%v: ignore section %v", path, line, key)
+				continue
+			}
+		}
+
+		err = nil
+		switch section {
+		case "server":
+			switch key {
+			case "ip-address":
+				ips = append(ips, value)
+			case "port":
+				port = value
+			case "mode":
+				conf.Mode = value
+			case "zonesdir":
+				if filepath.IsAbs(value) {
+					zonesDir = value
+				} else {
+					zonesDir = filepath.Join(filepath.Dir(path), value)
+				}
+			case "root-hints":
+				conf.RootHints = value
+			case "root-anchors-xml":
+				conf.RootAnchorsXML = value
+			default:
+				dns.Log.Warnf("%v:%v: ignore option %v", path, line, key)
+			}
+		case "zone":
+			zone := &conf.Zones[len(conf.Zones)-1]
+			switch key {
+			case "name":
+				zone.Name = value
+			case "zonefile":
+				zone.File = value
+			case "minimal-responses":
+				zone.MinimalResponses, err = parseYesNo(value)
+			default:
+				dns.Log.Warnf("%v:%v: ignore option %v", path, line, key)
+			}
+		case "":
+			err = fmt.Errorf("%v outside of a section", key)
+		default:
+			// a line of an ignored section
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, v := range ips {
+		// nsd.conf writes the port after "@"
+		host, ipPort, ok := strings.Cut(v, "@")
+		if !ok {
+			ipPort = port
+		}
+		conf.Addresses = append(conf.Addresses, net.JoinHostPort(host, ipPort))
+	}
+	for i, v := range conf.Zones {
+		if v.File == "" {
+			return nil, fmt.Errorf("%v: zone %v: no zonefile", path, v.Name)
+		}
+		if !filepath.IsAbs(v.File) {
+			conf.Zones[i].File = filepath.Join(zonesDir, v.File)
+		}
+	}
+	for _, v := range []*string{&conf.RootHints, &conf.RootAnchorsXML} {
+		if *v != "" && !filepath.IsAbs(*v) {
+			*v = filepath.Join(filepath.Dir(path), *v)
+		}
+	}
+	return conf, nil
+}
+
+func parseYesNo(s string) (bool, error) {
+	switch s {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("%v: not yes or no", s)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeZonefile(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "zone")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	conf, err := readConfig("../../testdata/serv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &config{
+		Addresses: []string{"127.0.0.1:8053"},
+		Mode:      "authoritative",
+		Zones: []zoneConfig{
+			{Name: "example.com", File: "../../testdata/zones/example.com.zone"},
+			{Name: "2.0.192.in-addr.arpa", File: "../../testdata/zones/2.0.192.in-addr.arpa.zone"},
+			{Name: "8.b.d.0.1.0.0.2.ip6.arpa", File: "../../testdata/zones/8.b.d.0.1.0.0.2.ip6.arpa.zone"},
+		},
+	}
+	if !reflect.DeepEqual(conf, expected) {
+		t.Errorf("%+v", conf)
+	}
+
+	// nsd.conf
+	conf, err = readConfig("../../nsd/conf/nsd.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Zones) != 3 || conf.Zones[2].File != "/zones/8.b.d.0.1.0.0.2.ip6.arpa.zone" {
+		t.Errorf("%+v", conf)
+	}
+
+	conf, err = readConfig(writeZonefile(t, `server:
+  ip-address: ::1  # comment
+  ip-address: 192.0.2.1@53
+  port: 8053
+  root-hints: /etc/named.root
+zone:
+  name: example.com
+  zonefile: "example.com.zone"
+  minimal-responses: yes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.Addresses, []string{"[::1]:8053", "192.0.2.1:53"}) || conf.RootHints != "/etc/named.root" || !conf.Zones[0].MinimalResponses {
+		t.Errorf("%+v", conf)
+	}
+
+	// the options and sections which serv does not use
+	conf, err = readConfig(writeZonefile(t, `server:
+  verbosity: 2
+  unknown: 1
+key:
+  name: "tsig-key"
+  algorithm: hmac-sha256
+pattern:
+  name: "secondary"
+  zonefile: "%s.zone"
+zone:
+  name: example.com
+  include-pattern: "secondary"
+  zonefile: example.com.zone
+remote-control:
+  control-enable: yes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Zones) != 1 || conf.Zones[0].Name != "example.com" || filepath.Base(conf.Zones[0].File) != "example.com.zone" {
+		t.Errorf("%+v", conf)
+	}
+
+	for _, v := range []string{
+		"name: example.com\n",
+		"zone:\n  name example.com\n",
+		"zone:\n  name: example.com\n",
+		"zone:\n  zonefile: example.com.zone\n  minimal-responses: 1\n",
+	} {
+		if _, err := readConfig(writeZonefile(t, v)); err == nil {
+			t.Error(v)
+		}
+	}
+}
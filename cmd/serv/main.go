@@ -1,156 +1,411 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"path"
 	"sort"
 	"strings"
+	"time"
 	"try/dns"
 )
 
-var zoneAuthorities []dns.ResourceRecord
+// zone is a zone which the authoritative server serves.
+type zone struct {
+	origin dns.Name
+	// records maps the questions with the canonical names to the records.
+	records          map[dns.Question][]dns.ResourceRecord
+	authorities      []dns.ResourceRecord
+	minimalResponses bool
+}
 
-var zoneResourceRecords map[dns.Question][]dns.ResourceRecord
+// zoneTable maps the canonical origins to the zones.
+type zoneTable map[dns.Name]*zone
 
-func loadZonefiles(path string) error {
-	zoneResourceRecords = make(map[dns.Question][]dns.ResourceRecord)
+var zones zoneTable
 
-	zone, err := dns.ReadZonefile(path)
-	if err != nil {
-		return err
+// find returns the closest enclosing zone of name, or nil if there is none.
+func (t zoneTable) find(name dns.Name) *zone {
+	var closest *zone
+	for origin, z := range t {
+		if name.IsSubdomainOf(origin) && (closest == nil || closest.origin.CountLabels() < origin.CountLabels()) {
+			closest = z
+		}
 	}
-	for _, v := range zone.Records {
-		key := dns.Question{Name: v.Name, Type: v.Type, Class: v.Class}
-		zoneResourceRecords[key] = append(zoneResourceRecords[key], v)
+	return closest
+}
+
+// loadZones reads the zone files of configs.
+func loadZones(configs []zoneConfig) error {
+	table := make(zoneTable)
+	for _, v := range configs {
+		zonefile, err := dns.ReadZonefileWithOrigin(v.File, v.Name)
+		if err != nil {
+			return err
+		}
+		origin := dns.Name(zonefile.Origin).Canonical()
+		if v.Name != "" {
+			origin = dns.Name(v.Name).Canonical()
+			if !strings.HasSuffix(string(origin), ".") {
+				origin += "."
+			}
+		}
+		if origin == "" {
+			return fmt.Errorf("%v: no origin", v.File)
+		}
+		if _, ok := table[origin]; ok {
+			return fmt.Errorf("%v: zone %v is loaded twice", v.File, origin)
+		}
+		z := &zone{
+			origin:           origin,
+			records:          make(map[dns.Question][]dns.ResourceRecord),
+			minimalResponses: v.MinimalResponses,
+		}
+		for _, rr := range zonefile.Records {
+			key := dns.Question{Name: rr.Name.Canonical(), Type: rr.Type, Class: rr.Class}
+			z.records[key] = append(z.records[key], rr)
+		}
+		if len(z.records[dns.Question{Name: origin, Type: dns.TypeSOA, Class: dns.ClassIN}]) == 0 {
+			return fmt.Errorf("%v: zone %v: no SOA record at the apex", v.File, origin)
+		}
+		z.authorities = z.records[dns.Question{Name: origin, Type: dns.TypeNS, Class: dns.ClassIN}]
+		table[origin] = z
 	}
-	key := dns.Question{Name: dns.Name(zone.Origin), Type: dns.TypeNS, Class: dns.ClassIN}
-	zoneAuthorities = zoneResourceRecords[key]
+	zones = table
 	return nil
 }
 
+// findResourceRecords returns the records in the closest enclosing zone of
+// name.
 func findResourceRecords(name dns.Name, type_ dns.Type, class dns.Class) []dns.ResourceRecord {
-	return zoneResourceRecords[dns.Question{Name: dns.Name(name), Type: type_, Class: class}]
+	z := zones.find(name)
+	if z == nil {
+		return nil
+	}
+	return z.records[dns.Question{Name: name.Canonical(), Type: type_, Class: class}]
+}
+
+// svcbTargets returns the name whose addresses the SVCB or HTTPS record rr
+// leads to, and the name whose records of the same type it leads to in
+// AliasMode (RFC 9460 Section 4.1).
+func svcbTargets(rr dns.ResourceRecord, svcb dns.SVCB) (target dns.Name, alias dns.Name) {
+	switch {
+	case svcb.IsAlias() && svcb.Target != ".":
+		return "", dns.Name(svcb.Target)
+	case svcb.IsAlias():
+		return "", ""
+	case svcb.Target == ".":
+		return rr.Name, ""
+	}
+	return dns.Name(svcb.Target), ""
 }
 
+// getAdditionals returns the address records of the MX exchanges and the
+// SRV targets, and the SRV records and addresses that NAPTR records lead to
+// (RFC 3403 Section 4.2), A records first. For SVCB and HTTPS records, it
+// returns the address records of the targets, and the records that
+// AliasMode records lead to.
 func getAdditionals(answers []dns.ResourceRecord) []dns.ResourceRecord {
-	var results1, results2 []dns.ResourceRecord
+	var srvs, targets []dns.Name
+	var results []dns.ResourceRecord
+	var addSVCB func(rr dns.ResourceRecord, svcb dns.SVCB, follow bool)
+	addSVCB = func(rr dns.ResourceRecord, svcb dns.SVCB, follow bool) {
+		target, alias := svcbTargets(rr, svcb)
+		if target != "" {
+			targets = append(targets, target)
+		}
+		if alias == "" || !follow {
+			return
+		}
+		rrs := findResourceRecords(alias, rr.Type, dns.ClassIN)
+		results = append(results, rrs...)
+		for _, v := range rrs {
+			switch rdata := v.RData.(type) {
+			case dns.SVCB:
+				addSVCB(v, rdata, false)
+			case dns.HTTPS:
+				addSVCB(v, rdata.SVCB, false)
+			}
+		}
+	}
 	for _, answer := range answers {
-		if answer.Type == dns.TypeMX {
-			rrs := findResourceRecords(dns.Name(answer.RData.(dns.MX).Exchange), dns.TypeA, dns.ClassIN)
-			results1 = append(results1, rrs...)
-			rrs = findResourceRecords(dns.Name(answer.RData.(dns.MX).Exchange), dns.TypeAAAA, dns.ClassIN)
-			results2 = append(results2, rrs...)
+		switch rdata := answer.RData.(type) {
+		case dns.SVCB:
+			addSVCB(answer, rdata, true)
+		case dns.HTTPS:
+			addSVCB(answer, rdata.SVCB, true)
+		case dns.MX:
+			targets = append(targets, dns.Name(rdata.Exchange))
+		case dns.SRV:
+			targets = append(targets, dns.Name(rdata.Target))
+		case dns.NAPTR:
+			switch strings.ToUpper(rdata.Flags) {
+			case "S":
+				srvs = append(srvs, dns.Name(rdata.Replacement))
+			case "A":
+				targets = append(targets, dns.Name(rdata.Replacement))
+			}
 		}
 	}
-	return append(results1, results2...)
+	for _, name := range srvs {
+		rrs := findResourceRecords(name, dns.TypeSRV, dns.ClassIN)
+		for _, rr := range rrs {
+			targets = append(targets, dns.Name(rr.RData.(dns.SRV).Target))
+		}
+		results = append(results, rrs...)
+	}
+	for _, type_ := range []dns.Type{dns.TypeA, dns.TypeAAAA} {
+		for _, name := range targets {
+			results = append(results, findResourceRecords(name, type_, dns.ClassIN)...)
+		}
+	}
+	return results
 }
 
-type RequestHandler func(dns.Request) (*dns.Response, error)
+// RequestHandler returns the response to req from addr.
+type RequestHandler func(req *dns.Msg, addr net.Addr) (*dns.Msg, error)
+
+// clientSubnet returns the client subnet option of req.
+func clientSubnet(req *dns.Msg) (dns.EDNS0ClientSubnet, bool) {
+	opt := req.IsEDNS0()
+	if opt == nil {
+		return dns.EDNS0ClientSubnet{}, false
+	}
+	ecs, ok := opt.Option(dns.EDNS0CodeClientSubnet).(dns.EDNS0ClientSubnet)
+	return ecs, ok
+}
+
+// setClientSubnet echoes the client subnet option of req in res with scope.
+func setClientSubnet(res *dns.Msg, req *dns.Msg, scope uint8) {
+	ecs, ok := clientSubnet(req)
+	if !ok {
+		return
+	}
+	if ecs.SourcePrefix < scope {
+		scope = ecs.SourcePrefix
+	}
+	ecs.ScopePrefix = scope
+	res.SetEDNS0(dns.UDPSize, req.IsEDNS0().DO)
+	res.IsEDNS0().SetOption(ecs)
+}
 
 // authoritativeServer is RequestHandler for authoritative server.
-func authoritativeServer(req dns.Request) (*dns.Response, error) {
-	var answers, additionals []dns.ResourceRecord
+func authoritativeServer(req *dns.Msg, addr net.Addr) (*dns.Msg, error) {
+	var additionals []dns.ResourceRecord
 
-	answers, ok := zoneResourceRecords[req.Question]
-	if ok {
-		additionals = getAdditionals(answers)
+	question := req.Questions[0]
+	z := zones.find(question.Name)
+	if z == nil {
+		// not authoritative for the name
+		res := new(dns.Msg).SetRcode(req, dns.REFUSED)
+		setClientSubnet(res, req, 0)
+		return res, nil
+	}
+	answers := findResourceRecords(question.Name, question.Type, question.Class)
+	if len(answers) != 0 {
+		if !z.minimalResponses {
+			additionals = getAdditionals(answers)
+		}
 	} else {
 		// CNAME
-		answers = findResourceRecords(req.Question.Name, dns.TypeCNAME, dns.ClassIN)
+		answers = findResourceRecords(question.Name, dns.TypeCNAME, dns.ClassIN)
 		if len(answers) == 1 {
 			cname := answers[0]
-			rrs := findResourceRecords(cname.RData.(dns.CNAME), req.Question.Type, dns.ClassIN)
+			rrs := findResourceRecords(cname.RData.(dns.CNAME), question.Type, dns.ClassIN)
 			answers = append(answers, rrs...)
 		} else {
-			return dns.MakeResponse(req.Header.ID,
-				dns.MakeHeaderFields(req.Header.Opcode(), dns.QR, dns.NXDOMAIN),
-				req.Question, nil, nil, nil)
+			res := new(dns.Msg).SetRcode(req, dns.NXDOMAIN)
+			setClientSubnet(res, req, 0)
+			return res, nil
 		}
 	}
-	return dns.MakeResponse(req.Header.ID,
-		dns.MakeHeaderFields(req.Header.Opcode(), dns.QR, dns.AA, dns.NOERROR),
-		req.Question, answers, zoneAuthorities, additionals)
+	res := new(dns.Msg).SetReply(req)
+	res.Header.SetFlag(dns.AA, true)
+	res.AnswerResourceRecords = answers
+	res.AuthorityResourceRecords = z.authorities
+	res.AdditionalResourceRecords = additionals
+	// the answers are the same for all clients
+	setClientSubnet(res, req, 0)
+	return res, nil
 }
 
 var cache = dns.NewCache()
 
+// requestSubnet returns the client subnet to send for req from addr. It is
+// invalid if the client opts out or has no public address.
+func requestSubnet(req *dns.Msg, addr net.Addr) netip.Prefix {
+	if ecs, ok := clientSubnet(req); ok {
+		return ecs.Prefix()
+	}
+	if addr == nil {
+		return netip.Prefix{}
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Prefix{}
+	}
+	ip := addrPort.Addr().Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return netip.Prefix{}
+	}
+	return netip.PrefixFrom(ip, ip.BitLen())
+}
+
 // resolver is RequestHandler for full-service resolver.
-func resolver(req dns.Request) (*dns.Response, error) {
-	if req.Question.Name == "." && req.Question.Type == dns.TypeNS {
+func resolver(req *dns.Msg, addr net.Addr) (*dns.Msg, error) {
+	question := req.Questions[0]
+	res := new(dns.Msg).SetReply(req)
+	res.Header.SetFlag(dns.RA, true)
+	if question.Name.Equal(".") && question.Type == dns.TypeNS {
 		// root
-		answers := dns.RootServerNSRRs
-		additionals := dns.RootServers
-		return dns.MakeResponse(req.Header.ID,
-			dns.MakeHeaderFields(req.Header.Opcode(), dns.QR, dns.AA, dns.RD, dns.RA, dns.NOERROR),
-			req.Question, answers, zoneAuthorities, additionals)
+		res.Header.SetFlag(dns.AA, true)
+		res.AnswerResourceRecords = dns.RootServerNSRRs
+		res.AdditionalResourceRecords = append([]dns.ResourceRecord{}, dns.RootServers...)
+		return res, nil
 	}
 
 	dnssec := true
-	rrs, ad, err := dns.Resolve(req.Question, true, dnssec, nil, cache)
+	rrs, ad, scope, err := dns.ResolveWithClientSubnet(question, requestSubnet(req, addr), true, dnssec, nil, cache)
 	if err != nil {
-		return dns.MakeResponse(req.Header.ID,
-			dns.MakeHeaderFields(req.Header.Opcode(), dns.QR, dns.RD, dns.RA, dns.NXDOMAIN),
-			req.Question, nil, nil, nil)
+		dns.Log.Errorf("resolve %v: %v", question, err)
+		var resolveErr *dns.ResolveError
+		if !errors.As(err, &resolveErr) {
+			resolveErr = &dns.ResolveError{Rcode: dns.SERVFAIL, InfoCode: dns.EDEOtherError, Err: err}
+		}
+		res.Header.SetRcode(resolveErr.Rcode)
+		setClientSubnet(res, req, 0)
+		if opt := req.IsEDNS0(); opt != nil {
+			res.SetEDNS0(dns.UDPSize, opt.DO)
+			if ede, ok := resolveErr.ExtendedError(); ok {
+				res.IsEDNS0().SetOption(ede)
+			}
+		}
+		return res, nil
 	}
 	sort.Slice(rrs, func(i, j int) bool {
 		return rrs[i].RData.String() < rrs[j].RData.String()
 	})
-	vals := dns.QR | dns.RD | dns.RA | dns.NOERROR
 	if ad {
 		// DNSSEC verification succeeded
-		vals |= dns.AD
+		res.Header.SetFlag(dns.AD, true)
 	}
-	var additionals []dns.ResourceRecord
 	var do bool
-	if len(req.AdditionalResourceRecords) != 0 {
-		rr := req.AdditionalResourceRecords[0]
-		if rr.Type == dns.TypeOPT {
-			var ttl uint32
-			ttl |= uint32(rr.TTL) & (1 << 15) // copy DO bit
-			do = 0 < ttl
-			opt := dns.ResourceRecord{
-				Type:  dns.TypeOPT,
-				Class: dns.UDPSize, // UDP payload size
-				TTL:   dns.TTL(ttl),
-			}
-			additionals = append(additionals, opt)
-		}
+	if opt := req.IsEDNS0(); opt != nil {
+		do = opt.DO // copy DO bit
+		res.SetEDNS0(dns.UDPSize, do)
 	}
 	if !do {
 		for i, v := range rrs {
-			if req.Question.Type != dns.TypeRRSIG && v.Type == dns.TypeRRSIG {
+			if question.Type != dns.TypeRRSIG && v.Type == dns.TypeRRSIG {
 				rrs = rrs[:i+copy(rrs[i:], rrs[i+1:])]
 			}
 		}
 	}
-	return dns.MakeResponse(req.Header.ID,
-		dns.MakeHeaderFields(req.Header.Opcode(), vals),
-		req.Question, rrs, nil, additionals)
+	res.AnswerResourceRecords = rrs
+	setClientSubnet(res, req, scope)
+	return res, nil
 }
 
-func handleConnection(conn net.PacketConn, addr net.Addr, req []byte, requestHandler RequestHandler) {
+var (
+	cookieSecret   *dns.CookieSecret
+	cookieRequired bool
+)
+
+// serverCookie verifies the cookie of req from addr. It returns the cookie
+// for the response, or nil if req has no cookie, and whether the server
+// cookie of req is valid.
+func serverCookie(req *dns.Msg, addr net.Addr) (*dns.EDNS0Cookie, bool) {
+	opt := req.IsEDNS0()
+	if opt == nil || cookieSecret == nil {
+		return nil, false
+	}
+	reqCookie, ok := opt.Option(dns.EDNS0CodeCookie).(dns.EDNS0Cookie)
+	if !ok {
+		return nil, false
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return nil, false
+	}
+	clientIP := addrPort.Addr()
+	now := time.Now()
+	valid, renew := cookieSecret.VerifyServerCookie(reqCookie.Client, reqCookie.Server, clientIP, now)
+	cookie := &dns.EDNS0Cookie{Client: reqCookie.Client, Server: reqCookie.Server}
+	if renew {
+		cookie.Server = cookieSecret.ServerCookie(reqCookie.Client, clientIP, now)
+	}
+	return cookie, valid
+}
+
+// handleRequest returns the parsed request and the response to req from
+// addr over network. The response is nil to drop it, and the request is nil
+// if it is malformed. Valid server cookies are not required over TCP, which
+// has proven the address of the client (RFC 7873 Section 5.2.3).
+func handleRequest(network string, addr net.Addr, req []byte, requestHandler RequestHandler) (*dns.Msg, *dns.Msg) {
 	var (
-		bytes    []byte
 		err      error
-		request  *dns.Request
-		response *dns.Response
+		request  = new(dns.Msg)
+		response *dns.Msg
 	)
 
-	request, err = dns.ParseRequest(req)
+	err = request.Unpack(req)
 	if err != nil {
 		dns.Log.Error(err)
-		return
+		if len(req) < 12 || request.Header.Flag(dns.QR) {
+			return nil, nil
+		}
+		return nil, new(dns.Msg).SetRcode(request, dns.FORMERR)
+	}
+
+	if request.Header.Flag(dns.QR) {
+		return request, nil
 	}
+	cookie, validCookie := serverCookie(request, addr)
+	if opt := request.IsEDNS0(); opt != nil && opt.Version != 0 {
+		response = new(dns.Msg).SetRcode(request, dns.BADVERS)
+	} else if request.Header.Opcode() != dns.OpcodeQuery {
+		response = new(dns.Msg).SetRcode(request, dns.NOTIMP)
+	} else if len(request.Questions) != 1 {
+		response = new(dns.Msg).SetRcode(request, dns.FORMERR)
+	} else if cookie != nil && !validCookie && cookieRequired && network != "tcp" {
+		// answer only after the client has learned the server cookie
+		response = new(dns.Msg).SetRcode(request, dns.BADCOOKIE)
+	} else {
+		response, err = requestHandler(request, addr)
+		if err != nil {
+			dns.Log.Error(err)
+			return request, nil
+		}
+	}
+	if opt := request.IsEDNS0(); opt != nil {
+		response.SetEDNS0(dns.UDPSize, opt.DO)
+		if cookie != nil {
+			response.IsEDNS0().SetOption(*cookie)
+		}
+	}
+	return request, response
+}
 
-	response, err = requestHandler(*request)
+func handleConnection(conn net.PacketConn, addr net.Addr, req []byte, requestHandler RequestHandler) {
+	request, response := handleRequest("udp", addr, req, requestHandler)
+	if response == nil {
+		return
+	}
+	size := 512
+	if request != nil {
+		size = dns.MaxUDPSize(request)
+	}
+	err := response.Truncate(size)
 	if err != nil {
 		dns.Log.Error(err)
 		return
 	}
-	bytes, err = response.Bytes()
+	bytes, err := response.Pack()
 	if err != nil {
 		dns.Log.Error(err)
 		return
@@ -168,29 +423,104 @@ func main() {
 	var address string
 	var zone string
 	var rootAnchorsXML string
+	var secret string
+	var configPath string
 
 	flag.StringVar(&address, "address", "", "")
 	flag.StringVar(&mode, "mode", "", "")
 	flag.StringVar(&zone, "zone", "", "")
 	flag.StringVar(&rootAnchorsXML, "root-anchors-xml", "", "")
+	flag.StringVar(&configPath, "config", "", "")
+	flag.StringVar(&secret, "cookie-secret", "", "")
+	flag.BoolVar(&cookieRequired, "cookie-required", false, "")
+	flag.DurationVar(&tcpIdleTimeout, "tcp-idle-timeout", tcpIdleTimeout, "")
+	flag.IntVar(&tcpMaxConns, "tcp-max-conns", tcpMaxConns, "")
+	flag.Func("ecs-servers", "", func(s string) error {
+		for _, v := range strings.Split(s, ",") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				addr, err := netip.ParseAddr(v)
+				if err != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			dns.ECSServers = append(dns.ECSServers, prefix)
+		}
+		return nil
+	})
 	flag.Parse()
 
-	conn, err := net.ListenPacket("udp", address)
+	// the flags override the configuration file
+	conf := new(config)
+	if configPath != "" {
+		var err error
+		conf, err = readConfig(configPath)
+		if err != nil {
+			dns.Log.Error(err)
+			os.Exit(1)
+		}
+	}
+	if address != "" || len(conf.Addresses) == 0 {
+		conf.Addresses = []string{address}
+	}
+	if mode != "" {
+		conf.Mode = mode
+	}
+	if rootAnchorsXML != "" {
+		conf.RootAnchorsXML = rootAnchorsXML
+	}
+	if zone != "" {
+		if conf.Mode == "authoritative" {
+			conf.Zones = []zoneConfig{{File: zone}}
+		} else {
+			conf.RootHints = zone
+		}
+	}
+
+	var err error
+	if secret == "" {
+		cookieSecret, err = dns.NewCookieSecret()
+	} else {
+		cookieSecret, err = dns.ParseCookieSecret(secret)
+	}
 	if err != nil {
 		dns.Log.Error(err)
 		os.Exit(1)
 	}
 
 	requestHandler := resolver
-	if mode == "authoritative" {
-		loadZonefiles(zone)
+	if conf.Mode == "authoritative" {
+		if err := loadZones(conf.Zones); err != nil {
+			dns.Log.Error(err)
+			os.Exit(1)
+		}
 		requestHandler = authoritativeServer
 	} else {
-		dns.SetUpResolver(zone, rootAnchorsXML)
+		dns.SetUpResolver(conf.RootHints, conf.RootAnchorsXML)
 	}
 
+	for _, address := range conf.Addresses {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			dns.Log.Error(err)
+			os.Exit(1)
+		}
+		listener, err := net.Listen("tcp", conn.LocalAddr().String())
+		if err != nil {
+			dns.Log.Error(err)
+			os.Exit(1)
+		}
+		go serveUDP(conn, requestHandler)
+		go serveTCP(listener, requestHandler)
+	}
+	select {}
+}
+
+// serveUDP handles the queries on conn.
+func serveUDP(conn net.PacketConn, requestHandler RequestHandler) {
 	for {
-		buf := make([]byte, 1500)
+		buf := make([]byte, 0xFFFF)
 		n, addr, err := conn.ReadFrom(buf[:])
 		if err != nil {
 			dns.Log.Error(err)
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
 	"testing"
+	"time"
 	"try/dns"
 )
 
@@ -10,20 +15,11 @@ func TestResolve(t *testing.T) {
 	dns.Log.SetLogLevel(dns.LogLevelDebug)
 	dns.SetUpResolver("../../root_files/named.root", "../../root_files/root-anchors.xml")
 
-	req := dns.Request{
-		Header: dns.Header{
-			ID:      0,
-			Fields:  0,
-			QDCount: 1,
-			ANCount: 0,
-			NSCount: 0,
-			ARCount: 0,
-		},
-		Question:                  dns.Question{Name: dns.Name("example.com."), Type: dns.TypeA, Class: dns.ClassIN},
-		AdditionalResourceRecords: nil,
-		MsgSize:                   0,
+	req, err := new(dns.Msg).SetQuestion(dns.Name("example.com."), dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
 	}
-	res, err := resolver(req)
+	res, err := resolver(req, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,3 +28,290 @@ func TestResolve(t *testing.T) {
 		t.Fatal("no answers")
 	}
 }
+
+func TestCookie(t *testing.T) {
+	var err error
+	cookieSecret, err = dns.NewCookieSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieRequired = true
+	defer func() { cookieSecret, cookieRequired = nil, false }()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var handled atomic.Int32
+	handler := func(req *dns.Msg, addr net.Addr) (*dns.Msg, error) {
+		handled.Add(1)
+		return new(dns.Msg).SetReply(req), nil
+	}
+	go func() {
+		for {
+			buf := make([]byte, 1500)
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			handleConnection(conn, addr, buf[:n], handler)
+		}
+	}()
+
+	client := dns.BasicClient{}
+	question := dns.Question{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassIN}
+	address := conn.LocalAddr().String()
+	for i := 1; i <= 2; i++ {
+		// BADCOOKIE and retry at first, then answered with the learned cookie
+		res, err := client.Do("udp", address, question, false, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Rcode() != dns.NOERROR || handled.Load() != int32(i) {
+			t.Fatal(res.Rcode(), handled.Load())
+		}
+		cookie, ok := res.IsEDNS0().Option(dns.EDNS0CodeCookie).(dns.EDNS0Cookie)
+		if !ok || len(cookie.Server) != 16 {
+			t.Fatal(res.IsEDNS0())
+		}
+	}
+}
+
+func TestHandleRequestCookie(t *testing.T) {
+	var err error
+	cookieSecret, err = dns.NewCookieSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieRequired = true
+	defer func() { cookieSecret, cookieRequired = nil, false }()
+
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	handler := func(req *dns.Msg, addr net.Addr) (*dns.Msg, error) {
+		return new(dns.Msg).SetReply(req), nil
+	}
+	clientCookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	serverCookie := cookieSecret.ServerCookie(clientCookie, netip.MustParseAddr("192.0.2.1"), time.Now())
+	data := []struct {
+		network  string
+		cookie   *dns.EDNS0Cookie
+		expected uint16
+	}{
+		{"udp", nil, dns.NOERROR},
+		{"udp", &dns.EDNS0Cookie{Client: clientCookie}, dns.BADCOOKIE},
+		{"udp", &dns.EDNS0Cookie{Client: clientCookie, Server: serverCookie}, dns.NOERROR},
+		{"udp", &dns.EDNS0Cookie{Client: clientCookie, Server: make([]byte, 16)}, dns.BADCOOKIE},
+		// TCP has proven the client address
+		{"tcp", &dns.EDNS0Cookie{Client: clientCookie}, dns.NOERROR},
+		{"tcp", &dns.EDNS0Cookie{Client: clientCookie, Server: make([]byte, 16)}, dns.NOERROR},
+	}
+	for _, v := range data {
+		req, err := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetEDNS0(dns.UDPSize, false)
+		if v.cookie != nil {
+			req.IsEDNS0().SetOption(*v.cookie)
+		}
+		b, err := req.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, res := handleRequest(v.network, addr, b, handler)
+		if res.Rcode() != v.expected {
+			t.Error(v.network, v.cookie, res.Rcode())
+		}
+		if v.cookie != nil {
+			cookie, ok := res.IsEDNS0().Option(dns.EDNS0CodeCookie).(dns.EDNS0Cookie)
+			if !ok || !bytes.Equal(cookie.Client, clientCookie) || len(cookie.Server) != 16 {
+				t.Error(v.cookie, res.IsEDNS0())
+			}
+		}
+	}
+
+	// malformed cookie
+	req, _ := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	req.SetEDNS0(dns.UDPSize, false)
+	req.IsEDNS0().SetOption(dns.EDNS0Unknown{OptionCode: dns.EDNS0CodeCookie, Data: []byte{1, 2, 3}})
+	b, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, res := handleRequest("udp", addr, b, handler); res == nil || res.Rcode() != dns.FORMERR {
+		t.Error(res)
+	}
+}
+
+func TestAuthoritativeClientSubnet(t *testing.T) {
+	err := loadZones([]zoneConfig{{File: "../../testdata/zones/example.com.zone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []dns.Name{"example.com.", "nonexistent.example.com."} {
+		req, err := new(dns.Msg).SetQuestion(name, dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetEDNS0(dns.UDPSize, false)
+		ecs := dns.NewEDNS0ClientSubnet(netip.MustParsePrefix("198.51.100.0/24"))
+		req.IsEDNS0().SetOption(ecs)
+		res, err := authoritativeServer(req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.IsEDNS0() == nil || res.IsEDNS0().Option(dns.EDNS0CodeClientSubnet) != ecs {
+			t.Error(name, res.IsEDNS0())
+		}
+	}
+}
+
+func TestAuthoritativeCase(t *testing.T) {
+	err := loadZones([]zoneConfig{{File: "../../testdata/zones/example.com.zone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := new(dns.Msg).SetQuestion("WWW.Example.COM.", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := authoritativeServer(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rcode() != dns.NOERROR || len(res.AnswerResourceRecords) == 0 || res.Questions[0].Name != "WWW.Example.COM." {
+		t.Error(res)
+	}
+}
+
+func TestAuthoritativeAdditionals(t *testing.T) {
+	err := loadZones([]zoneConfig{{File: "../../testdata/zones/example.com.zone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		name     dns.Name
+		type_    dns.Type
+		expected []string
+	}{
+		{"example.com.", dns.TypeMX, []string{
+			"mx1.example.com. 3600 IN A 192.0.2.3",
+			"mx2.example.com. 3600 IN A 192.0.2.4",
+			"mx1.example.com. 3600 IN AAAA 2001:db8::3",
+			"mx2.example.com. 3600 IN AAAA 2001:db8::4",
+		}},
+		{"_sip._udp.example.com.", dns.TypeSRV, []string{
+			"sip.example.com. 3600 IN A 192.0.2.5",
+			"sip.example.com. 3600 IN AAAA 2001:db8::5",
+		}},
+		{"example.com.", dns.TypeNAPTR, []string{
+			"_sip._udp.example.com. 3600 IN SRV 10 60 5060 sip.example.com.",
+			"sip.example.com. 3600 IN A 192.0.2.5",
+			"sip.example.com. 3600 IN AAAA 2001:db8::5",
+		}},
+		{"example.com.", dns.TypeHTTPS, []string{
+			"example.com. 600 IN A 192.0.2.1",
+			"example.com. 600 IN A 192.0.2.2",
+			"example.com. 600 IN AAAA 2001:db8::1",
+			"example.com. 600 IN AAAA 2001:db8::2",
+		}},
+		{"www2.example.com.", dns.TypeHTTPS, []string{
+			"svc.example.com. 3600 IN HTTPS 1 sip.example.com. port=8443",
+			"sip.example.com. 3600 IN A 192.0.2.5",
+			"sip.example.com. 3600 IN AAAA 2001:db8::5",
+		}},
+	}
+	for _, v := range data {
+		req, err := new(dns.Msg).SetQuestion(v.name, v.type_)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := authoritativeServer(req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.AdditionalResourceRecords) != len(v.expected) {
+			t.Fatal(v.type_, res.AdditionalResourceRecords)
+		}
+		for i, rr := range res.AdditionalResourceRecords {
+			if s := rr.String(); s != v.expected[i] {
+				t.Error(v.type_, s)
+			}
+		}
+	}
+}
+
+func TestAuthoritativeZones(t *testing.T) {
+	conf, err := readConfig("../../testdata/serv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Zones = append(conf.Zones, zoneConfig{Name: "sub.example.com", File: writeZonefile(t, `$TTL 3600
+@   SOA ns1.example.com. hostmaster.example.com. 1 7200 1800 1209600 86400
+@   NS  ns1.example.com.
+@   MX  10 www
+www A   192.0.2.9
+`), MinimalResponses: true})
+	if err := loadZones(conf.Zones); err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		name      dns.Name
+		type_     dns.Type
+		rcode     uint16
+		answer    string
+		authority string
+	}{
+		{"MX1.example.com.", dns.TypeA, dns.NOERROR, "mx1.example.com. 3600 IN A 192.0.2.3", "example.com. 3600 IN NS ns1.example.com."},
+		{"2.2.0.192.in-addr.arpa.", dns.TypePTR, dns.NOERROR, "2.2.0.192.in-addr.arpa. 3600 IN PTR mx1.example.com.", "2.0.192.in-addr.arpa. 3600 IN NS ns1.example.com."},
+		{"3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", dns.TypePTR, dns.NOERROR, "3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 3600 IN PTR mx2.example.com.", "8.b.d.0.1.0.0.2.ip6.arpa. 3600 IN NS ns1.example.com."},
+		{"WWW.Sub.Example.COM.", dns.TypeA, dns.NOERROR, "www.sub.example.com. 3600 IN A 192.0.2.9", "sub.example.com. 3600 IN NS ns1.example.com."},
+		{"nonexistent.example.com.", dns.TypeA, dns.NXDOMAIN, "", ""},
+		{"example.org.", dns.TypeA, dns.REFUSED, "", ""},
+		{"com.", dns.TypeNS, dns.REFUSED, "", ""},
+	}
+	for _, v := range data {
+		req, err := new(dns.Msg).SetQuestion(v.name, v.type_)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := authoritativeServer(req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Rcode() != v.rcode || res.Header.Flag(dns.AA) != (v.rcode == dns.NOERROR) {
+			t.Error(v.name, res)
+			continue
+		}
+		if v.answer == "" {
+			continue
+		}
+		if len(res.AnswerResourceRecords) == 0 || res.AnswerResourceRecords[0].String() != v.answer {
+			t.Error(v.name, res.AnswerResourceRecords)
+		}
+		if len(res.AuthorityResourceRecords) == 0 || res.AuthorityResourceRecords[0].String() != v.authority {
+			t.Error(v.name, res.AuthorityResourceRecords)
+		}
+	}
+
+	// minimal-responses
+	req, _ := new(dns.Msg).SetQuestion("sub.example.com.", dns.TypeMX)
+	res, err := authoritativeServer(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.AnswerResourceRecords) != 1 || len(res.AdditionalResourceRecords) != 0 {
+		t.Error(res)
+	}
+
+	for _, v := range [][]zoneConfig{
+		{{File: "../../testdata/zones/example.com.zone"}, {Name: "example.com.", File: "../../testdata/zones/example.com.zone"}},
+		{{Name: "example.org", File: "../../testdata/zones/example.com.zone"}},
+		{{File: "../../testdata/zones/2.0.192.in-addr.arpa.zone"}},
+	} {
+		if err := loadZones(v); err == nil {
+			t.Error(v)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"try/dns"
+)
+
+var (
+	tcpIdleTimeout = 10 * time.Second
+	tcpMaxConns    = 100
+)
+
+// tcpMaxPipelined is the number of queries handled at once per connection.
+const tcpMaxPipelined = 16
+
+// serveTCP accepts connections up to tcpMaxConns at once until listener is
+// closed.
+func serveTCP(listener net.Listener, requestHandler RequestHandler) {
+	conns := make(chan struct{}, tcpMaxConns)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			dns.Log.Error(err)
+			continue
+		}
+		select {
+		case conns <- struct{}{}:
+			go func() {
+				handleTCPConnection(conn, requestHandler)
+				<-conns
+			}()
+		default:
+			dns.Log.Warnf("%v: too many connections", conn.RemoteAddr())
+			conn.Close()
+		}
+	}
+}
+
+// handleTCPConnection handles the pipelined queries on conn, each in its own
+// goroutine, so the responses may be out of order. conn is closed after
+// tcpIdleTimeout without queries.
+func handleTCPConnection(conn net.Conn, requestHandler RequestHandler) {
+	defer conn.Close()
+	addr := conn.RemoteAddr()
+	var (
+		mu        sync.Mutex // for writing
+		wg        sync.WaitGroup
+		pipelined = make(chan struct{}, tcpMaxPipelined)
+	)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		req, err := dns.ReadTCPMsg(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				dns.Log.Debugf("%v: %v", addr, err)
+			}
+			break
+		}
+		pipelined <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-pipelined
+				wg.Done()
+			}()
+			bytes, err := tcpResponse(addr, req, requestHandler)
+			if err != nil {
+				dns.Log.Error(err)
+				return
+			}
+			if bytes == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(tcpIdleTimeout))
+			_, err = conn.Write(bytes)
+			if err != nil {
+				dns.Log.Error(err)
+				return
+			}
+			dns.Log.Infof("%v tcp %v", addr, len(bytes)-2)
+		}()
+	}
+	wg.Wait()
+}
+
+// tcpResponse returns the length-prefixed response to req, or nil to drop
+// it. The idle timeout is sent in the TCP keepalive option (RFC 7828) to
+// the clients asking for it.
+func tcpResponse(addr net.Addr, req []byte, requestHandler RequestHandler) ([]byte, error) {
+	request, response := handleRequest("tcp", addr, req, requestHandler)
+	if response == nil {
+		return nil, nil
+	}
+	if request != nil && request.IsEDNS0() != nil {
+		opt := request.IsEDNS0()
+		if keepalive, ok := opt.Option(dns.EDNS0CodeTCPKeepalive).(dns.EDNS0TCPKeepalive); ok {
+			if keepalive.HasTimeout {
+				// clients must not send a timeout
+				response = new(dns.Msg).SetRcode(request, dns.FORMERR)
+				response.SetEDNS0(dns.UDPSize, opt.DO)
+			}
+			timeout := tcpIdleTimeout / (100 * time.Millisecond)
+			if 0xFFFF < timeout {
+				timeout = 0xFFFF
+			}
+			response.IsEDNS0().SetOption(dns.EDNS0TCPKeepalive{HasTimeout: true, Timeout: uint16(timeout)})
+		}
+	}
+	err := response.Truncate(0xFFFF)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := response.PackBuffer([]byte{0, 0})
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(bytes, uint16(len(bytes)-2))
+	return bytes, nil
+}
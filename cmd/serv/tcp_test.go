@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"try/dns"
+)
+
+func startTCP(t *testing.T, requestHandler RequestHandler) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serveTCP(listener, requestHandler)
+	return listener.Addr().String()
+}
+
+func writeTCPMsg(t *testing.T, conn net.Conn, m *dns.Msg) {
+	b, err := m.PackBuffer([]byte{0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint16(b, uint16(len(b)-2))
+	_, err = conn.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTCPResponse(t *testing.T, conn net.Conn) *dns.Msg {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := dns.ReadTCPMsg(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := new(dns.Msg)
+	err = res.Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTCPPipelined(t *testing.T) {
+	address := startTCP(t, func(req *dns.Msg, addr net.Addr) (*dns.Msg, error) {
+		if req.Questions[0].Name == "slow.example." {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return new(dns.Msg).SetReply(req), nil
+	})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	slow, _ := new(dns.Msg).SetQuestion("slow.example.", dns.TypeA)
+	fast, _ := new(dns.Msg).SetQuestion("fast.example.", dns.TypeA)
+	fast.SetEDNS0(dns.UDPSize, false)
+	fast.IsEDNS0().SetOption(dns.EDNS0TCPKeepalive{})
+	writeTCPMsg(t, conn, slow)
+	writeTCPMsg(t, conn, fast)
+
+	// out of order
+	res := readTCPResponse(t, conn)
+	if res.Header.ID != fast.Header.ID {
+		t.Error(res.Questions)
+	}
+	keepalive, ok := res.IsEDNS0().Option(dns.EDNS0CodeTCPKeepalive).(dns.EDNS0TCPKeepalive)
+	if !ok || !keepalive.HasTimeout || keepalive.Timeout != uint16(tcpIdleTimeout/(100*time.Millisecond)) {
+		t.Error(res.IsEDNS0())
+	}
+	res = readTCPResponse(t, conn)
+	if res.Header.ID != slow.Header.ID || res.IsEDNS0() != nil {
+		t.Error(res)
+	}
+
+	// a timeout from the client
+	fast.IsEDNS0().SetOption(dns.EDNS0TCPKeepalive{HasTimeout: true, Timeout: 10})
+	writeTCPMsg(t, conn, fast)
+	if res := readTCPResponse(t, conn); res.Rcode() != dns.FORMERR {
+		t.Error(res.Rcode())
+	}
+}
+
+func TestTCPLimits(t *testing.T) {
+	idleTimeout, maxConns := tcpIdleTimeout, tcpMaxConns
+	tcpIdleTimeout, tcpMaxConns = 100*time.Millisecond, 1
+	defer func() { tcpIdleTimeout, tcpMaxConns = idleTimeout, maxConns }()
+
+	address := startTCP(t, func(req *dns.Msg, addr net.Addr) (*dns.Msg, error) {
+		return new(dns.Msg).SetReply(req), nil
+	})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req, _ := new(dns.Msg).SetQuestion("example.", dns.TypeA)
+	writeTCPMsg(t, conn, req)
+	readTCPResponse(t, conn)
+
+	// over the limit
+	conn2, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn2.Read(make([]byte, 1)); err == nil {
+		t.Error("not closed")
+	}
+
+	// idle
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("not closed")
+	}
+}
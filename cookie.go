@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"time"
+)
+
+// siphash24 returns SipHash-2-4 of msg.
+func siphash24(key [16]byte, msg []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[:])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	last := uint64(len(msg)) << 56
+	for ; 8 <= len(msg); msg = msg[8:] {
+		m := binary.LittleEndian.Uint64(msg)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i := len(msg) - 1; 0 <= i; i-- {
+		last |= uint64(msg[i]) << (8 * i)
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+const (
+	serverCookieVersion = 1
+	serverCookieLen     = 16
+
+	// validity of server cookies (RFC 9018 Section 4.3)
+	serverCookieLifetime = 3600
+	serverCookieRenewal  = 1800
+	serverCookieSkew     = 300
+)
+
+// CookieSecret is the server secret of interoperable server cookies
+// (RFC 9018).
+type CookieSecret [16]byte
+
+// NewCookieSecret returns a random secret.
+func NewCookieSecret() (*CookieSecret, error) {
+	secret := new(CookieSecret)
+	_, err := rand.Read(secret[:])
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ParseCookieSecret parses a secret in hex.
+func ParseCookieSecret(s string) (*CookieSecret, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	secret := new(CookieSecret)
+	if len(b) != len(secret) {
+		return nil, fmt.Errorf("cookie secret length: %v", len(b))
+	}
+	copy(secret[:], b)
+	return secret, nil
+}
+
+func (secret *CookieSecret) serverCookie(clientCookie []byte, clientIP netip.Addr, timestamp uint32) []byte {
+	cookie := make([]byte, 8, serverCookieLen)
+	cookie[0] = serverCookieVersion
+	binary.BigEndian.PutUint32(cookie[4:], timestamp)
+
+	input := append(append([]byte{}, clientCookie...), cookie...)
+	input = append(input, clientIP.Unmap().AsSlice()...)
+	return binary.LittleEndian.AppendUint64(cookie, siphash24(*secret, input))
+}
+
+// ServerCookie returns a server cookie for clientCookie from clientIP.
+func (secret *CookieSecret) ServerCookie(clientCookie []byte, clientIP netip.Addr, now time.Time) []byte {
+	return secret.serverCookie(clientCookie, clientIP, uint32(now.Unix()))
+}
+
+// VerifyServerCookie reports whether serverCookie was made with secret for
+// clientCookie from clientIP and has not expired. renew reports that the
+// cookie is old enough to be replaced in the response.
+func (secret *CookieSecret) VerifyServerCookie(clientCookie []byte, serverCookie []byte, clientIP netip.Addr, now time.Time) (valid bool, renew bool) {
+	if len(serverCookie) != serverCookieLen || serverCookie[0] != serverCookieVersion {
+		return false, true
+	}
+	timestamp := binary.BigEndian.Uint32(serverCookie[4:])
+	age := int32(uint32(now.Unix()) - timestamp) // serial number arithmetic
+	if age < -serverCookieSkew || serverCookieLifetime < age {
+		return false, true
+	}
+	expected := secret.serverCookie(clientCookie, clientIP, timestamp)
+	if subtle.ConstantTimeCompare(expected, serverCookie) != 1 {
+		return false, true
+	}
+	return true, serverCookieRenewal < age
+}
@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSiphash24(t *testing.T) {
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	data := []struct {
+		msg      []byte
+		expected uint64
+	}{
+		{nil, 0x726fdb47dd0e0e31},
+		{msg[:8], 0x93f5f5799a932462},
+		{msg, 0xa129ca6149be45e5},
+	}
+	for _, v := range data {
+		if actual := siphash24(key, v.msg); actual != v.expected {
+			t.Errorf("%x: %x", v.msg, actual)
+		}
+	}
+}
+
+func TestServerCookie(t *testing.T) {
+	// RFC 9018 Appendix A.1
+	secret, err := ParseCookieSecret("e5e973e5a6b2a43f48e7dc849e37bfcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCookie, _ := hex.DecodeString("2464c4abcf10c957")
+	clientIP := netip.MustParseAddr("198.51.100.100")
+	now := time.Unix(1559731985, 0)
+	expected, _ := hex.DecodeString("010000005cf79f111f8130c3eee29480")
+	cookie := secret.ServerCookie(clientCookie, clientIP, now)
+	if !bytes.Equal(cookie, expected) {
+		t.Errorf("%x", cookie)
+	}
+
+	data := []struct {
+		clientIP netip.Addr
+		now      time.Time
+		valid    bool
+		renew    bool
+	}{
+		{clientIP, now, true, false},
+		{clientIP, now.Add(-299 * time.Second), true, false},
+		{clientIP, now.Add(-301 * time.Second), false, true},
+		{clientIP, now.Add(1801 * time.Second), true, true},
+		{clientIP, now.Add(3601 * time.Second), false, true},
+		{netip.MustParseAddr("198.51.100.101"), now, false, true},
+	}
+	for _, v := range data {
+		valid, renew := secret.VerifyServerCookie(clientCookie, cookie, v.clientIP, v.now)
+		if valid != v.valid || renew != v.renew {
+			t.Error(v, valid, renew)
+		}
+	}
+	if valid, _ := secret.VerifyServerCookie(clientCookie, cookie[:8], clientIP, now); valid {
+		t.Error("short cookie")
+	}
+}
@@ -3,38 +3,157 @@ package dns
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
-	"crypto/sha256"
+	_ "crypto/sha1" // register the hash functions of the algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 	"encoding/binary"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"os"
 	"sort"
+	"time"
 )
 
-func verifySignature(pubkeyBytes []byte, message []byte, signature []byte) error {
-	pub := decodePublicKey(pubkeyBytes)
-	hashed := sha256.Sum256(message)
-	return rsa.VerifyPKCS1v15(&pub, crypto.SHA256, hashed[:], signature)
+// DNSSEC algorithm numbers (RFC 8624 Section 3.1).
+const (
+	AlgorithmRSASHA1          uint8 = 5
+	AlgorithmRSASHA1NSEC3SHA1 uint8 = 7
+	AlgorithmRSASHA256        uint8 = 8
+	AlgorithmRSASHA512        uint8 = 10
+	AlgorithmECDSAP256SHA256  uint8 = 13
+	AlgorithmECDSAP384SHA384  uint8 = 14
+	AlgorithmED25519          uint8 = 15
+)
+
+// ErrUnsupportedAlgorithm is returned for zones whose DS records have
+// algorithms or digest types that cannot be validated. Such zones are
+// treated as insecure rather than bogus (RFC 4035 Section 5.2).
+var ErrUnsupportedAlgorithm = errors.New("unsupported DNSSEC algorithm")
+
+var (
+	ErrSignatureExpired     = errors.New("signature expired")
+	ErrSignatureNotYetValid = errors.New("signature not yet valid")
+)
+
+// errRRSIGMissing is returned for RRsets without an RRSIG of a supported
+// algorithm.
+var errRRSIGMissing = errors.New("not found RRSIG with a supported algorithm")
+
+// Now returns the time at which the validity periods of signatures are
+// checked. It may be replaced to validate at another time.
+var Now = time.Now
+
+// bogusInfoCode returns the extended error code for err of a bogus
+// signature.
+func bogusInfoCode(err error) uint16 {
+	switch {
+	case errors.Is(err, ErrSignatureExpired):
+		return EDESignatureExpired
+	case errors.Is(err, ErrSignatureNotYetValid):
+		return EDESignatureNotYetValid
+	case errors.Is(err, errRRSIGMissing):
+		return EDERRSIGsMissing
+	}
+	return EDEDNSSECBogus
+}
+
+// SupportedAlgorithm reports whether signatures of algorithm can be
+// validated.
+func SupportedAlgorithm(algorithm uint8) bool {
+	switch algorithm {
+	case AlgorithmRSASHA1, AlgorithmRSASHA1NSEC3SHA1, AlgorithmRSASHA256, AlgorithmRSASHA512,
+		AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384, AlgorithmED25519:
+		return true
+	}
+	return false
+}
+
+func hashMessage(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
 }
 
-func decodePublicKey(key []byte) rsa.PublicKey {
+func verifySignature(algorithm uint8, pubkeyBytes []byte, message []byte, signature []byte) error {
+	switch algorithm {
+	case AlgorithmRSASHA1, AlgorithmRSASHA1NSEC3SHA1, AlgorithmRSASHA256, AlgorithmRSASHA512:
+		hash := crypto.SHA1
+		switch algorithm {
+		case AlgorithmRSASHA256:
+			hash = crypto.SHA256
+		case AlgorithmRSASHA512:
+			hash = crypto.SHA512
+		}
+		pub, err := decodePublicKey(pubkeyBytes)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, hashMessage(hash, message), signature)
+	case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+		// RFC 6605
+		curve, hash := elliptic.P256(), crypto.SHA256
+		if algorithm == AlgorithmECDSAP384SHA384 {
+			curve, hash = elliptic.P384(), crypto.SHA384
+		}
+		size := curve.Params().BitSize / 8
+		if len(pubkeyBytes) != 2*size {
+			return fmt.Errorf("invalid ECDSA public key length: %v", len(pubkeyBytes))
+		}
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid ECDSA signature length: %v", len(signature))
+		}
+		pub := ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(pubkeyBytes[:size]),
+			Y:     new(big.Int).SetBytes(pubkeyBytes[size:]),
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(&pub, hashMessage(hash, message), r, s) {
+			return fmt.Errorf("ecdsa: verification error")
+		}
+		return nil
+	case AlgorithmED25519:
+		// RFC 8080
+		if len(pubkeyBytes) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key length: %v", len(pubkeyBytes))
+		}
+		if !ed25519.Verify(ed25519.PublicKey(pubkeyBytes), message, signature) {
+			return fmt.Errorf("ed25519: verification error")
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrUnsupportedAlgorithm, algorithm)
+}
+
+func decodePublicKey(key []byte) (*rsa.PublicKey, error) {
 	// RFC 3110
-	var exponentLen uint16
-	var offset uint16
+	if len(key) < 3 {
+		return nil, fmt.Errorf("invalid RSA public key length: %v", len(key))
+	}
+	var exponentLen int
+	var offset int
 	if key[0] == 0 {
-		exponentLen = binary.BigEndian.Uint16(key[1:])
+		exponentLen = int(binary.BigEndian.Uint16(key[1:]))
 		offset = 3
 	} else {
-		exponentLen = uint16(key[0])
+		exponentLen = int(key[0])
 		offset = 1
 	}
-	return rsa.PublicKey{
+	if exponentLen == 0 || 4 < exponentLen || len(key) <= offset+exponentLen {
+		return nil, fmt.Errorf("invalid RSA public key")
+	}
+	return &rsa.PublicKey{
 		N: new(big.Int).SetBytes(key[offset+exponentLen:]),
 		E: int(new(big.Int).SetBytes(key[offset : offset+exponentLen]).Int64()),
-	}
+	}, nil
 }
 
 type TrustAnchor struct {
@@ -87,110 +206,261 @@ func readRootAnchorsXML(path string) (*TrustAnchor, error) {
 	return &trustAnchor, nil
 }
 
-func getRootAnchorDS(path string) (*DS, error) {
+// getRootAnchorDSs returns the DS records of the trust anchors valid at
+// now.
+func getRootAnchorDSs(path string, now time.Time) ([]DS, error) {
 	trustAnchor, err := readRootAnchorsXML(path)
 	if err != nil {
 		return nil, err
 	}
-	ds := trustAnchor.KeyDigests[len(trustAnchor.KeyDigests)-1].toDS()
-	return &ds, nil
+	var dss []DS
+	for _, v := range trustAnchor.KeyDigests {
+		if validFrom, err := time.Parse(time.RFC3339, v.ValidFrom); err == nil && now.Before(validFrom) {
+			continue
+		}
+		if validUntil, err := time.Parse(time.RFC3339, v.ValidUntil); err == nil && !now.Before(validUntil) {
+			continue
+		}
+		dss = append(dss, v.toDS())
+	}
+	if len(dss) == 0 {
+		return nil, fmt.Errorf("no valid trust anchor: %v", path)
+	}
+	return dss, nil
+}
+
+// usableDS reports whether the algorithm and the digest type of ds are
+// supported. The DS records of the others are ignored (RFC 6840 Section
+// 5.2).
+func usableDS(ds DS) bool {
+	_, ok := digestHash(ds.digestType)
+	return ok && SupportedAlgorithm(ds.algo)
 }
 
-func getZSK(name Name, nameServer string, dnssecDSs []DS, client Client) ([]byte, error) {
+// matchDS reports whether dnskey owned by name is the key that ds refers
+// to.
+func matchDS(name Name, dnskey DNSKEY, ds DS) bool {
+	if dnskey.KeyTag() != ds.keyTag || dnskey.Algo != ds.algo {
+		return false
+	}
+	digest, err := dnskey.Digest(name.String(), ds.digestType)
+	return err == nil && bytes.Equal(digest, ds.digest)
+}
+
+// getDNSKEYs returns the zone keys of name, whose DNSKEY RRset is signed
+// with a key that one of dnssecDSs refers to. The zone may have multiple
+// KSKs and ZSKs, or a single key for both. It returns
+// ErrUnsupportedAlgorithm if no DS is usable, which is decided from the
+// validated DS records only since the algorithms of RRSIGs are not
+// authenticated.
+func getDNSKEYs(name Name, nameServer string, dnssecDSs []DS, client Client) ([]DNSKEY, error) {
+	var usable []DS
+	for _, ds := range dnssecDSs {
+		if usableDS(ds) {
+			usable = append(usable, ds)
+		}
+	}
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("DS of %v: %w", name, ErrUnsupportedAlgorithm)
+	}
+
 	question := Question{name, TypeDNSKEY, ClassIN}
-	Log.Debugf("getZSK: send request: @%v %v", nameServer, question)
-	res, err := client.Do("udp", nameServer+":53", question, false, true, true)
+	Log.Debugf("getDNSKEYs: send request: @%v %v", nameServer, question)
+	res, err := query(client, "udp", nameServer+":53", question, false, true, true)
 	if err != nil {
-		return nil, err
+		return nil, queryError(nameServer, err)
 	}
 	answerRRSets := NewRRSets(res.AnswerResourceRecords)
-	dnskeyRRSet, ok := answerRRSets[question]
+	dnskeyRRSet, ok := answerRRSets.Get(question)
 	if !ok {
-		return nil, fmt.Errorf("not found DNSKEY")
+		return nil, newResolveError(SERVFAIL, EDEDNSKEYMissing, fmt.Errorf("not found DNSKEY"))
 	}
-	rrsigRRSet, ok := answerRRSets[Question{name, TypeRRSIG, ClassIN}]
+	rrsigRRSet, ok := answerRRSets.Get(Question{name, TypeRRSIG, ClassIN})
 	if !ok {
-		return nil, fmt.Errorf("not found RRSIG of DNSKey")
+		return nil, newResolveError(SERVFAIL, EDERRSIGsMissing, fmt.Errorf("not found RRSIG of DNSKey"))
 	}
 
-	var zskDNSKey, kskDNSKey RData
+	var keys, ksks []DNSKEY
 	for _, v := range dnskeyRRSet.RDatas {
-		switch v.(DNSKEY).Flags {
-		case 256:
-			zskDNSKey = v.(DNSKEY)
-		case 257:
-			kskDNSKey = v.(DNSKEY)
+		dnskey, ok := v.(DNSKEY)
+		if !ok || !dnskey.IsZoneKey() || dnskey.Proto != 3 {
+			continue
+		}
+		keys = append(keys, dnskey)
+		for _, ds := range usable {
+			if matchDS(name, dnskey, ds) {
+				ksks = append(ksks, dnskey)
+				break
+			}
 		}
 	}
-	if zskDNSKey == nil || kskDNSKey == nil {
-		return nil, fmt.Errorf("not found DNSKEY")
+	if len(ksks) == 0 {
+		return nil, newResolveError(SERVFAIL, EDEDNSKEYMissing, fmt.Errorf("not found DNSKEY matching DS of %v", name))
 	}
 
-	// vefiry KSK
-	digest, err := kskDNSKey.(DNSKEY).Digest(name.String())
+	// verify DNSKey
+	err = verifyRRSIGs(ksks, dnskeyRRSet, rrsigRRSet)
 	if err != nil {
-		return nil, err
-	}
-	for _, ds := range dnssecDSs {
-		if bytes.Equal(digest, ds.digest) { // TODO
-			goto VERIFY
-		}
+		return nil, newResolveError(SERVFAIL, bogusInfoCode(err), err)
 	}
-	return nil, fmt.Errorf("error KSK")
-
-VERIFY:
+	return keys, nil
+}
 
-	// verify DNSKey
+// verifyRRSIGs verifies rrSet with the RRSIGs in rrsigRRSet that cover it
+// until one succeeds, using the key in keys with the key tag and the
+// algorithm of each RRSIG. The RRSIGs with unsupported algorithms or out of
+// their validity periods at Now are skipped, so rrSet is bogus if only such
+// RRSIGs cover it.
+func verifyRRSIGs(keys []DNSKEY, rrSet *RRSet, rrsigRRSet *RRSet) error {
+	err := fmt.Errorf("%w: covering %v", errRRSIGMissing, rrSet.Type)
+	supported := false
 	for _, v := range rrsigRRSet.RDatas {
-		err = verifyRRSet(kskDNSKey.(DNSKEY).Key, dnskeyRRSet, v.(RRSIG))
-		if err == nil {
-			break
+		rrsig, ok := v.(RRSIG)
+		if !ok || rrsig.TypeCovered != rrSet.Type || !SupportedAlgorithm(rrsig.Algo) {
+			continue
+		}
+		if !supported {
+			err = fmt.Errorf("not found DNSKEY with key tag %v", rrsig.KeyTag)
+		}
+		supported = true
+		if periodErr := rrsig.CheckValidityPeriod(Now()); periodErr != nil {
+			err = periodErr
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != rrsig.KeyTag || key.Algo != rrsig.Algo {
+				continue
+			}
+			err = verifyRRSet(key.Key, rrSet, rrsig)
+			if err == nil {
+				return nil
+			}
 		}
 	}
+	return err
+}
+
+func verifyRRSet(key []byte, rrSet *RRSet, rrsig RRSIG) error {
+	message, err := signedData(rrSet, rrsig)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	err = verifySignature(rrsig.Algo, key, message, rrsig.Signature)
+	if err != nil {
+		return fmt.Errorf("failed verifyRRSet(key: %x, rrSet: %v, rrsig: %v) error: %w", key, rrSet, rrsig, err)
+	}
+	return nil
+}
+
+// CheckValidityPeriod checks that now is in the validity period of rrsig,
+// comparing the times in serial number arithmetic (RFC 4034 Section 3.1.5).
+// It returns ErrSignatureExpired or ErrSignatureNotYetValid otherwise.
+func (rrsig RRSIG) CheckValidityPeriod(now time.Time) error {
+	t := uint32(now.Unix())
+	if int32(t-rrsig.SignatureInception) < 0 {
+		return fmt.Errorf("%w: inception %v", ErrSignatureNotYetValid, time.Unix(int64(rrsig.SignatureInception), 0).UTC().Format(TimeLayout))
+	}
+	if int32(rrsig.SignatureExpiration-t) < 0 {
+		return fmt.Errorf("%w: expiration %v", ErrSignatureExpired, time.Unix(int64(rrsig.SignatureExpiration), 0).UTC().Format(TimeLayout))
 	}
+	return nil
+}
 
-	return zskDNSKey.(DNSKEY).Key, nil
+// CanonicalRData returns rdata with the domain names lowercased for the
+// types listed in RFC 4034 Section 6.2, as amended by RFC 6840 Section 5.1.
+func CanonicalRData(rdata RData) RData {
+	switch v := rdata.(type) {
+	case Name: // NS, CNAME and PTR
+		return v.Canonical()
+	case SOA:
+		v.mname = v.mname.Canonical()
+		v.rname = v.rname.Canonical()
+		return v
+	case MX:
+		v.Exchange = Name(v.Exchange).Canonical().String()
+		return v
+	case SRV:
+		v.Target = Name(v.Target).Canonical().String()
+		return v
+	case NAPTR:
+		v.Replacement = Name(v.Replacement).Canonical().String()
+		return v
+	case RRSIG:
+		v.SignerName = v.SignerName.Canonical()
+		return v
+	}
+	return rdata
 }
 
-func verifyRRSet(key []byte, rrSet *RRSet, rrsig RRSIG) error {
+// signedOwnerName returns the owner name that rrsig signs, which is the
+// wildcard name if rrsig has fewer labels than name (RFC 4035 Section
+// 5.3.2).
+func signedOwnerName(name Name, rrsig RRSIG) (Name, error) {
+	canonical := name.Canonical()
+	labels, starts, err := splitName(canonical.String())
+	if err != nil {
+		return "", err
+	}
+	count := len(labels)
+	if 0 < count && labels[0] == "*" {
+		// the wildcard label is not counted
+		count--
+	}
+	n := int(rrsig.Labels)
+	switch {
+	case count < n:
+		return "", fmt.Errorf("RRSIG labels %v exceed %v", n, name)
+	case n == count:
+		return canonical, nil
+	case n == 0:
+		return "*.", nil
+	}
+	return "*." + canonical[starts[len(starts)-n]:], nil
+}
+
+// signedData returns the data that rrsig signs for rrSet, the RRSIG RDATA
+// without the signature followed by the records in the canonical form and
+// order without duplicates (RFC 4034 Sections 3.1.8.1 and 6).
+func signedData(rrSet *RRSet, rrsig RRSIG) ([]byte, error) {
 	message, err := rrsig.MarshalBinaryWithoutSig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// sort rdatas
 	var rdatas [][]byte
 	for _, v := range rrSet.RDatas {
-		b, err := v.MarshalBinary(nil)
+		b, err := CanonicalRData(v).MarshalBinary(nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		rdatas = append(rdatas, b)
 	}
 	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
 
 	// NAME + TYPE + CLASS + TTL + RDLENGTH
-	encoded, err := encodeName(rrSet.Name.String(), nil)
+	owner, err := signedOwnerName(rrSet.Name, rrsig)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	encoded, err := encodeName(owner.String())
+	if err != nil {
+		return nil, err
 	}
 	l := len(encoded)
 	first := make([]byte, l+10) // TYPE(2) + CLASS(2) + TTL(4) + RDLENGTH(2)
 	copy(first, encoded)
 	binary.BigEndian.PutUint16(first[l:], uint16(rrSet.Type))
 	binary.BigEndian.PutUint16(first[l+2:], uint16(rrSet.Class))
-	binary.BigEndian.PutUint32(first[l+4:], uint32(rrSet.TTL))
+	binary.BigEndian.PutUint32(first[l+4:], rrsig.OriginalTtl)
 
-	for _, v := range rdatas {
+	for i, v := range rdatas {
+		if 0 < i && bytes.Equal(v, rdatas[i-1]) {
+			continue
+		}
 		binary.BigEndian.PutUint16(first[l+8:], uint16(len(v)))
 		message = append(message, first...)
 		message = append(message, v...)
 	}
-	err = verifySignature(key, message, rrsig.Signature)
-	if err != nil {
-		return fmt.Errorf("failed verifyRRSet(key: %x, rrSet: %v, rrsig: %v) error: %w", key, rrSet, rrsig, err)
-	}
-	return nil
+	return message, nil
 }
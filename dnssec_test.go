@@ -1,9 +1,18 @@
 package dns
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestVerifySignature(t *testing.T) {
@@ -61,7 +70,7 @@ func TestVerifySignature(t *testing.T) {
 	}
 	message = append(message, b...)
 
-	err = verifySignature(kskDNSKey.Key, message, rrsig.Signature)
+	err = verifySignature(AlgorithmRSASHA256, kskDNSKey.Key, message, rrsig.Signature)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -97,17 +106,21 @@ func TestVerifySignature(t *testing.T) {
 		t.Fatal(err)
 	}
 	message = append(message, b...)
-	err = verifySignature(zskDNSKey.Key, message, rrsig.Signature)
+	err = verifySignature(AlgorithmRSASHA256, zskDNSKey.Key, message, rrsig.Signature)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestGetRootAnchorDS(t *testing.T) {
-	ds, err := getRootAnchorDS("root_files/root-anchors.xml")
+	dss, err := getRootAnchorDSs("root_files/root-anchors.xml", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(dss) != 1 || dss[0].keyTag != 20326 {
+		t.Fatal(dss)
+	}
+	ds := dss[0]
 	if fmt.Sprintf("%X", ds.digest) != "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D" {
 		t.Fatalf("actual: %X", ds.digest)
 	}
@@ -116,11 +129,10 @@ func TestGetRootAnchorDS(t *testing.T) {
 func TestVerifyRRSet(t *testing.T) {
 	client := &BasicClient{Limit: 20}
 	rootServer := "198.41.0.4"
-	rootDS, err := getRootAnchorDS("root_files/root-anchors.xml")
+	dnssecDSs, err := getRootAnchorDSs("root_files/root-anchors.xml", time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
-	dnssecDSs := []DS{*rootDS}
 	name := Name("com.")
 	question := Question{name, TypeNS, ClassIN}
 	res, err := client.Do("udp", rootServer+":53", question, false, true, true)
@@ -130,11 +142,11 @@ func TestVerifyRRSet(t *testing.T) {
 	authorityRRSets := NewRRSets(res.AuthorityResourceRecords)
 	dsRRSet := authorityRRSets[Question{name, TypeDS, ClassIN}]
 	rrsigRRSet := authorityRRSets[Question{name, TypeRRSIG, ClassIN}]
-	zsk, err := getZSK(name.parent(), rootServer, dnssecDSs, client)
+	keys, err := getDNSKEYs(name.parent(), rootServer, dnssecDSs, client)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = verifyRRSet(zsk, dsRRSet, rrsigRRSet.RDatas[0].(RRSIG))
+	err = verifyRRSIGs(keys, dsRRSet, rrsigRRSet)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -253,3 +265,391 @@ func TestVerifyRRSet4(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestVerifyRRSetTypes(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 3110
+	key := append([]byte{3, 1, 0, 1}, priv.N.Bytes()...)
+
+	data := []struct {
+		type_  Type
+		rdatas []RData
+	}{
+		{TypeCAA, []RData{CAA{0, "issue", "ca.example.net"}, CAA{128, "tbs", "Unknown"}}},
+		{TypeTLSA, []RData{TLSA{3, 1, 1, []byte{0xab, 0xcd}}, TLSA{2, 0, 1, []byte{0x01}}}},
+		{TypeSSHFP, []RData{SSHFP{4, 2, []byte{0x12, 0x34}}, SSHFP{1, 1, []byte{0x56}}}},
+	}
+	for _, v := range data {
+		rrSet := &RRSet{"example.com.", v.type_, ClassIN, 3600, v.rdatas}
+		reversed := &RRSet{"example.com.", v.type_, ClassIN, 3600, []RData{v.rdatas[1], v.rdatas[0]}}
+		rrsig := RRSIG{v.type_, 8, 2, 3600, 1700000000, 1690000000, 12345, "example.com.", nil}
+		message, err := signedData(rrSet, rrsig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashed := sha256.Sum256(message)
+		rrsig.Signature, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyRRSet(key, reversed, rrsig); err != nil {
+			t.Error(v.type_, err)
+		}
+		changed := &RRSet{"example.com.", v.type_, ClassIN, 3600, v.rdatas[:1]}
+		if err := verifyRRSet(key, changed, rrsig); err == nil {
+			t.Error(v.type_)
+		}
+	}
+}
+
+func TestVerifyRRSetAlgorithms(t *testing.T) {
+	// RFC 6605 Section 6.1 and RFC 8080 Section 6.1
+	data := []struct {
+		dnskey DNSKEY
+		rrSet  RRSet
+		rrsig  RRSIG
+	}{
+		{
+			mustParseDNSKEY("257 3 13 GojIhhXUN/u4v54ZQqGSnyhWJwaubCvTmeexv7bR6edbkrSqQpF64cYbcB7wNcP+e+MAnLr+Wi9xMWyQLc8NAA=="),
+			RRSet{"www.example.net.", TypeA, ClassIN, 3600, []RData{mustParseA("192.0.2.1")}},
+			mustParseRRSIG("A 13 3 3600 20100909100439 20100812100439 55648 example.net. qx6wLYqmh+l9oCKTN6qIc+bw6ya+KJ8oMz0YP107epXAyGmt+3SNruPFKG7tZoLBLlUzGGus7ZwmwWep666VCw=="),
+		},
+		{
+			mustParseDNSKEY("257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4="),
+			RRSet{"example.com.", TypeMX, ClassIN, 3600, []RData{MX{10, "mail.example.com."}}},
+			mustParseRRSIG("MX 15 2 3600 20150819220000 20150729220000 3613 example.com. oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QLs3fx8A4M3e23mRZ9VrbpMngwcrqNAg=="),
+		},
+	}
+	for _, v := range data {
+		if err := verifyRRSet(v.dnskey.Key, &v.rrSet, v.rrsig); err != nil {
+			t.Error(v.dnskey.Algo, err)
+		}
+	}
+}
+
+func TestVerifySignatureAlgorithms(t *testing.T) {
+	message := []byte("message")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub := append([]byte{3, 1, 0, 1}, rsaKey.N.Bytes()...)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSign := func(curve elliptic.Curve, hash crypto.Hash) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, hashMessage(hash, message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := curve.Params().BitSize / 8
+		pub := append(key.X.FillBytes(make([]byte, size)), key.Y.FillBytes(make([]byte, size))...)
+		return pub, append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	rsaSign := func(hash crypto.Hash) []byte {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, hash, hashMessage(hash, message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	p256Pub, p256Sig := ecdsaSign(elliptic.P256(), crypto.SHA256)
+	p384Pub, p384Sig := ecdsaSign(elliptic.P384(), crypto.SHA384)
+	data := []struct {
+		algorithm uint8
+		key       []byte
+		signature []byte
+	}{
+		{AlgorithmRSASHA1, rsaPub, rsaSign(crypto.SHA1)},
+		{AlgorithmRSASHA1NSEC3SHA1, rsaPub, rsaSign(crypto.SHA1)},
+		{AlgorithmRSASHA256, rsaPub, rsaSign(crypto.SHA256)},
+		{AlgorithmRSASHA512, rsaPub, rsaSign(crypto.SHA512)},
+		{AlgorithmECDSAP256SHA256, p256Pub, p256Sig},
+		{AlgorithmECDSAP384SHA384, p384Pub, p384Sig},
+		{AlgorithmED25519, edPub, ed25519.Sign(edKey, message)},
+	}
+	for _, v := range data {
+		if err := verifySignature(v.algorithm, v.key, message, v.signature); err != nil {
+			t.Error(v.algorithm, err)
+		}
+		if err := verifySignature(v.algorithm, v.key, []byte("changed"), v.signature); err == nil {
+			t.Error(v.algorithm)
+		}
+		if err := verifySignature(v.algorithm, v.key[:len(v.key)-1], message, v.signature); err == nil {
+			t.Error(v.algorithm)
+		}
+		if !SupportedAlgorithm(v.algorithm) {
+			t.Error(v.algorithm)
+		}
+	}
+	for _, v := range []uint8{1, 3, 6, 12, 16, 253} {
+		if err := verifySignature(v, edPub, message, nil); !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Error(v, err)
+		}
+	}
+	for _, v := range [][]byte{{}, {0}, {0, 0, 1, 3}, {1, 3}} {
+		if _, err := decodePublicKey(v); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+// setNow sets Now to now until the test ends.
+func setNow(t *testing.T, now time.Time) {
+	Now = func() time.Time { return now }
+	t.Cleanup(func() { Now = time.Now })
+}
+
+func TestVerifyRRSIGs(t *testing.T) {
+	setNow(t, time.Unix(1695000000, 0))
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnskey := DNSKEY{256, 3, AlgorithmED25519, pub}
+	keys := []DNSKEY{dnskey}
+	rrSet := &RRSet{"example.com.", TypeA, ClassIN, 3600, []RData{mustParseA("192.0.2.1")}}
+	sign := func(algorithm uint8) RRSIG {
+		rrsig := RRSIG{TypeA, algorithm, 2, 3600, 1700000000, 1690000000, dnskey.KeyTag(), "example.com.", nil}
+		message, err := signedData(rrSet, rrsig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrsig.Signature = ed25519.Sign(key, message)
+		return rrsig
+	}
+	// the algorithm of an RRSIG is not authenticated, so an RRSIG with an
+	// unsupported algorithm is missing rather than insecure
+	unsupported := sign(253)
+	rrsigRRSet := &RRSet{"example.com.", TypeRRSIG, ClassIN, 3600, []RData{unsupported}}
+	if err := verifyRRSIGs(keys, rrSet, rrsigRRSet); err == nil || errors.Is(err, ErrUnsupportedAlgorithm) || bogusInfoCode(err) != EDERRSIGsMissing {
+		t.Error(err)
+	}
+	rrsigRRSet.RDatas = append(rrsigRRSet.RDatas, sign(AlgorithmED25519))
+	if err := verifyRRSIGs(keys, rrSet, rrsigRRSet); err != nil {
+		t.Error(err)
+	}
+	if err := verifyRRSIGs(nil, rrSet, rrsigRRSet); err == nil || errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Error(err)
+	}
+	setNow(t, time.Unix(1700000001, 0))
+	if err := verifyRRSIGs(keys, rrSet, rrsigRRSet); !errors.Is(err, ErrSignatureExpired) || bogusInfoCode(err) != EDESignatureExpired {
+		t.Error(err)
+	}
+	setNow(t, time.Unix(1689999999, 0))
+	if err := verifyRRSIGs(keys, rrSet, rrsigRRSet); !errors.Is(err, ErrSignatureNotYetValid) || bogusInfoCode(err) != EDESignatureNotYetValid {
+		t.Error(err)
+	}
+	setNow(t, time.Unix(1695000000, 0))
+	bogus := sign(AlgorithmED25519)
+	bogus.Signature[0] ^= 1
+	rrsigRRSet.RDatas = []RData{unsupported, bogus}
+	if err := verifyRRSIGs(keys, rrSet, rrsigRRSet); err == nil || errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Error(err)
+	}
+}
+
+func TestGetDNSKEYs(t *testing.T) {
+	setNow(t, time.Unix(1695000000, 0))
+	type signer struct {
+		dnskey DNSKEY
+		key    ed25519.PrivateKey
+	}
+	newSigner := func(flags uint16) signer {
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer{DNSKEY{flags, 3, AlgorithmED25519, pub}, key}
+	}
+	name := Name("example.com.")
+	client := func(keys []signer, signers ...signer) funcClient {
+		rrSet := &RRSet{name, TypeDNSKEY, ClassIN, 3600, nil}
+		for _, v := range keys {
+			rrSet.RDatas = append(rrSet.RDatas, v.dnskey)
+		}
+		var rrs []ResourceRecord
+		for _, v := range rrSet.RDatas {
+			rrs = append(rrs, ResourceRecord{name, TypeDNSKEY, ClassIN, 3600, v})
+		}
+		for _, v := range signers {
+			rrsig := RRSIG{TypeDNSKEY, AlgorithmED25519, 2, 3600, 1700000000, 1690000000, v.dnskey.KeyTag(), name, nil}
+			message, err := signedData(rrSet, rrsig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rrsig.Signature = ed25519.Sign(v.key, message)
+			rrs = append(rrs, ResourceRecord{name, TypeRRSIG, ClassIN, 3600, rrsig})
+		}
+		return func(network string, address string, req *Msg) (*Msg, error) {
+			res := new(Msg).SetReply(req)
+			res.AnswerResourceRecords = rrs
+			return res, nil
+		}
+	}
+	toDS := func(s signer, digestType uint8) DS {
+		ds, err := s.dnskey.ToDS(name.String(), digestType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+
+	csk := newSigner(DNSKEYFlagZone | DNSKEYFlagSEP)
+	ksk1, ksk2 := newSigner(DNSKEYFlagZone|DNSKEYFlagSEP), newSigner(DNSKEYFlagZone|DNSKEYFlagSEP)
+	zsk1, zsk2 := newSigner(DNSKEYFlagZone), newSigner(DNSKEYFlagZone)
+	notZone := newSigner(DNSKEYFlagSEP)
+	data := []struct {
+		client Client
+		dss    []DS
+		keys   int
+	}{
+		{client([]signer{csk}, csk), []DS{toDS(csk, DigestSHA256)}, 1},
+		{client([]signer{ksk1, ksk2, zsk1, zsk2}, ksk2), []DS{toDS(ksk1, DigestSHA1), toDS(ksk2, DigestSHA384)}, 4},
+		{client([]signer{ksk1, ksk2, zsk1}, ksk1, ksk2), []DS{{ksk1.dnskey.KeyTag(), AlgorithmED25519, 99, nil}, toDS(ksk2, DigestSHA256)}, 3},
+		{client([]signer{csk, notZone}, csk), []DS{toDS(csk, DigestSHA256)}, 1},
+	}
+	for i, v := range data {
+		keys, err := getDNSKEYs(name, "192.0.2.53", v.dss, v.client)
+		if err != nil || len(keys) != v.keys {
+			t.Error(i, keys, err)
+		}
+	}
+
+	for i, v := range []struct {
+		client Client
+		dss    []DS
+	}{
+		{client([]signer{ksk1, zsk1}, ksk1), []DS{toDS(ksk2, DigestSHA256)}},       // no key matching DS
+		{client([]signer{ksk1, ksk2, zsk1}, ksk1), []DS{toDS(ksk2, DigestSHA256)}}, // signed with the other KSK
+		{client([]signer{notZone}, notZone), []DS{toDS(notZone, DigestSHA256)}},    // not a zone key
+		{client([]signer{ksk1, zsk1}, zsk1), []DS{toDS(ksk1, DigestSHA256)}},       // signed with ZSK only
+		{client([]signer{ksk1, zsk1}), []DS{toDS(ksk1, DigestSHA256)}},             // no RRSIG
+	} {
+		_, err := getDNSKEYs(name, "192.0.2.53", v.dss, v.client)
+		var resolveErr *ResolveError
+		if !errors.As(err, &resolveErr) || resolveErr.Rcode != SERVFAIL {
+			t.Error(i, err)
+		}
+	}
+
+	// RRSIGs with an unsupported algorithm for a trusted key
+	downgraded := func(network string, address string, req *Msg) (*Msg, error) {
+		res, err := client([]signer{csk}, csk)(network, address, req)
+		for i, rr := range res.AnswerResourceRecords {
+			if rrsig, ok := rr.RData.(RRSIG); ok {
+				rrsig.Algo = 253
+				res.AnswerResourceRecords[i].RData = rrsig
+			}
+		}
+		return res, err
+	}
+	_, err := getDNSKEYs(name, "192.0.2.53", []DS{toDS(csk, DigestSHA256)}, funcClient(downgraded))
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || resolveErr.Rcode != SERVFAIL || errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Error(err)
+	}
+
+	// DS records with unsupported algorithms or digest types only
+	for _, ds := range []DS{{csk.dnskey.KeyTag(), 253, DigestSHA256, nil}, {csk.dnskey.KeyTag(), AlgorithmED25519, 3, nil}} {
+		_, err := getDNSKEYs(name, "192.0.2.53", []DS{ds}, client([]signer{csk}, csk))
+		if !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Error(ds, err)
+		}
+	}
+}
+
+func TestCheckValidityPeriod(t *testing.T) {
+	data := []struct {
+		inception  uint32
+		expiration uint32
+		now        int64
+		err        error
+	}{
+		{1690000000, 1700000000, 1690000000, nil},
+		{1690000000, 1700000000, 1700000000, nil},
+		{1690000000, 1700000000, 1689999999, ErrSignatureNotYetValid},
+		{1690000000, 1700000000, 1700000001, ErrSignatureExpired},
+		// across the wrap-around of 32-bit times in 2106
+		{4294960000, 1000, 4294967296 + 500, nil},
+		{4294960000, 1000, 4294967296 + 1001, ErrSignatureExpired},
+	}
+	for _, v := range data {
+		rrsig := RRSIG{TypeA, AlgorithmED25519, 2, 3600, v.expiration, v.inception, 0, "example.com.", nil}
+		err := rrsig.CheckValidityPeriod(time.Unix(v.now, 0))
+		if !errors.Is(err, v.err) || (v.err == nil) != (err == nil) {
+			t.Error(v.now, err)
+		}
+	}
+}
+
+func TestSignedDataCanonical(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		signed   RRSet
+		labels   byte
+		received RRSet
+	}{
+		// names in RDATA are lowercased
+		{
+			RRSet{"example.com.", TypeMX, ClassIN, 3600, []RData{MX{10, "mail.example.com."}}},
+			2,
+			RRSet{"Example.COM.", TypeMX, ClassIN, 3600, []RData{MX{10, "MAIL.Example.com."}}},
+		},
+		{
+			RRSet{"example.com.", TypeNS, ClassIN, 3600, []RData{Name("ns1.example.com."), Name("ns2.example.com.")}},
+			2,
+			RRSet{"example.com.", TypeNS, ClassIN, 3600, []RData{Name("NS2.example.com."), Name("Ns1.Example.Com.")}},
+		},
+		{
+			RRSet{"_sip._udp.example.com.", TypeSRV, ClassIN, 3600, []RData{SRV{10, 60, 5060, "sip.example.com."}}},
+			4,
+			RRSet{"_sip._udp.example.com.", TypeSRV, ClassIN, 3600, []RData{SRV{10, 60, 5060, "SIP.example.com."}}},
+		},
+		// duplicates are removed and the original TTL is used
+		{
+			RRSet{"example.com.", TypeA, ClassIN, 3600, []RData{mustParseA("192.0.2.1"), mustParseA("192.0.2.2")}},
+			2,
+			RRSet{"example.com.", TypeA, ClassIN, 1234, []RData{mustParseA("192.0.2.2"), mustParseA("192.0.2.1"), mustParseA("192.0.2.2")}},
+		},
+		// expanded from a wildcard
+		{
+			RRSet{"*.example.com.", TypeA, ClassIN, 3600, []RData{mustParseA("192.0.2.1")}},
+			2,
+			RRSet{"a.b.Example.com.", TypeA, ClassIN, 3600, []RData{mustParseA("192.0.2.1")}},
+		},
+	}
+	for _, v := range data {
+		rrsig := RRSIG{v.signed.Type, AlgorithmED25519, v.labels, 3600, 1700000000, 1690000000, 0, "example.com.", nil}
+		message, err := signedData(&v.signed, rrsig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrsig.Signature = ed25519.Sign(key, message)
+		if err := verifyRRSet(pub, &v.received, rrsig); err != nil {
+			t.Error(v.received, err)
+		}
+	}
+
+	rrSet := &RRSet{"example.com.", TypeA, ClassIN, 3600, []RData{mustParseA("192.0.2.1")}}
+	rrsig := RRSIG{TypeA, AlgorithmED25519, 3, 3600, 1700000000, 1690000000, 0, "example.com.", nil}
+	if _, err := signedData(rrSet, rrsig); err == nil {
+		t.Error("labels")
+	}
+	// the names in NSEC RDATA are not lowercased
+	nsec := NSEC{"Host.example.com.", []Type{TypeA}}
+	if CanonicalRData(nsec).(NSEC).NextDomainName != "Host.example.com." {
+		t.Error(nsec)
+	}
+}
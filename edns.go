@@ -0,0 +1,431 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+	"unicode"
+)
+
+const (
+	EDNS0CodeNSID          uint16 = 3
+	EDNS0CodeClientSubnet  uint16 = 8
+	EDNS0CodeCookie        uint16 = 10
+	EDNS0CodeTCPKeepalive  uint16 = 11
+	EDNS0CodePadding       uint16 = 12
+	EDNS0CodeExtendedError uint16 = 15
+)
+
+// Extended RCODEs, which need the upper 8 bits carried in the OPT record.
+const (
+	BADVERS   uint16 = 16
+	BADCOOKIE uint16 = 23
+)
+
+// OPT is the RDATA of the EDNS(0) OPT pseudo-record (RFC 6891). The UDP
+// payload size, the extended RCODE and the flags, which are carried in the
+// CLASS and TTL fields on the wire, are held here as well.
+type OPT struct {
+	UDPSize       uint16
+	ExtendedRcode uint8 // upper 8 bits of the 12-bit RCODE
+	Version       uint8
+	DO            bool
+	Options       []EDNS0Option
+}
+
+func decodeOPT(msg []byte, current int, end int, class class, ttl TTL) (*OPT, error) {
+	opt := &OPT{
+		UDPSize:       uint16(class),
+		ExtendedRcode: uint8(ttl >> 24),
+		Version:       uint8(ttl >> 16),
+		DO:            ttl&(1<<15) != 0,
+	}
+	for current < end {
+		if end < current+4 {
+			return nil, ErrBadRData
+		}
+		code := binary.BigEndian.Uint16(msg[current:])
+		length := int(binary.BigEndian.Uint16(msg[current+2:]))
+		current += 4
+		if end < current+length {
+			return nil, ErrBadRData
+		}
+		option, err := decodeEDNS0Option(code, msg[current:current+length])
+		if err != nil {
+			return nil, err
+		}
+		opt.Options = append(opt.Options, option)
+		current += length
+	}
+	return opt, nil
+}
+
+func (opt *OPT) ttl() TTL {
+	ttl := TTL(opt.ExtendedRcode)<<24 | TTL(opt.Version)<<16
+	if opt.DO {
+		ttl |= 1 << 15
+	}
+	return ttl
+}
+
+// MarshalBinary returns the options. The other fields are carried in the
+// CLASS and TTL fields of the resource record.
+func (opt *OPT) MarshalBinary(msg []byte) (data []byte, err error) {
+	data = []byte{}
+	for _, option := range opt.Options {
+		b, err := option.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if 0xFFFF < len(b) {
+			return nil, fmt.Errorf("option length")
+		}
+		data = binary.BigEndian.AppendUint16(data, option.Code())
+		data = binary.BigEndian.AppendUint16(data, uint16(len(b)))
+		data = append(data, b...)
+	}
+	return
+}
+
+// Option returns the first option with code, or nil.
+func (opt *OPT) Option(code uint16) EDNS0Option {
+	for _, option := range opt.Options {
+		if option.Code() == code {
+			return option
+		}
+	}
+	return nil
+}
+
+// SetOption replaces the options with the same code by option.
+func (opt *OPT) SetOption(option EDNS0Option) {
+	options := opt.Options[:0]
+	for _, v := range opt.Options {
+		if v.Code() != option.Code() {
+			options = append(options, v)
+		}
+	}
+	opt.Options = append(options, option)
+}
+
+// String returns the OPT pseudo-section in the dig style, an option per line.
+func (opt *OPT) String() string {
+	flags := ""
+	if opt.DO {
+		flags = " do"
+	}
+	lines := []string{fmt.Sprintf("EDNS: version: %v, flags:%v; udp: %v", opt.Version, flags, opt.UDPSize)}
+	for _, option := range opt.Options {
+		lines = append(lines, option.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// EDNS0Option is an option in the OPT record.
+type EDNS0Option interface {
+	Code() uint16
+	MarshalBinary() ([]byte, error)
+	String() string
+}
+
+func decodeEDNS0Option(code uint16, data []byte) (EDNS0Option, error) {
+	data = append([]byte{}, data...)
+	switch code {
+	case EDNS0CodeNSID:
+		return EDNS0NSID(data), nil
+	case EDNS0CodeClientSubnet:
+		return decodeEDNS0ClientSubnet(data)
+	case EDNS0CodeCookie:
+		return decodeEDNS0Cookie(data)
+	case EDNS0CodeTCPKeepalive:
+		switch len(data) {
+		case 0:
+			return EDNS0TCPKeepalive{}, nil
+		case 2:
+			return EDNS0TCPKeepalive{true, binary.BigEndian.Uint16(data)}, nil
+		}
+		return nil, ErrBadRData
+	case EDNS0CodePadding:
+		return EDNS0Padding(len(data)), nil
+	case EDNS0CodeExtendedError:
+		if len(data) < 2 {
+			return nil, ErrBadRData
+		}
+		return EDNS0ExtendedError{binary.BigEndian.Uint16(data), string(data[2:])}, nil
+	}
+	return EDNS0Unknown{code, data}, nil
+}
+
+// EDNS0NSID is the name server identifier option (RFC 5001). It is empty in
+// queries.
+type EDNS0NSID []byte
+
+func (nsid EDNS0NSID) Code() uint16 {
+	return EDNS0CodeNSID
+}
+
+func (nsid EDNS0NSID) MarshalBinary() ([]byte, error) {
+	return []byte(nsid), nil
+}
+
+func (nsid EDNS0NSID) String() string {
+	if len(nsid) == 0 {
+		return "NSID"
+	}
+	printable := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && unicode.IsPrint(r) {
+			return r
+		}
+		return '.'
+	}, string(nsid))
+	return fmt.Sprintf("NSID: %x (%q)", []byte(nsid), printable)
+}
+
+// EDNS0ClientSubnet is the client subnet option (RFC 7871).
+type EDNS0ClientSubnet struct {
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	Address      netip.Addr
+}
+
+// NewEDNS0ClientSubnet returns the option for prefix, masking the host bits.
+func NewEDNS0ClientSubnet(prefix netip.Prefix) EDNS0ClientSubnet {
+	prefix = prefix.Masked()
+	return EDNS0ClientSubnet{uint8(prefix.Bits()), 0, prefix.Addr()}
+}
+
+func decodeEDNS0ClientSubnet(data []byte) (EDNS0Option, error) {
+	if len(data) < 4 {
+		return nil, ErrBadRData
+	}
+	family := binary.BigEndian.Uint16(data)
+	source := data[2]
+	scope := data[3]
+	var addr []byte
+	switch family {
+	case 1:
+		addr = make([]byte, 4)
+	case 2:
+		addr = make([]byte, 16)
+	default:
+		return nil, ErrBadRData
+	}
+	if len(addr)*8 < int(source) || len(addr)*8 < int(scope) || len(data)-4 != (int(source)+7)/8 {
+		return nil, ErrBadRData
+	}
+	copy(addr, data[4:])
+	ip, _ := netip.AddrFromSlice(addr)
+	if netip.PrefixFrom(ip, int(source)).Masked().Addr() != ip {
+		// the bits beyond the source prefix must be zero
+		return nil, ErrBadRData
+	}
+	return EDNS0ClientSubnet{source, scope, ip}, nil
+}
+
+func (ecs EDNS0ClientSubnet) Code() uint16 {
+	return EDNS0CodeClientSubnet
+}
+
+func (ecs EDNS0ClientSubnet) family() uint16 {
+	if ecs.Address.Is4() {
+		return 1
+	}
+	return 2
+}
+
+// Prefix returns the source prefix.
+func (ecs EDNS0ClientSubnet) Prefix() netip.Prefix {
+	return netip.PrefixFrom(ecs.Address, int(ecs.SourcePrefix))
+}
+
+func (ecs EDNS0ClientSubnet) MarshalBinary() ([]byte, error) {
+	if !ecs.Address.IsValid() || ecs.Address.BitLen() < int(ecs.SourcePrefix) || ecs.Address.BitLen() < int(ecs.ScopePrefix) {
+		return nil, fmt.Errorf("invalid client subnet: %v", ecs)
+	}
+	data := binary.BigEndian.AppendUint16(nil, ecs.family())
+	data = append(data, ecs.SourcePrefix, ecs.ScopePrefix)
+	addr := netip.PrefixFrom(ecs.Address, int(ecs.SourcePrefix)).Masked().Addr().AsSlice()
+	return append(data, addr[:(ecs.SourcePrefix+7)/8]...), nil
+}
+
+func (ecs EDNS0ClientSubnet) String() string {
+	return fmt.Sprintf("CLIENT-SUBNET: %v/%v/%v", ecs.Address, ecs.SourcePrefix, ecs.ScopePrefix)
+}
+
+// EDNS0Cookie is the DNS cookie option (RFC 7873). Server is empty when
+// the client has not learned the server cookie yet.
+type EDNS0Cookie struct {
+	Client []byte
+	Server []byte
+}
+
+func decodeEDNS0Cookie(data []byte) (EDNS0Option, error) {
+	if len(data) != 8 && (len(data) < 16 || 40 < len(data)) {
+		return nil, ErrBadRData
+	}
+	cookie := EDNS0Cookie{Client: data[:8]}
+	if 8 < len(data) {
+		cookie.Server = data[8:]
+	}
+	return cookie, nil
+}
+
+func (cookie EDNS0Cookie) Code() uint16 {
+	return EDNS0CodeCookie
+}
+
+func (cookie EDNS0Cookie) MarshalBinary() ([]byte, error) {
+	if len(cookie.Client) != 8 || (len(cookie.Server) != 0 && (len(cookie.Server) < 8 || 32 < len(cookie.Server))) {
+		return nil, fmt.Errorf("invalid cookie length")
+	}
+	return append(append([]byte{}, cookie.Client...), cookie.Server...), nil
+}
+
+func (cookie EDNS0Cookie) String() string {
+	return fmt.Sprintf("COOKIE: %x%x", cookie.Client, cookie.Server)
+}
+
+// EDNS0TCPKeepalive is the TCP keepalive option (RFC 7828). Timeout is in
+// units of 100 milliseconds and is absent in queries.
+type EDNS0TCPKeepalive struct {
+	HasTimeout bool
+	Timeout    uint16
+}
+
+func (keepalive EDNS0TCPKeepalive) Code() uint16 {
+	return EDNS0CodeTCPKeepalive
+}
+
+func (keepalive EDNS0TCPKeepalive) MarshalBinary() ([]byte, error) {
+	if !keepalive.HasTimeout {
+		return []byte{}, nil
+	}
+	return binary.BigEndian.AppendUint16(nil, keepalive.Timeout), nil
+}
+
+func (keepalive EDNS0TCPKeepalive) String() string {
+	if !keepalive.HasTimeout {
+		return "TCP-KEEPALIVE"
+	}
+	return fmt.Sprintf("TCP-KEEPALIVE: %.1f secs", float64(keepalive.Timeout)/10)
+}
+
+// EDNS0Padding is the padding option (RFC 7830) of the given length.
+type EDNS0Padding int
+
+func (padding EDNS0Padding) Code() uint16 {
+	return EDNS0CodePadding
+}
+
+func (padding EDNS0Padding) MarshalBinary() ([]byte, error) {
+	if padding < 0 || 0xFFFF < padding {
+		return nil, fmt.Errorf("invalid padding length: %v", int(padding))
+	}
+	return make([]byte, padding), nil
+}
+
+func (padding EDNS0Padding) String() string {
+	return fmt.Sprintf("PAD: (%v bytes)", int(padding))
+}
+
+// Extended DNS Error info-codes (RFC 8914).
+const (
+	EDEOtherError                 uint16 = 0
+	EDEUnsupportedDNSKEYAlgorithm uint16 = 1
+	EDEUnsupportedDSDigestType    uint16 = 2
+	EDEStaleAnswer                uint16 = 3
+	EDEForgedAnswer               uint16 = 4
+	EDEDNSSECIndeterminate        uint16 = 5
+	EDEDNSSECBogus                uint16 = 6
+	EDESignatureExpired           uint16 = 7
+	EDESignatureNotYetValid       uint16 = 8
+	EDEDNSKEYMissing              uint16 = 9
+	EDERRSIGsMissing              uint16 = 10
+	EDENoZoneKeyBitSet            uint16 = 11
+	EDENSECMissing                uint16 = 12
+	EDECachedError                uint16 = 13
+	EDENotReady                   uint16 = 14
+	EDEBlocked                    uint16 = 15
+	EDECensored                   uint16 = 16
+	EDEFiltered                   uint16 = 17
+	EDEProhibited                 uint16 = 18
+	EDEStaleNXDOMAINAnswer        uint16 = 19
+	EDENotAuthoritative           uint16 = 20
+	EDENotSupported               uint16 = 21
+	EDENoReachableAuthority       uint16 = 22
+	EDENetworkError               uint16 = 23
+	EDEInvalidData                uint16 = 24
+)
+
+var edeTexts = []string{
+	"Other Error",
+	"Unsupported DNSKEY Algorithm",
+	"Unsupported DS Digest Type",
+	"Stale Answer",
+	"Forged Answer",
+	"DNSSEC Indeterminate",
+	"DNSSEC Bogus",
+	"Signature Expired",
+	"Signature Not Yet Valid",
+	"DNSKEY Missing",
+	"RRSIGs Missing",
+	"No Zone Key Bit Set",
+	"NSEC Missing",
+	"Cached Error",
+	"Not Ready",
+	"Blocked",
+	"Censored",
+	"Filtered",
+	"Prohibited",
+	"Stale NXDOMAIN Answer",
+	"Not Authoritative",
+	"Not Supported",
+	"No Reachable Authority",
+	"Network Error",
+	"Invalid Data",
+}
+
+// EDNS0ExtendedError is the extended DNS error option (RFC 8914).
+type EDNS0ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+func (ede EDNS0ExtendedError) Code() uint16 {
+	return EDNS0CodeExtendedError
+}
+
+func (ede EDNS0ExtendedError) MarshalBinary() ([]byte, error) {
+	return append(binary.BigEndian.AppendUint16(nil, ede.InfoCode), ede.ExtraText...), nil
+}
+
+func (ede EDNS0ExtendedError) String() string {
+	s := fmt.Sprintf("EDE: %v", ede.InfoCode)
+	if int(ede.InfoCode) < len(edeTexts) {
+		s += fmt.Sprintf(" (%v)", edeTexts[ede.InfoCode])
+	}
+	if ede.ExtraText != "" {
+		s += fmt.Sprintf(": (%v)", ede.ExtraText)
+	}
+	return s
+}
+
+// EDNS0Unknown is an option without a specific type.
+type EDNS0Unknown struct {
+	OptionCode uint16
+	Data       []byte
+}
+
+func (u EDNS0Unknown) Code() uint16 {
+	return u.OptionCode
+}
+
+func (u EDNS0Unknown) MarshalBinary() ([]byte, error) {
+	return u.Data, nil
+}
+
+func (u EDNS0Unknown) String() string {
+	return fmt.Sprintf("OPT=%v: %v", u.OptionCode, strings.ToUpper(hex.EncodeToString(u.Data)))
+}
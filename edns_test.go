@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestOPTPackUnpack(t *testing.T) {
+	req, err := new(Msg).SetQuestion(Name("example.com."), TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetEDNS0(1232, true)
+	opt := req.IsEDNS0()
+	opt.Options = []EDNS0Option{
+		EDNS0NSID("ns1"),
+		NewEDNS0ClientSubnet(netip.MustParsePrefix("192.0.2.123/24")),
+		EDNS0Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Server: []byte{1, 0, 0, 0, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}},
+		EDNS0TCPKeepalive{true, 300},
+		EDNS0Padding(4),
+		EDNS0ExtendedError{EDEDNSSECBogus, "example.com."},
+		EDNS0Unknown{65001, []byte{0xAB}},
+	}
+	b, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := new(Msg)
+	err = actual.Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actualOpt := actual.IsEDNS0()
+	if actualOpt == nil {
+		t.Fatal("no OPT")
+	}
+	if !reflect.DeepEqual(actualOpt, opt) {
+		t.Errorf("%#v", actualOpt)
+	}
+	expected := `EDNS: version: 0, flags: do; udp: 1232
+NSID: 6e7331 ("ns1")
+CLIENT-SUBNET: 192.0.2.0/24/0
+COOKIE: 010203040506070801000000090a0b0c0d0e0f1011121314
+TCP-KEEPALIVE: 30.0 secs
+PAD: (4 bytes)
+EDE: 6 (DNSSEC Bogus): (example.com.)
+OPT=65001: AB`
+	if s := actualOpt.String(); s != expected {
+		t.Error(s)
+	}
+}
+
+func TestMsgExtendedRcode(t *testing.T) {
+	req, err := new(Msg).SetQuestion(Name("example.com."), TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := new(Msg).SetRcode(req, BADCOOKIE)
+	b, err := res.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := new(Msg)
+	err = actual.Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Rcode() != BADCOOKIE || actual.Header.Rcode() != BADCOOKIE&0xF {
+		t.Error(actual.Rcode())
+	}
+	if len(actual.AdditionalResourceRecords) != 1 {
+		t.Error(actual.AdditionalResourceRecords)
+	}
+}
+
+func TestDecodeEDNS0OptionErrors(t *testing.T) {
+	data := []struct {
+		code uint16
+		data []byte
+	}{
+		{EDNS0CodeCookie, []byte{1, 2, 3}},
+		{EDNS0CodeCookie, make([]byte, 12)},
+		{EDNS0CodeClientSubnet, []byte{0, 1, 24, 0, 192, 0}},       // short address
+		{EDNS0CodeClientSubnet, []byte{0, 1, 24, 0, 192, 0, 2, 1}}, // long address
+		{EDNS0CodeClientSubnet, []byte{0, 1, 23, 0, 192, 0, 3}},    // host bits
+		{EDNS0CodeClientSubnet, []byte{0, 3, 0, 0}},                // family
+		{EDNS0CodeTCPKeepalive, []byte{1}},
+		{EDNS0CodeExtendedError, []byte{1}},
+	}
+	for _, v := range data {
+		if _, err := decodeEDNS0Option(v.code, v.data); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+func TestEDNS0PaddingInvalid(t *testing.T) {
+	req, err := new(Msg).SetQuestion(Name("example.com."), TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetEDNS0(1232, false)
+	req.IsEDNS0().SetOption(EDNS0Padding(-1))
+	if _, err := req.Pack(); err == nil {
+		t.Error("no error")
+	}
+}
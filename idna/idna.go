@@ -0,0 +1,186 @@
+// Package idna converts internationalized domain names between the Unicode
+// form and the ASCII form with Punycode (RFC 3492). It implements a
+// simplified subset of IDNA 2008 (RFC 5891) rather than the full tables:
+// full-width forms and ideographic full stops are mapped and letters are
+// lowercased as in UTS #46, and a label may have letters, digits, combining
+// marks not at the start, hyphens not at the ends, and the CONTEXTO
+// characters of RFC 5892 Appendix A in their contexts. Unicode
+// normalization, the derived properties of RFC 5892, the CONTEXTJ rules and
+// the Bidi rule (RFC 5893) are not applied, so some labels that IDNA 2008
+// disallows, such as ones with compatibility characters, are accepted, and
+// labels with ZERO WIDTH JOINER or NON-JOINER are rejected. The input is
+// expected to be in NFC, which input methods produce.
+package idna
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const acePrefix = "xn--"
+
+const labelLenMax = 63
+
+// splitLabels splits name at the dots, or the full stops mapped to dots,
+// which are not escaped with a backslash. The empty last label of an
+// absolute name is kept.
+func splitLabels(name string) []string {
+	var labels []string
+	var label strings.Builder
+	escaped := false
+	for _, r := range name {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '.' || r == '。' || r == '．' || r == '｡':
+			labels = append(labels, label.String())
+			label.Reset()
+			continue
+		}
+		label.WriteRune(r)
+	}
+	return append(labels, label.String())
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if utf8.RuneSelf <= s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mapRune maps r by UTS #46 for the common cases.
+func mapRune(r rune) rune {
+	if 0xFF01 <= r && r <= 0xFF5E {
+		// full-width ASCII
+		r -= 0xFF01 - 0x21
+	}
+	return unicode.ToLower(r)
+}
+
+// checkLabel checks the U-label by the simplified rule of the package.
+func checkLabel(label string) error {
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return fmt.Errorf("idna: hyphen at the start or the end: %q", label)
+	}
+	if 4 <= len(label) && label[2:4] == "--" {
+		return fmt.Errorf("idna: hyphens at the third and fourth positions: %q", label)
+	}
+	runes := []rune(label)
+	for i, r := range runes {
+		switch {
+		case r == '-':
+		case unicode.IsMark(r):
+			if i == 0 {
+				return fmt.Errorf("idna: combining mark at the start: %q", label)
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		case isContextO(r):
+			if !contextOAllowed(runes, i) {
+				return fmt.Errorf("idna: %U out of its context: %q", r, label)
+			}
+		default:
+			return fmt.Errorf("idna: disallowed character %U: %q", r, label)
+		}
+	}
+	if strings.ContainsAny(label, "٠١٢٣٤٥٦٧٨٩") && strings.ContainsAny(label, "۰۱۲۳۴۵۶۷۸۹") {
+		return fmt.Errorf("idna: mixed Arabic-Indic digits: %q", label)
+	}
+	return nil
+}
+
+// isContextO reports whether r is a CONTEXTO character other than the
+// digits (RFC 5892 Appendix A).
+func isContextO(r rune) bool {
+	switch r {
+	case 0x00B7, 0x0375, 0x05F3, 0x05F4, 0x30FB:
+		return true
+	}
+	return false
+}
+
+// contextOAllowed reports whether the CONTEXTO character runes[i] is in its
+// context.
+func contextOAllowed(runes []rune, i int) bool {
+	switch runes[i] {
+	case 0x00B7:
+		// MIDDLE DOT between two l, as in Catalan
+		return 0 < i && i+1 < len(runes) && runes[i-1] == 'l' && runes[i+1] == 'l'
+	case 0x0375:
+		// GREEK LOWER NUMERAL SIGN followed by Greek
+		return i+1 < len(runes) && unicode.Is(unicode.Greek, runes[i+1])
+	case 0x05F3, 0x05F4:
+		// HEBREW PUNCTUATION GERESH and GERSHAYIM after Hebrew
+		return 0 < i && unicode.Is(unicode.Hebrew, runes[i-1])
+	case 0x30FB:
+		// KATAKANA MIDDLE DOT with Hiragana, Katakana or Han
+		for _, r := range runes {
+			if r != 0x30FB && unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ToASCII returns name with the non-ASCII labels converted to A-labels. The
+// ASCII labels are kept as they are, including the escapes.
+func ToASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	labels := splitLabels(name)
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		label = strings.Map(mapRune, label)
+		if isASCII(label) {
+			labels[i] = label
+			continue
+		}
+		err := checkLabel(label)
+		if err != nil {
+			return "", err
+		}
+		encoded, err := encodePunycode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = acePrefix + encoded
+		if labelLenMax < len(labels[i]) {
+			return "", fmt.Errorf("idna: label too long: %q", label)
+		}
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode returns name with the A-labels converted to U-labels.
+func ToUnicode(name string) (string, error) {
+	labels := splitLabels(name)
+	for i, label := range labels {
+		if len(label) < len(acePrefix) || !strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			continue
+		}
+		decoded, err := decodePunycode(strings.ToLower(label[len(acePrefix):]))
+		if err != nil {
+			return "", err
+		}
+		// the A-label must be the canonical one of the U-label
+		encoded, err := ToASCII(decoded)
+		if err != nil {
+			return "", err
+		}
+		if !strings.EqualFold(encoded, label) {
+			return "", fmt.Errorf("idna: invalid A-label: %q", label)
+		}
+		labels[i] = decoded
+	}
+	return strings.Join(labels, "."), nil
+}
@@ -0,0 +1,92 @@
+package idna
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	data := []struct {
+		name     string
+		expected string
+	}{
+		{"example.com.", "example.com."},
+		{`A\.b.Example.com`, `A\.b.Example.com`},
+		{"日本語.jp", "xn--wgv71a119e.jp"},
+		{"日本語。ＪＰ。", "xn--wgv71a119e.jp."},
+		{"ｅｘａｍｐｌｅ．ｊｐ", "example.jp"},
+		{"Bücher.example.", "xn--bcher-kva.example."},
+		{"www.ラーメン.jp", "www.xn--4dkp5a8a.jp"},
+	}
+	for _, v := range data {
+		actual, err := ToASCII(v.name)
+		if err != nil || actual != v.expected {
+			t.Errorf("%v: %v %v", v.name, actual, err)
+		}
+	}
+
+	for _, name := range []string{"-日本.jp", "日本-.jp", "日 本.jp", "́日本.jp"} {
+		if actual, err := ToASCII(name); err == nil {
+			t.Errorf("%v: %v", name, actual)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	data := []struct {
+		name     string
+		expected string
+	}{
+		{"example.com.", "example.com."},
+		{"xn--wgv71a119e.jp.", "日本語.jp."},
+		{"XN--BCHER-KVA.example", "bücher.example"},
+	}
+	for _, v := range data {
+		actual, err := ToUnicode(v.name)
+		if err != nil || actual != v.expected {
+			t.Errorf("%v: %v %v", v.name, actual, err)
+		}
+	}
+
+	for _, name := range []string{"xn--ls8h.jp", "xn--abc-.jp", "xn--wgv71a119e!.jp"} {
+		if actual, err := ToUnicode(name); err == nil {
+			t.Errorf("%v: %v", name, actual)
+		}
+	}
+}
+
+func TestToASCIIContext(t *testing.T) {
+	// CONTEXTO characters of RFC 5892 Appendix A
+	for _, name := range []string{
+		"ハロー・ワールド.jp",    // KATAKANA MIDDLE DOT with Katakana
+		"col·lecció.cat", // MIDDLE DOT between l
+		"͵α.gr",          // GREEK LOWER NUMERAL SIGN before Greek
+		"א׳.il",          // HEBREW PUNCTUATION GERESH after Hebrew
+		"١٢٣.example",    // Arabic-Indic digits
+	} {
+		ascii, err := ToASCII(name)
+		if err != nil {
+			t.Error(name, err)
+			continue
+		}
+		if unicode, err := ToUnicode(ascii); err != nil || unicode != name {
+			t.Error(name, ascii, unicode, err)
+		}
+	}
+	for _, name := range []string{"・.jp", "a・b.jp", "a·b.cat", "a͵b.gr", "a׳.il", "١۲.example"} {
+		if actual, err := ToASCII(name); err == nil {
+			t.Errorf("%v: %v", name, actual)
+		}
+	}
+}
+
+// TestToASCIILimits pins the labels where the simplified rule differs from
+// IDNA 2008.
+func TestToASCIILimits(t *testing.T) {
+	// IDNA 2008 disallows the compatibility characters, such as LATIN SMALL
+	// LIGATURE FI, which are letters without normalization
+	if _, err := ToASCII("ﬁ.example"); err != nil {
+		t.Error(err)
+	}
+	// the CONTEXTJ rule allows ZERO WIDTH NON-JOINER in Persian
+	if actual, err := ToASCII("می‌خواهم.example"); err == nil {
+		t.Error(actual)
+	}
+}
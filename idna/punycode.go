@@ -0,0 +1,177 @@
+package idna
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Bootstring parameters for Punycode (RFC 3492 Section 5).
+const (
+	base        = 36
+	tmin        = 1
+	tmax        = 26
+	skew        = 38
+	damp        = 700
+	initialBias = 72
+	initialN    = 128
+)
+
+var errOverflow = fmt.Errorf("punycode: overflow")
+
+func adapt(delta int, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for (base-tmin)*tmax/2 < delta {
+		delta /= base - tmin
+		k += base
+	}
+	return k + (base-tmin+1)*delta/(delta+skew)
+}
+
+func threshold(k int, bias int) int {
+	t := k - bias
+	if t < tmin {
+		return tmin
+	} else if tmax < t {
+		return tmax
+	}
+	return t
+}
+
+func encodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func decodeDigit(c byte) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c-'0') + 26, true
+	case 'A' <= c && c <= 'Z':
+		return int(c - 'A'), true
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a'), true
+	}
+	return 0, false
+}
+
+// encodePunycode returns s in Punycode without the ACE prefix.
+func encodePunycode(s string) (string, error) {
+	runes := []rune(s)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if 0 < b {
+		out = append(out, '-')
+	}
+	n, delta, bias := initialN, 0, initialBias
+	for h < len(runes) {
+		m := math.MaxInt32
+		for _, r := range runes {
+			if n <= int(r) && int(r) < m {
+				m = int(r)
+			}
+		}
+		if (math.MaxInt32-delta)/(h+1) < m-n {
+			return "", errOverflow
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+				if delta == math.MaxInt32 {
+					return "", errOverflow
+				}
+			}
+			if int(r) == n {
+				q := delta
+				for k := base; ; k += base {
+					t := threshold(k, bias)
+					if q < t {
+						break
+					}
+					out = append(out, encodeDigit(t+(q-t)%(base-t)))
+					q = (q - t) / (base - t)
+				}
+				out = append(out, encodeDigit(q))
+				bias = adapt(delta, h+1, h == b)
+				delta = 0
+				h++
+			}
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+// decodePunycode returns the Unicode string of s without the ACE prefix.
+func decodePunycode(s string) (string, error) {
+	var out []rune
+	start := 0
+	if b := strings.LastIndexByte(s, '-'); b != -1 {
+		for i := 0; i < b; i++ {
+			if utf8.RuneSelf <= s[i] {
+				return "", fmt.Errorf("punycode: invalid basic code point: %q", s)
+			}
+			out = append(out, rune(s[i]))
+		}
+		start = b + 1
+	}
+	n, i, bias := initialN, 0, initialBias
+	for pos := start; pos < len(s); {
+		oldi, w := i, 1
+		for k := base; ; k += base {
+			if len(s) <= pos {
+				return "", fmt.Errorf("punycode: truncated: %q", s)
+			}
+			digit, ok := decodeDigit(s[pos])
+			pos++
+			if !ok {
+				return "", fmt.Errorf("punycode: invalid digit: %q", s)
+			}
+			if (math.MaxInt32-i)/w < digit {
+				return "", errOverflow
+			}
+			i += digit * w
+			t := threshold(k, bias)
+			if digit < t {
+				break
+			}
+			if math.MaxInt32/(base-t) < w {
+				return "", errOverflow
+			}
+			w *= base - t
+		}
+		x := len(out) + 1
+		bias = adapt(i-oldi, x, oldi == 0)
+		if math.MaxInt32-n < i/x {
+			return "", errOverflow
+		}
+		n += i / x
+		i %= x
+		if utf8.MaxRune < n || (0xD800 <= n && n <= 0xDFFF) {
+			return "", fmt.Errorf("punycode: invalid code point: %q", s)
+		}
+		out = append(out, 0)
+		copy(out[i+1:], out[i:])
+		out[i] = rune(n)
+		i++
+	}
+	return string(out), nil
+}
@@ -0,0 +1,35 @@
+package idna
+
+import "testing"
+
+func TestPunycode(t *testing.T) {
+	// RFC 3492 Section 7.1 and others
+	data := []struct {
+		unicode  string
+		punycode string
+	}{
+		{"bücher", "bcher-kva"},
+		{"münchen", "mnchen-3ya"},
+		{"日本語", "wgv71a119e"},
+		{"3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+		{"ひとつ屋根の下2", "2-u9tlzr9756bt3uc0v"},
+		{"そのスピードで", "d9juau41awczczp"},
+		{"-> $1.00 <-", "-> $1.00 <--"},
+	}
+	for _, v := range data {
+		encoded, err := encodePunycode(v.unicode)
+		if err != nil || encoded != v.punycode {
+			t.Errorf("%v: %v %v", v.unicode, encoded, err)
+		}
+		decoded, err := decodePunycode(v.punycode)
+		if err != nil || decoded != v.unicode {
+			t.Errorf("%v: %v %v", v.punycode, decoded, err)
+		}
+	}
+
+	for _, s := range []string{"wgv71a119e!", "a-", "zzzzzzzzzzzzzzzzzzzzzzzzzz", "ü-abc"} {
+		if decoded, err := decodePunycode(s); err == nil && s != "a-" {
+			t.Errorf("%v: %q", s, decoded)
+		}
+	}
+}
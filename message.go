@@ -4,13 +4,22 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"net/netip"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+var (
+	ErrTruncated   = errors.New("truncated message")
+	ErrBadPointer  = errors.New("bad compression pointer")
+	ErrBadLabel    = errors.New("bad label")
+	ErrNameTooLong = errors.New("name too long")
+	ErrBadRData    = errors.New("bad rdata")
+)
+
 type Header struct {
 	ID      uint16
 	Fields  uint16
@@ -75,8 +84,33 @@ func (h *Header) rcode() uint16 {
 	return h.Fields & 0xf
 }
 
+// Flag reports whether flag, one of QR, AA, TC, RD, RA, Z, AD and CD, is set.
+func (h *Header) Flag(flag uint16) bool {
+	return h.Fields&flag != 0
+}
+
+func (h *Header) SetFlag(flag uint16, on bool) {
+	if on {
+		h.Fields |= flag
+	} else {
+		h.Fields &^= flag
+	}
+}
+
+func (h *Header) SetOpcode(opcode uint16) {
+	h.Fields = h.Fields&^(0xf<<11) | (opcode&0xf)<<11
+}
+
+func (h *Header) Rcode() uint16 {
+	return h.rcode()
+}
+
+func (h *Header) SetRcode(rcode uint16) {
+	h.Fields = h.Fields&^0xf | rcode&0xf
+}
+
 func (h *Header) resourceRecordCount() int {
-	return int(h.ANCount + h.NSCount + h.ARCount)
+	return int(h.ANCount) + int(h.NSCount) + int(h.ARCount)
 }
 
 func (h *Header) Bytes() []byte {
@@ -90,9 +124,23 @@ func (h *Header) Bytes() []byte {
 	return bytes
 }
 
+var statusTexts = map[uint16]string{
+	NOERROR: "NOERROR", FORMERR: "FORMERR", SERVFAIL: "SERVFAIL", NXDOMAIN: "NXDOMAIN",
+	NOTIMP: "NOTIMP", REFUSED: "REFUSED", YXDOMAIN: "YXDOMAIN", YXRRSET: "YXRRSET",
+	NXRRSET: "NXRRSET", NOTAUTH: "NOTAUTH", NOTZONE: "NOTZONE",
+	BADVERS: "BADVERS", BADCOOKIE: "BADCOOKIE",
+}
+
+// statusText returns the mnemonic of rcode.
+func statusText(rcode uint16) string {
+	if text, ok := statusTexts[rcode]; ok {
+		return text
+	}
+	return fmt.Sprint(rcode)
+}
+
 func (h Header) String() string {
-	opcodeTexts := []string{"QUERY", "IQUERY", "STATUS"}
-	statusTexts := []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+	opcodeTexts := []string{"QUERY", "IQUERY", "STATUS", "", "NOTIFY", "UPDATE"}
 
 	flags := make([]string, 0, 8)
 	if h.qr() != 0 {
@@ -120,10 +168,16 @@ func (h Header) String() string {
 		flags = append(flags, "cd")
 	}
 
+	opcode := fmt.Sprint(h.Opcode())
+	if int(h.Opcode()) < len(opcodeTexts) && opcodeTexts[h.Opcode()] != "" {
+		opcode = opcodeTexts[h.Opcode()]
+	}
+	status := statusText(uint16(h.rcode()))
+
 	return fmt.Sprintf(";; ->>HEADER<<- opcode: %v, status: %v, id: %v\n"+
 		";; flags: %v; QUERY: %v, ANSWER: %v, AUTHORITY: %v, ADDITIONAL: %v\n",
-		opcodeTexts[h.Opcode()],
-		statusTexts[h.rcode()],
+		opcode,
+		status,
 		h.ID,
 		strings.Join(flags, " "),
 		h.QDCount,
@@ -145,8 +199,21 @@ const (
 	FORMERR  uint16 = 1
 	SERVFAIL uint16 = 2
 	NXDOMAIN uint16 = 3
-	NOTIMP   uint16 = 3
-	REFUSED  uint16 = 4
+	NOTIMP   uint16 = 4
+	REFUSED  uint16 = 5
+	YXDOMAIN uint16 = 6
+	YXRRSET  uint16 = 7
+	NXRRSET  uint16 = 8
+	NOTAUTH  uint16 = 9
+	NOTZONE  uint16 = 10
+)
+
+const (
+	OpcodeQuery  uint16 = 0
+	OpcodeIQuery uint16 = 1
+	OpcodeStatus uint16 = 2
+	OpcodeNotify uint16 = 4
+	OpcodeUpdate uint16 = 5
 )
 
 func MakeHeaderFields(opcode uint16, vals ...uint16) uint16 {
@@ -157,128 +224,58 @@ func MakeHeaderFields(opcode uint16, vals ...uint16) uint16 {
 	return val
 }
 
-const (
-	labelLenMax      = 63
-	domainNameLenMax = 253
-)
-
-type Name string
-
-func (n Name) ancestors() []string {
-	parent := n.parent()
-	if parent == "." {
-		return nil
-	}
-	names := parent.ancestors()
-	names = append(names, parent.String())
-	return names
-}
-
-func (n Name) parent() Name {
-	s := strings.SplitN(string(n), ".", 2)
-	if s[0] == "" || len(s) == 1 {
-		return ""
-	} else if s[1] == "" {
-		return "."
-	}
-	return Name(s[1])
-}
-
-func (n Name) MarshalBinary(msg []byte) (data []byte, err error) {
-	return encodeName(string(n), msg)
-}
-
-func (n Name) String() string {
-	return string(n)
-}
-
-func encodeName(name string, msg []byte) ([]byte, error) {
-	if name == "" || name == "." {
-		return []byte{0}, nil
-	}
-
-	name = strings.TrimRight(name, ".")
-	if domainNameLenMax < len(name) {
-		return nil, fmt.Errorf("%s length", name)
-	}
-
-	if len(msg) != 0 {
-		// message compression
-
-		// search for FQDN
-		encoded, err := encodeName(name, nil)
-		if err != nil {
-			return nil, err
-		}
-		if i := bytes.Index(msg, encoded); i != -1 {
-			// simple match
-			return []byte{0xC0 | byte(i>>8), byte(i & 0xFF)}, nil
-		}
-
-		fields := strings.SplitN(name, ".", 2)
-		if len(fields) == 2 {
-			lavel0, err := encodeName(fields[0], nil)
-			if err != nil {
-				return nil, err
-			}
-			lavel0 = lavel0[:len(lavel0)-1] // trim null character
-
-			// search for lavel0 + lavel1 pointer
-			lavel1, err := encodeName(fields[1], nil)
-			if err != nil {
-				return nil, err
-			}
-			if i := bytes.Index(msg, lavel1); i != -1 {
-				search := append(lavel0, 0xC0|byte(i>>8), byte(i&0xFF))
-				if i := bytes.Index(msg, search); i != -1 {
-					return []byte{0xC0 | byte(i>>8), byte(i & 0xFF)}, nil
-				}
-			}
-
-			lavel1, err = encodeName(fields[1], msg)
-			if err != nil {
-				return nil, err
-			}
-			return append(lavel0, lavel1...), nil
-		}
-	}
-
-	buf := new(bytes.Buffer)
-	labels := strings.Split(name, ".")
-	for _, label := range labels {
-		len := len(label)
-		if labelLenMax < len {
-			return nil, fmt.Errorf("%s length", label)
-		}
-		buf.WriteByte(byte(len))
-		buf.WriteString(label)
-	}
-	buf.WriteByte(byte(0))
-	return buf.Bytes(), nil
-}
+const labelLenMax = 63
 
+// decodeName reads a possibly compressed domain name at current. Compression
+// pointers must point strictly before the labels being read, which rules out
+// both forward pointers and loops.
 func decodeName(data []byte, current int) (field, int, error) {
 	next := -1
 	buf := new(bytes.Buffer)
 	i := current
+	limit := current
+	wireLen := 1 // root label
 	for {
-		len := int(data[i])
-		if len == 0 {
+		if len(data) <= i {
+			return nil, 0, ErrTruncated
+		}
+		len_ := int(data[i])
+		if len_ == 0 {
 			i++
 			break
-		} else if len&0xC0 == 0xC0 {
+		}
+		switch len_ & 0xC0 {
+		case 0xC0:
+			if len(data) <= i+1 {
+				return nil, 0, ErrTruncated
+			}
 			if next == -1 {
 				next = i + 2
 			}
-			i = (len & ^0xC0 << 8) + int(data[i+1])
+			ptr := (len_ & ^0xC0 << 8) + int(data[i+1])
+			if limit <= ptr {
+				return nil, 0, ErrBadPointer
+			}
+			limit = ptr
+			i = ptr
 			continue
+		case 0x00:
+		default:
+			return nil, 0, ErrBadLabel
+		}
+		wireLen += 1 + len_
+		if 255 < wireLen {
+			return nil, 0, ErrNameTooLong
+		}
+		i++
+		if len(data) < i+len_ {
+			return nil, 0, ErrTruncated
 		}
 		if buf.Len() != 0 {
 			buf.WriteString(".")
 		}
-		i++
-		buf.Write(data[i : i+len])
-		i += len
+		buf.WriteString(escapeLabel(string(data[i : i+len_])))
+		i += len_
 	}
 	buf.WriteString(".")
 	if next == -1 {
@@ -300,15 +297,18 @@ func encodeTexts(texts []string) ([]byte, error) {
 	return bytes, nil
 }
 
-func decodeTexts(data []byte, current int, end int) []string {
+func decodeTexts(data []byte, current int, end int) ([]string, error) {
 	texts := make([]string, 0, 1)
 	for current < end {
 		txtlen := int(data[current])
+		if end < current+1+txtlen {
+			return nil, ErrBadRData
+		}
 		text := string(data[current+1 : current+1+txtlen])
 		texts = append(texts, text)
 		current += 1 + txtlen
 	}
-	return texts
+	return texts, nil
 }
 
 type Question struct {
@@ -326,7 +326,7 @@ func NewQuestionFromString(n, t, c string) (Question, error) {
 }
 
 func (q *Question) Bytes() ([]byte, error) {
-	encoded, err := encodeName(q.Name.String(), nil)
+	encoded, err := encodeName(q.Name.String())
 	if err != nil {
 		return nil, err
 	}
@@ -339,6 +339,12 @@ func (q *Question) Bytes() ([]byte, error) {
 	return bytes, nil
 }
 
+// canonical returns q with the canonical name, which is used as map keys.
+func (q Question) canonical() Question {
+	q.Name = q.Name.Canonical()
+	return q
+}
+
 func (q Question) String() string {
 	return fmt.Sprintf("%v %v %v", q.Name, q.Class, q.Type)
 }
@@ -374,11 +380,11 @@ func readFields(data []byte, current int, readers ...reader) ([]field, int, erro
 }
 
 func readType(data []byte, current int) (field, int, error) {
-	type_ := Type(binary.BigEndian.Uint16(data[current:]))
-	if _, ok := typeTexts[type_]; ok {
-		return type_, current + 2, nil
+	if len(data) < current+2 {
+		return nil, 0, ErrTruncated
 	}
-	return type_, 0, fmt.Errorf("invalid type: %v", uint16(type_))
+	type_ := Type(binary.BigEndian.Uint16(data[current:]))
+	return type_, current + 2, nil
 }
 
 type class uint16
@@ -389,10 +395,16 @@ const (
 )
 
 func (c class) String() string {
-	return classTextOf[c]
+	if text, ok := classTextOf[c]; ok {
+		return text
+	}
+	return fmt.Sprintf("CLASS%v", uint16(c))
 }
 
 func readClass(data []byte, current int) (field, int, error) {
+	if len(data) < current+2 {
+		return nil, 0, ErrTruncated
+	}
 	class := class(binary.BigEndian.Uint16(data[current:]))
 	return class, current + 2, nil
 }
@@ -404,6 +416,9 @@ func (t TTL) String() string {
 }
 
 func readTtl(data []byte, current int) (field, int, error) {
+	if len(data) < current+4 {
+		return nil, 0, ErrTruncated
+	}
 	ttl := TTL(binary.BigEndian.Uint32(data[current:]))
 	return ttl, current + 4, nil
 }
@@ -415,6 +430,9 @@ func (l rdlength) String() string {
 }
 
 func readRdlength(data []byte, current int) (field, int, error) {
+	if len(data) < current+2 {
+		return nil, 0, ErrTruncated
+	}
 	rdlength := rdlength(binary.BigEndian.Uint16(data[current:]))
 	return rdlength, current + 2, nil
 }
@@ -424,15 +442,41 @@ type RData interface {
 	String() string
 }
 
-type RDataStr string
+// UnknownRData is the opaque RDATA of a type without a codec, in the RFC 3597
+// generic format.
+type UnknownRData []byte
+
+func decodeUnknownRData(msg []byte, current int, end int) (RData, error) {
+	return UnknownRData(append([]byte{}, msg[current:end]...)), nil
+}
+
+func parseUnknownRData(fields []string) (UnknownRData, error) {
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, fmt.Errorf("invalid format: %v", fields)
+	}
+	l, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, err
+	}
+	if l != len(data) {
+		return nil, fmt.Errorf("invalid rdata length: %v", l)
+	}
+	return UnknownRData(data), nil
+}
 
-func (s RDataStr) MarshalBinary(msg []byte) (data []byte, err error) {
-	// TODO
-	return
+func (u UnknownRData) MarshalBinary(msg []byte) (data []byte, err error) {
+	return []byte(u), nil
 }
 
-func (s RDataStr) String() string {
-	return string(s)
+func (u UnknownRData) String() string {
+	if len(u) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %v %x`, len(u), []byte(u))
 }
 
 type ResourceRecord struct {
@@ -444,123 +488,32 @@ type ResourceRecord struct {
 }
 
 func parseResourceRecord(data []byte, current int) (*ResourceRecord, int, error) {
-	var val string
-
 	fields, current, err := readFields(data, current, decodeName, readType, readClass, readTtl, readRdlength)
 	if err != nil {
-		return nil, 0, fmt.Errorf("%v, fields: %v", err, fields)
+		return nil, 0, fmt.Errorf("%w, fields: %v", err, fields)
 	}
 	name := fields[0].(Name)
 	type_ := fields[1].(Type)
 	class := fields[2].(class)
 	ttl := fields[3].(TTL)
 	rdlength := fields[4].(rdlength)
+	end := current + int(rdlength)
+	if len(data) < end {
+		return nil, 0, ErrTruncated
+	}
+	// names in RDATA may point back into the message, but nothing may be
+	// read past RDLENGTH
+	data = data[:end]
 	var rdata RData
-
-	// rddata
-	switch type_ {
-	case TypeA:
-		ip, _ := netip.AddrFromSlice(data[current : current+int(rdlength)])
-		rdata = A(ip)
-	case TypeAAAA:
-		ip, _ := netip.AddrFromSlice(data[current : current+int(rdlength)])
-		rdata = AAAA(ip)
-	case TypeNS, TypeCNAME, TypePTR:
-		decoded, _, err := decodeName(data, current)
-		if err != nil {
-			return nil, 0, err
-		}
-		val = decoded.String()
-		rdata = Name(val)
-	case TypeMX:
-		preference := binary.BigEndian.Uint16(data[current:])
-		exchange, _, err := decodeName(data, current+2)
-		if err != nil {
-			return nil, 0, err
-		}
-		rdata = MX{preference, exchange.String()}
-	case TypeSOA:
-		mname, next, err := decodeName(data, current)
-		if err != nil {
-			return nil, 0, err
-		}
-		rname, next, err := decodeName(data, next)
-		if err != nil {
-			return nil, 0, err
-		}
-		serial := binary.BigEndian.Uint32(data[next:])
-		refresh := binary.BigEndian.Uint32(data[next+4:])
-		retry := binary.BigEndian.Uint32(data[next+8:])
-		expire := binary.BigEndian.Uint32(data[next+12:])
-		minimum := binary.BigEndian.Uint32(data[next+16:])
-		rdata = SOA{mname.(Name), rname.(Name), serial, refresh, retry, expire, minimum}
-	case TypeTXT:
-		texts := decodeTexts(data, current, current+int(rdlength))
-		for i, v := range texts {
-			texts[i] = fmt.Sprintf("%q", v)
-		}
-		rdata = newTxt(texts)
-	case TypeOPT:
-		rdata = RDataStr("")
-	case TypeDS:
-		keyTag := binary.BigEndian.Uint16(data[current:])
-		algo := data[current+2]
-		digestType := data[current+3]
-		digest := data[current+4 : current+int(rdlength)]
-		rdata = DS{keyTag, algo, digestType, digest}
-	case TypeRRSIG:
-		typeCovered, _, _ := readType(data, current)
-		algo := data[current+2]
-		labels := data[current+3]
-		originalTtl := binary.BigEndian.Uint32(data[current+4:])
-		signatureExpiration := binary.BigEndian.Uint32(data[current+8:])
-		signatureInception := binary.BigEndian.Uint32(data[current+12:])
-		keyTag := binary.BigEndian.Uint16(data[current+16:])
-		decoded, next, err := decodeName(data, current+18)
-		if err != nil {
-			return nil, 0, err
-		}
-		signerName := decoded.String()
-		signature := data[next : current+int(rdlength)]
-		rdata = RRSIG{typeCovered.(Type), algo, labels, originalTtl,
-			signatureExpiration, signatureInception,
-			keyTag, Name(signerName), signature}
-	case TypeNSEC:
-		decoded, next, err := decodeName(data, current)
-		if err != nil {
-			return nil, 0, err
-		}
-		nextDomainName := decoded.String()
-		windowBlock := data[next]
-		bitmapLen := int(data[next+1])
-		bitmap := data[next+2 : next+2+bitmapLen]
-		var texts []string
-		var types []int
-		for v := range typeTexts {
-			types = append(types, int(v))
-		}
-		sort.Ints(types)
-		if windowBlock == 0 {
-			for _, v := range types {
-				if v/8 < bitmapLen && bitmap[v/8]>>(7-v%8)&1 == 1 {
-					texts = append(texts, typeTexts[Type(uint16(v))])
-				}
-			}
-		}
-		rdata = NSEC{nextDomainName, strings.Join(texts, " ")}
-	case TypeDNSKEY:
-		flags := binary.BigEndian.Uint16(data[current:])
-		proto := data[current+2]
-		if proto != 3 {
-			return nil, 0, fmt.Errorf("DNSKEY proto: %v", proto)
-		}
-		algo := data[current+3]
-		key := data[current+4 : current+int(rdlength)]
-		rdata = DNSKEY{flags, proto, algo, key}
-	default:
-		rdata = RDataStr(fmt.Sprintf("unknown type: %v, rdlength: %v", type_, rdlength))
+	if type_ == TypeOPT {
+		rdata, err = decodeOPT(data, current, end, class, ttl)
+	} else {
+		rdata, err = decodeRData(type_, data, current, end)
 	}
-	current += int(rdlength)
+	if err != nil {
+		return nil, 0, err
+	}
+	current = end
 
 	return &ResourceRecord{
 		name,
@@ -571,44 +524,33 @@ func parseResourceRecord(data []byte, current int) (*ResourceRecord, int, error)
 	}, current, nil
 }
 
+// Bytes returns the wire format of rr without compression. msg is the
+// message before rr.
 func (rr ResourceRecord) Bytes(msg []byte) ([]byte, error) {
-	encoded, err := encodeName(rr.Name.String(), msg)
+	p := packer{buf: msg[:len(msg):len(msg)]}
+	err := p.appendResourceRecord(rr)
 	if err != nil {
 		return nil, err
 	}
-	l := len(encoded)
-	bytes := make([]byte, l+10) // TYPE(2) + CLASS(2) + TTL(4) + RDLENGTH(2)
-	copy(bytes, encoded)
-	binary.BigEndian.PutUint16(bytes[l:], uint16(rr.Type))
-	binary.BigEndian.PutUint16(bytes[l+2:], uint16(rr.Class))
-	binary.BigEndian.PutUint32(bytes[l+4:], uint32(rr.TTL))
-	var rdata []byte
-	if rr.Type != TypeOPT {
-		rdata, err = rr.RData.MarshalBinary(msg)
-		if err != nil {
-			return nil, err
-		}
-	}
-	binary.BigEndian.PutUint16(bytes[l+8:], uint16(len(rdata)))
-	bytes = append(bytes, rdata...)
-	return bytes, nil
+	return p.buf[len(msg):], nil
 }
 
 func (rr ResourceRecord) String() string {
-	if rr.Type == TypeOPT {
-		flags := ""
-		if ((rr.TTL >> 15) & 1) == 1 {
-			flags = " do"
-		}
-		return fmt.Sprintf("EDNS: version: %v, flags:%v; udp: %v\n", (rr.TTL>>16)&0xf, flags, int(rr.Class))
+	if opt, ok := rr.RData.(*OPT); ok {
+		return opt.String()
 	} else {
-		return fmt.Sprintf("%v %v %v %v %v", rr.Name, rr.TTL, rr.Class, rr.Type, rr.RData.String())
+		return fmt.Sprintf("%v %v %v %v %v", rr.Name, rr.TTL, rr.Class, rr.Type, printRData(rr.Type, rr.RData))
 	}
 }
 
-type Response struct {
+const UDPSize = 1500
+
+// Msg is a DNS message. It represents queries and responses as well as other
+// opcodes such as NOTIFY and UPDATE, whose sections are mapped to the
+// question, answer, authority and additional sections.
+type Msg struct {
 	Header                    Header
-	Question                  Question
+	Questions                 []Question
 	AnswerResourceRecords     []ResourceRecord
 	AuthorityResourceRecords  []ResourceRecord
 	AdditionalResourceRecords []ResourceRecord
@@ -618,7 +560,7 @@ type Response struct {
 }
 
 func MakeResponse(id uint16, fields uint16, question Question,
-	answers []ResourceRecord, authorities []ResourceRecord, additionals []ResourceRecord) (*Response, error) {
+	answers []ResourceRecord, authorities []ResourceRecord, additionals []ResourceRecord) (*Msg, error) {
 	resHeader := Header{
 		ID:      id,
 		Fields:  fields,
@@ -627,178 +569,157 @@ func MakeResponse(id uint16, fields uint16, question Question,
 		NSCount: uint16(len(authorities)),
 		ARCount: uint16(len(additionals)),
 	}
-	res := Response{
-		resHeader,
-		question,
-		answers,
-		authorities,
-		additionals,
-		0,
-		0,
-		nil,
+	res := Msg{
+		Header:                    resHeader,
+		Questions:                 []Question{question},
+		AnswerResourceRecords:     answers,
+		AuthorityResourceRecords:  authorities,
+		AdditionalResourceRecords: additionals,
 	}
 	return &res, nil
 }
 
-func ParseResMsg(data []byte) (*Response, error) {
-	msg, err := parseMessage(data)
+func newID() (uint16, error) {
+	rnd := make([]byte, 2)
+	_, err := rand.Read(rnd)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-
-	return &Response{
-		msg.Header,
-		msg.Question,
-		msg.AnswerResourceRecords,
-		msg.AuthorityResourceRecords,
-		msg.AdditionalResourceRecords,
-		msg.Size,
-		0,
-		data,
-	}, nil
+	return binary.BigEndian.Uint16(rnd), nil
 }
 
-func (res *Response) Bytes() ([]byte, error) {
-	questionBytes, err := res.Question.Bytes()
+// SetQuestion makes m a recursive query for name and type_ with a random ID.
+func (m *Msg) SetQuestion(name Name, type_ Type) (*Msg, error) {
+	id, err := newID()
 	if err != nil {
 		return nil, err
 	}
-	bytes := []byte{}
-	bytes = append(bytes, res.Header.Bytes()...)
-	bytes = append(bytes, questionBytes...)
-	for _, rr := range res.AnswerResourceRecords {
-		rrBytes, err := rr.Bytes(bytes)
-		if err != nil {
-			return nil, err
-		}
-		bytes = append(bytes, rrBytes...)
+	*m = Msg{
+		Header:    Header{ID: id, Fields: MakeHeaderFields(OpcodeQuery, RD), QDCount: 1},
+		Questions: []Question{{name, type_, ClassIN}},
 	}
-	for _, rr := range res.AuthorityResourceRecords {
-		rrBytes, err := rr.Bytes(bytes)
-		if err != nil {
-			return nil, err
-		}
-		bytes = append(bytes, rrBytes...)
+	return m, nil
+}
+
+// SetReply makes m an empty NOERROR response to req.
+func (m *Msg) SetReply(req *Msg) *Msg {
+	*m = Msg{
+		Header: Header{
+			ID:     req.Header.ID,
+			Fields: MakeHeaderFields(req.Header.Opcode(), QR, req.Header.Fields&(RD|CD)),
+		},
 	}
-	for _, rr := range res.AdditionalResourceRecords {
-		rrBytes, err := rr.Bytes(bytes)
-		if err != nil {
-			return nil, err
-		}
-		bytes = append(bytes, rrBytes...)
+	if len(req.Questions) != 0 {
+		m.Questions = []Question{req.Questions[0]}
+		m.Header.QDCount = 1
 	}
-	return bytes, nil
+	return m
 }
 
-const UDPSize = 1500
-
-type Request struct {
-	Header                    Header
-	Question                  Question
-	AdditionalResourceRecords []ResourceRecord
-	MsgSize                   int
+// SetRcode makes m a response to req with rcode. An extended RCODE adds an
+// OPT record for its upper bits.
+func (m *Msg) SetRcode(req *Msg, rcode uint16) *Msg {
+	m.SetReply(req)
+	m.Header.SetRcode(rcode)
+	if 0xF < rcode {
+		m.SetEDNS0(UDPSize, false)
+		m.IsEDNS0().ExtendedRcode = uint8(rcode >> 4)
+	}
+	return m
 }
 
-func MakeReqMsg(question Question, rd bool, edns bool, dnssec bool) ([]byte, error) {
-	questionBytes, err := question.Bytes()
-	if err != nil {
-		return nil, err
+// SetEDNS0 adds an OPT record to the additional section, or updates the
+// existing one.
+func (m *Msg) SetEDNS0(udpSize uint16, do bool) *Msg {
+	if opt := m.IsEDNS0(); opt != nil {
+		opt.UDPSize = udpSize
+		opt.DO = do
+		return m
 	}
-	headerFields := map[bool]uint16{false: 0, true: 1 << 8}[rd]
+	m.AdditionalResourceRecords = append(m.AdditionalResourceRecords, ResourceRecord{
+		Name:  ".",
+		Type:  TypeOPT,
+		Class: Class(udpSize),
+		RData: &OPT{UDPSize: udpSize, DO: do},
+	})
+	m.Header.ARCount = uint16(len(m.AdditionalResourceRecords))
+	return m
+}
 
-	var arcount uint16
-	var arbytes []byte
-	if edns {
-		ttl := 0
-		if dnssec {
-			ttl = 1 << 15 // DO bit
-		}
-		arcount = 1
-		opt := ResourceRecord{
-			Type:  TypeOPT,
-			Class: UDPSize, // UDP payload size
-			TTL:   TTL(ttl),
+// IsEDNS0 returns the OPT record in the additional section, or nil.
+func (m *Msg) IsEDNS0() *OPT {
+	for _, rr := range m.AdditionalResourceRecords {
+		if opt, ok := rr.RData.(*OPT); ok && rr.Type == TypeOPT {
+			return opt
 		}
-		bytes, err := opt.Bytes(nil)
-		if err != nil {
-			return nil, err
-		}
-		arbytes = append(arbytes, bytes...)
-	}
-
-	rnd := make([]byte, 2)
-	_, err = rand.Read(rnd)
-	if err != nil {
-		return nil, err
-	}
-	header := &Header{
-		ID:      binary.BigEndian.Uint16(rnd),
-		Fields:  headerFields,
-		QDCount: 1,
-		ARCount: arcount,
 	}
-
-	bytes := []byte{}
-	bytes = append(bytes, header.Bytes()...)
-	bytes = append(bytes, questionBytes...)
-	bytes = append(bytes, arbytes...)
-
-	return bytes, nil
+	return nil
 }
 
-func ParseRequest(data []byte) (*Request, error) {
-	msg, err := parseMessage(data)
-	if err != nil {
-		return nil, err
+// Rcode returns the RCODE extended with the OPT record.
+func (m *Msg) Rcode() uint16 {
+	rcode := m.Header.Rcode()
+	if opt := m.IsEDNS0(); opt != nil {
+		rcode |= uint16(opt.ExtendedRcode) << 4
 	}
-
-	return &Request{
-		msg.Header,
-		msg.Question,
-		msg.AdditionalResourceRecords,
-		msg.Size,
-	}, nil
+	return rcode
 }
 
-type message struct {
-	Header                    Header
-	Question                  Question
-	AnswerResourceRecords     []ResourceRecord
-	AuthorityResourceRecords  []ResourceRecord
-	AdditionalResourceRecords []ResourceRecord
-	Size                      int
+// Pack returns the wire format of m. The section counts in the header are
+// taken from the sections.
+func (m *Msg) Pack() ([]byte, error) {
+	return m.PackBuffer(nil)
 }
 
-func parseMessage(msg []byte) (*message, error) {
+// Unpack parses the wire format of a message into m.
+func (m *Msg) Unpack(data []byte) error {
 	// Header section
-	header, err := parseHeader(msg)
+	header, err := parseHeader(data)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	*m = Msg{Header: *header} // kept on error to make FORMERR responses
 
 	// Question section
-	fields, current, err := readFields(msg, headerSize, decodeName, readType, readClass)
-	if err != nil {
-		return nil, err
+	current := headerSize
+	if len(data)-current < int(header.QDCount)*5 { // NAME(1) + TYPE(2) + CLASS(2)
+		return ErrTruncated
+	}
+	questions := make([]Question, header.QDCount)
+	for i := range questions {
+		var fields []field
+		fields, current, err = readFields(data, current, decodeName, readType, readClass)
+		if err != nil {
+			return err
+		}
+		questions[i] = Question{fields[0].(Name), fields[1].(Type), fields[2].(class)}
 	}
 
 	// Resource records
-	records := make([]ResourceRecord, header.resourceRecordCount())
-	for i := 0; i < header.resourceRecordCount(); i++ {
+	count := header.resourceRecordCount()
+	if len(data)-current < count*11 { // NAME(1) + TYPE(2) + CLASS(2) + TTL(4) + RDLENGTH(2)
+		return ErrTruncated
+	}
+	records := make([]ResourceRecord, count)
+	for i := 0; i < count; i++ {
 		var record *ResourceRecord
-		record, current, err = parseResourceRecord(msg, current)
+		record, current, err = parseResourceRecord(data, current)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		records[i] = *record
 	}
 
-	return &message{
-		*header,
-		Question{fields[0].(Name), fields[1].(Type), fields[2].(class)},
-		records[:header.ANCount],
-		records[header.ANCount : header.ANCount+header.NSCount],
-		records[header.ANCount+header.NSCount : header.ANCount+header.NSCount+header.ARCount],
-		current,
-	}, nil
+	an := int(header.ANCount)
+	ns := an + int(header.NSCount)
+	*m = Msg{
+		Header:                    *header,
+		Questions:                 questions,
+		AnswerResourceRecords:     records[:an:an],
+		AuthorityResourceRecords:  records[an:ns:ns],
+		AdditionalResourceRecords: records[ns:],
+		MsgSize:                   current,
+		RawMsg:                    data,
+	}
+	return nil
 }
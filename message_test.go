@@ -2,6 +2,7 @@ package dns
 
 import (
 	"bytes"
+	"errors"
 	"net/netip"
 	"reflect"
 	"strings"
@@ -9,16 +10,24 @@ import (
 )
 
 func TestParseRequest(t *testing.T) {
-	reqMsg, err := MakeReqMsg(Question{Name("example.com"), TypeA, ClassIN}, true, true, false)
+	req, err := newQuery(Question{Name("example.com"), TypeA, ClassIN}, true, true, false)
 	if err != nil {
 		t.Error(err)
 	}
-	request, err := ParseRequest(reqMsg)
+	reqMsg, err := req.Pack()
 	if err != nil {
 		t.Error(err)
 	}
-	if request.Question.Name != "example.com." {
-		t.Errorf("request.Question.Name: %v", request.Question.Name)
+	request := new(Msg)
+	err = request.Unpack(reqMsg)
+	if err != nil {
+		t.Error(err)
+	}
+	if request.Questions[0].Name != "example.com." {
+		t.Errorf("request.Questions[0].Name: %v", request.Questions[0].Name)
+	}
+	if !request.Header.Flag(RD) || request.Header.Flag(QR) || len(request.AdditionalResourceRecords) != 1 {
+		t.Errorf("request: %v", request.Header)
 	}
 }
 
@@ -42,7 +51,7 @@ func TestResponseBytes(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		bytes, err := res.Bytes()
+		bytes, err := res.Pack()
 		if err != nil {
 			t.Error(err)
 		}
@@ -64,7 +73,7 @@ func TestResponseBytes(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		bytes, err := res.Bytes()
+		bytes, err := res.Pack()
 		if err != nil {
 			t.Error(err)
 		}
@@ -117,18 +126,15 @@ func TestEncodeTexts(t *testing.T) {
 func TestEncodeName(t *testing.T) {
 	data := []struct {
 		name     string
-		msg      []byte
 		expected []byte
 	}{
-		{"example.com.", nil, []byte("\x07example\x03com\x00")},
-		{"example.com.", []byte("\x07example\x03com\x00"), []byte("\xC0\x00")},
-		{"example.com.", []byte("\x03www\x07example\x03com\x00"), []byte("\xC0\x04")},
-		{"mx1.example.com.", []byte("\x07example\x03com\x00"), []byte("\x03mx1\xC0\x00")},
-		{"mx1.example.com.", []byte("\x07example\x03com\x00\x03mx1\xC0\x00"), []byte("\xC0\x0D")},
+		{"example.com.", []byte("\x07example\x03com\x00")},
+		{"example.com", []byte("\x07example\x03com\x00")},
+		{".", []byte("\x00")},
 	}
 
 	for _, v := range data {
-		encoded, err := encodeName(v.name, v.msg)
+		encoded, err := encodeName(v.name)
 		if err != nil {
 			t.Error(v, err)
 		}
@@ -136,6 +142,12 @@ func TestEncodeName(t *testing.T) {
 			t.Error(v, encoded)
 		}
 	}
+
+	for _, name := range []string{"a..example.com.", strings.Repeat("a", 64) + ".com."} {
+		if _, err := encodeName(name); err == nil {
+			t.Error(name)
+		}
+	}
 }
 
 func TestNameAncestors(t *testing.T) {
@@ -168,3 +180,209 @@ func TestNameParent(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMessageErrors(t *testing.T) {
+	data := []struct {
+		msg      []byte
+		expected error
+	}{
+		// truncated question name
+		{[]byte("\x00\x00\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x07exam"), ErrTruncated},
+		// pointer to itself
+		{[]byte("\x00\x00\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\xC0\x0C\x00\x01\x00\x01"), ErrBadPointer},
+		// forward pointer
+		{[]byte("\x00\x00\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\xC0\x0E\x00\x00\x01\x00\x01"), ErrBadPointer},
+		// reserved label type
+		{[]byte("\x00\x00\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x40\x00\x01\x00\x01"), ErrBadLabel},
+		// ANCOUNT larger than the message
+		{[]byte("\x00\x00\x81\x00\x00\x01\xFF\xFF\x00\x00\x00\x00\x00\x00\x01\x00\x01"), ErrTruncated},
+		// RDLENGTH past the end of the message
+		{[]byte("\x00\x00\x81\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00\x01\x00\x01" +
+			"\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x04\xC0\x00"), ErrTruncated},
+		// A record with a wrong RDLENGTH
+		{[]byte("\x00\x00\x81\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00\x01\x00\x01" +
+			"\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x03\xC0\x00\x02"), ErrBadRData},
+		// NS name running past RDLENGTH
+		{[]byte("\x00\x00\x81\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00\x01\x00\x01" +
+			"\x00\x00\x02\x00\x01\x00\x00\x00\x00\x00\x02\x03com\x00"), ErrTruncated},
+	}
+	for i, v := range data {
+		err := new(Msg).Unpack(v.msg)
+		if !errors.Is(err, v.expected) {
+			t.Errorf("%v: expected: %v, actual: %v", i, v.expected, err)
+		}
+	}
+}
+
+func FuzzMsgUnpack(f *testing.F) {
+	req, err := newQuery(Question{Name("example.com."), TypeA, ClassIN}, true, true, true)
+	if err != nil {
+		f.Fatal(err)
+	}
+	reqMsg, err := req.Pack()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(reqMsg)
+	answers := []ResourceRecord{
+		{Name("example.com."), TypeMX, ClassIN, 3600, MX{10, "mx1.example.com."}},
+		{Name("example.com."), TypeTXT, ClassIN, 3600, TXT("foo\x00bar")},
+		{Name("example.com."), TypeDS, ClassIN, 3600, mustParseDS("31589 8 1 3490A6806D47F17A34C29E2CE80E8A999FFBE4BE")},
+	}
+	authorities := []ResourceRecord{
+		{Name("example.com."), TypeNS, ClassIN, 3600, NS("ns1.example.com.")},
+		{Name("example.com."), TypeSOA, ClassIN, 3600, SOA{"ns1.example.com.", "hostmaster.example.com.", 1, 2, 3, 4, 5}},
+	}
+	additionals := []ResourceRecord{
+		{Name("mx1.example.com."), TypeA, ClassIN, 600, A(netip.MustParseAddr("192.0.2.3"))},
+		{Name("mx1.example.com."), TypeAAAA, ClassIN, 600, AAAA(netip.MustParseAddr("2001:db8::3"))},
+	}
+	res, err := MakeResponse(0, QR, Question{Name("example.com."), TypeMX, ClassIN}, answers, authorities, additionals)
+	if err != nil {
+		f.Fatal(err)
+	}
+	resMsg, err := res.Pack()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(resMsg)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := new(Msg)
+		err := msg.Unpack(data)
+		if err != nil {
+			return
+		}
+		_ = msg.Header.String()
+		for _, q := range msg.Questions {
+			_ = q.String()
+		}
+		for _, rrs := range [][]ResourceRecord{msg.AnswerResourceRecords, msg.AuthorityResourceRecords, msg.AdditionalResourceRecords} {
+			for _, rr := range rrs {
+				_ = rr.String()
+			}
+		}
+		if opt := msg.IsEDNS0(); opt != nil {
+			_ = opt.String()
+		}
+		packed, err := msg.Pack()
+		if err != nil {
+			return
+		}
+		if err := new(Msg).Unpack(packed); err != nil {
+			t.Errorf("unpack of packed %x: %v", data, err)
+		}
+	})
+}
+
+func TestUnknownRData(t *testing.T) {
+	msg := []byte("\x00\x00\x81\x00\x00\x01\x00\x01\x00\x00\x00\x00" +
+		"\x07example\x03com\x00\xFF\xFE\x00\x01" +
+		"\xC0\x0C\xFF\xFE\x00\x01\x00\x00\x0E\x10\x00\x04\x0A\x00\x00\x01")
+	res := new(Msg)
+	err := res.Unpack(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.Questions[0].String(); s != "example.com. IN TYPE65534" {
+		t.Error(s)
+	}
+	if s := res.AnswerResourceRecords[0].String(); s != `example.com. 3600 IN TYPE65534 \# 4 0a000001` {
+		t.Error(s)
+	}
+	b, err := res.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Errorf("%x", b)
+	}
+}
+
+func TestParseUnknownRData(t *testing.T) {
+	data := []struct {
+		fields   []string
+		expected UnknownRData
+	}{
+		{[]string{`\#`, "0"}, UnknownRData{}},
+		{[]string{`\#`, "4", "0a000001"}, UnknownRData{10, 0, 0, 1}},
+		{[]string{`\#`, "4", "0a00", "0001"}, UnknownRData{10, 0, 0, 1}},
+	}
+	for _, v := range data {
+		actual, err := parseUnknownRData(v.fields)
+		if err != nil {
+			t.Error(v.fields, err)
+		} else if !bytes.Equal(actual, v.expected) {
+			t.Error(v.fields, actual)
+		}
+	}
+	for _, v := range [][]string{{`\#`}, {`\#`, "3", "0a000001"}, {`\#`, "1", "zz"}, {"4", "0a000001"}} {
+		if _, err := parseUnknownRData(v); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+func TestMsgReply(t *testing.T) {
+	req, err := new(Msg).SetQuestion(Name("example.com."), TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.SetFlag(CD, true)
+	req.SetEDNS0(1232, true)
+
+	res := new(Msg).SetRcode(req, NXDOMAIN)
+	if res.Header.ID != req.Header.ID {
+		t.Error(res.Header.ID)
+	}
+	if !res.Header.Flag(QR) || !res.Header.Flag(RD) || !res.Header.Flag(CD) || res.Header.Flag(AA) {
+		t.Error(res.Header)
+	}
+	if res.Header.Rcode() != NXDOMAIN || res.Header.Opcode() != OpcodeQuery {
+		t.Error(res.Header)
+	}
+	if len(res.Questions) != 1 || res.Questions[0] != req.Questions[0] {
+		t.Error(res.Questions)
+	}
+	if len(res.AdditionalResourceRecords) != 0 {
+		t.Error(res.AdditionalResourceRecords)
+	}
+}
+
+func TestMsgPackUnpack(t *testing.T) {
+	// UPDATE message with zone, prerequisite and update sections
+	msg := &Msg{
+		Header:    Header{ID: 1234, Fields: MakeHeaderFields(OpcodeUpdate)},
+		Questions: []Question{{Name("example.com."), TypeSOA, ClassIN}},
+		AnswerResourceRecords: []ResourceRecord{
+			{Name("www.example.com."), TypeCNAME, ClassIN, 0, CNAME("example.com.")},
+		},
+		AuthorityResourceRecords: []ResourceRecord{
+			{Name("mx1.example.com."), TypeA, ClassIN, 600, A(netip.MustParseAddr("192.0.2.3"))},
+			{Name("mx2.example.com."), TypeA, ClassIN, 600, A(netip.MustParseAddr("192.0.2.4"))},
+		},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := new(Msg)
+	err = actual.Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Header.Opcode() != OpcodeUpdate || actual.Header.ID != 1234 {
+		t.Error(actual.Header)
+	}
+	if actual.Header.QDCount != 1 || actual.Header.ANCount != 1 || actual.Header.NSCount != 2 || actual.Header.ARCount != 0 {
+		t.Error(actual.Header)
+	}
+	if !reflect.DeepEqual(actual.Questions, msg.Questions) ||
+		!reflect.DeepEqual(actual.AnswerResourceRecords, msg.AnswerResourceRecords) ||
+		!reflect.DeepEqual(actual.AuthorityResourceRecords, msg.AuthorityResourceRecords) {
+		t.Error(actual)
+	}
+	if actual.MsgSize != len(b) {
+		t.Error(actual.MsgSize)
+	}
+}
@@ -0,0 +1,201 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Name is a domain name in the presentation format, where the dots, the
+// backslashes and the other special or non-printable bytes in labels are
+// escaped as \X or \DDD (RFC 1035 Section 5.1).
+type Name string
+
+// isAbsolute reports whether name ends with an unescaped dot.
+func isAbsolute(name string) bool {
+	if !strings.HasSuffix(name, ".") {
+		return false
+	}
+	backslashes := 0
+	for i := len(name) - 2; 0 <= i && name[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 0
+}
+
+// splitName returns the labels of name with the escapes resolved, and the
+// index in name where each label starts. The root label is not included.
+func splitName(name string) (labels []string, starts []int, err error) {
+	if name == "." {
+		return nil, nil, nil
+	}
+	var label []byte
+	start := 0
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch c {
+		case '.':
+			if i == start {
+				return nil, nil, fmt.Errorf("empty label: %s", name)
+			}
+			labels = append(labels, string(label))
+			starts = append(starts, start)
+			label = label[:0]
+			start = i + 1
+			continue
+		case '\\':
+			i++
+			if len(name) <= i {
+				return nil, nil, fmt.Errorf("invalid escape: %s", name)
+			}
+			c = name[i]
+			if '0' <= c && c <= '9' {
+				if len(name) < i+3 {
+					return nil, nil, fmt.Errorf("invalid escape: %s", name)
+				}
+				v, err := strconv.ParseUint(name[i:i+3], 10, 8)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid escape: %s", name)
+				}
+				c = byte(v)
+				i += 2
+			}
+		}
+		label = append(label, c)
+	}
+	if start < len(name) {
+		labels = append(labels, string(label))
+		starts = append(starts, start)
+	}
+	return labels, starts, nil
+}
+
+// escapeLabel returns label in the presentation format.
+func escapeLabel(label string) string {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case strings.IndexByte(`.\"();@$`, c) != -1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x21 || 0x7E < c:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func (n Name) ancestors() []string {
+	parent := n.parent()
+	if parent == "." || parent == "" {
+		return nil
+	}
+	names := parent.ancestors()
+	names = append(names, parent.String())
+	return names
+}
+
+func (n Name) parent() Name {
+	labels, starts, err := splitName(string(n))
+	if err != nil || len(labels) == 0 {
+		return ""
+	}
+	if len(labels) == 1 {
+		if isAbsolute(string(n)) {
+			return "."
+		}
+		return ""
+	}
+	return n[starts[1]:]
+}
+
+func (n Name) MarshalBinary(msg []byte) (data []byte, err error) {
+	return encodeName(string(n))
+}
+
+func (n Name) String() string {
+	return string(n)
+}
+
+// Canonical returns n in lowercase with the escapes normalized, which is the
+// same for the equal names.
+func (n Name) Canonical() Name {
+	labels, _, err := splitName(string(n))
+	if err != nil {
+		return Name(lowerASCII(string(n)))
+	}
+	if len(labels) == 0 {
+		return n
+	}
+	escaped := make([]string, len(labels))
+	for i, label := range labels {
+		escaped[i] = escapeLabel(lowerASCII(label))
+	}
+	canonical := strings.Join(escaped, ".")
+	if isAbsolute(string(n)) {
+		canonical += "."
+	}
+	return Name(canonical)
+}
+
+// Equal reports whether n and other are the same name, ignoring case.
+func (n Name) Equal(other Name) bool {
+	return n == other || n.Canonical() == other.Canonical()
+}
+
+// CountLabels returns the number of labels except the root.
+func (n Name) CountLabels() int {
+	labels, _, _ := splitName(string(n))
+	return len(labels)
+}
+
+// IsSubdomainOf reports whether n is parent or below parent, ignoring case.
+func (n Name) IsSubdomainOf(parent Name) bool {
+	labels, _, err := splitName(string(n))
+	if err != nil {
+		return false
+	}
+	parentLabels, _, err := splitName(string(parent))
+	if err != nil || len(labels) < len(parentLabels) {
+		return false
+	}
+	labels = labels[len(labels)-len(parentLabels):]
+	for i := range labels {
+		if lowerASCII(labels[i]) != lowerASCII(parentLabels[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareCanonical compares n and other in the canonical order (RFC 4034
+// Section 6.1), label by label from the right, and returns -1, 0 or 1.
+func (n Name) CompareCanonical(other Name) int {
+	labels, _, _ := splitName(string(n))
+	otherLabels, _, _ := splitName(string(other))
+	for i, j := len(labels)-1, len(otherLabels)-1; 0 <= i || 0 <= j; i, j = i-1, j-1 {
+		if i < 0 {
+			return -1
+		}
+		if j < 0 {
+			return 1
+		}
+		if c := strings.Compare(lowerASCII(labels[i]), lowerASCII(otherLabels[j])); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
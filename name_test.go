@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func TestNameEscape(t *testing.T) {
+	data := []struct {
+		name    Name
+		encoded []byte
+	}{
+		{`a\.b.example.`, []byte("\x03a.b\x07example\x00")},
+		{`a\\b.example.`, []byte("\x03a\\b\x07example\x00")},
+		{`sp\032ace.example.`, []byte("\x06sp ace\x07example\x00")},
+		{`\000\255.example.`, []byte("\x02\x00\xFF\x07example\x00")},
+		{`\@\$\;\(\)\".example.`, []byte("\x06@$;()\"\x07example\x00")},
+	}
+	for _, v := range data {
+		encoded, err := encodeName(v.name.String())
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if !bytes.Equal(encoded, v.encoded) {
+			t.Errorf("%v: %q", v.name, encoded)
+		}
+		decoded, _, err := decodeName(v.encoded, 0)
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if decoded != v.name {
+			t.Errorf("%v: %v", v.name, decoded)
+		}
+	}
+
+	// \DDD of a printable byte is the same label
+	encoded, err := encodeName(`\065\.b.example.`)
+	if err != nil || !bytes.Equal(encoded, []byte("\x03A.b\x07example\x00")) {
+		t.Errorf("%q %v", encoded, err)
+	}
+
+	for _, name := range []string{`a\`, `a\25.example.`, `a\256.example.`, `a..example.`} {
+		if _, err := encodeName(name); err == nil {
+			t.Error(name)
+		}
+	}
+}
+
+func TestNameParentEscaped(t *testing.T) {
+	if parent := Name(`a\.b.example.`).parent(); parent != "example." {
+		t.Error(parent)
+	}
+	if n := Name(`a\.b.example.`).CountLabels(); n != 2 {
+		t.Error(n)
+	}
+	if n := Name(".").CountLabels(); n != 0 {
+		t.Error(n)
+	}
+}
+
+func TestNameCanonical(t *testing.T) {
+	data := []struct {
+		name      Name
+		canonical Name
+	}{
+		{"Example.COM.", "example.com."},
+		{`\065\.B.example.`, `a\.b.example.`},
+		{`\x.example`, "x.example"},
+		{".", "."},
+	}
+	for _, v := range data {
+		if canonical := v.name.Canonical(); canonical != v.canonical {
+			t.Errorf("%v: %v", v.name, canonical)
+		}
+		if !v.name.Equal(v.canonical) {
+			t.Errorf("%v != %v", v.name, v.canonical)
+		}
+	}
+	if Name("a.example.").Equal("b.example.") {
+		t.Error("equal")
+	}
+}
+
+func TestNameIsSubdomainOf(t *testing.T) {
+	data := []struct {
+		name     Name
+		parent   Name
+		expected bool
+	}{
+		{"www.example.com.", "example.com.", true},
+		{"WWW.Example.com.", "EXAMPLE.COM.", true},
+		{"example.com.", "example.com.", true},
+		{"example.com.", ".", true},
+		{"wwwexample.com.", "example.com.", false},
+		{`www\.example.com.`, "example.com.", false},
+		{"com.", "example.com.", false},
+	}
+	for _, v := range data {
+		if actual := v.name.IsSubdomainOf(v.parent); actual != v.expected {
+			t.Error(v, actual)
+		}
+	}
+}
+
+func TestNameCompareCanonical(t *testing.T) {
+	// RFC 4034 Section 6.1
+	expected := []Name{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		`\001.z.example.`,
+		"*.z.example.",
+		`\200.z.example.`,
+	}
+	names := []Name{}
+	for i := len(expected) - 1; 0 <= i; i-- {
+		names = append(names, expected[i])
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].CompareCanonical(names[j]) < 0 })
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Error(names)
+			break
+		}
+	}
+	if c := Name("Example.").CompareCanonical("example."); c != 0 {
+		t.Error(c)
+	}
+}
+
+func TestNewRRSetsCase(t *testing.T) {
+	rrSets := NewRRSets([]ResourceRecord{
+		{"Example.COM.", TypeTXT, ClassIN, 300, TXT("a")},
+		{"example.com.", TypeTXT, ClassIN, 300, TXT("b")},
+	})
+	rrSet, ok := rrSets.Get(Question{"EXAMPLE.com.", TypeTXT, ClassIN})
+	if len(rrSets) != 1 || !ok || len(rrSet.RDatas) != 2 {
+		t.Error(rrSets)
+	}
+}
@@ -0,0 +1,356 @@
+package dns
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decodeTypeBitmap decodes the type bit maps of NSEC and NSEC3 records (RFC
+// 4034 Section 4.1.2).
+func decodeTypeBitmap(data []byte) ([]Type, error) {
+	var types []Type
+	lastWindow := -1
+	for i := 0; i < len(data); {
+		if len(data) < i+2 {
+			return nil, ErrBadRData
+		}
+		window := int(data[i])
+		bitmapLen := int(data[i+1])
+		if window <= lastWindow || bitmapLen == 0 || 32 < bitmapLen || len(data) < i+2+bitmapLen {
+			return nil, ErrBadRData
+		}
+		bitmap := data[i+2 : i+2+bitmapLen]
+		for j, b := range bitmap {
+			for k := 0; k < 8; k++ {
+				if b>>(7-k)&1 == 1 {
+					types = append(types, Type(window<<8|j*8+k))
+				}
+			}
+		}
+		lastWindow = window
+		i += 2 + bitmapLen
+	}
+	return types, nil
+}
+
+// encodeTypeBitmap encodes types in the type bit maps.
+func encodeTypeBitmap(types []Type) []byte {
+	types = sortTypes(types)
+	var data []byte
+	for i := 0; i < len(types); {
+		window := types[i] >> 8
+		var bitmap [32]byte
+		bitmapLen := 0
+		for ; i < len(types) && types[i]>>8 == window; i++ {
+			low := types[i] & 0xFF
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			bitmapLen = int(low/8) + 1
+		}
+		data = append(data, byte(window), byte(bitmapLen))
+		data = append(data, bitmap[:bitmapLen]...)
+	}
+	return data
+}
+
+// sortTypes returns types sorted without duplicates.
+func sortTypes(types []Type) []Type {
+	sorted := append([]Type{}, types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	unique := sorted[:0]
+	for i, v := range sorted {
+		if i == 0 || v != sorted[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+func parseTypes(fields []string) ([]Type, error) {
+	var types []Type
+	for _, v := range fields {
+		type_, err := typeFromString(strings.ToUpper(v))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", err, v)
+		}
+		types = append(types, type_)
+	}
+	return sortTypes(types), nil
+}
+
+func typesString(types []Type) string {
+	texts := make([]string, len(types))
+	for i, v := range types {
+		texts[i] = v.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+type NSEC struct {
+	NextDomainName Name
+	Types          []Type
+}
+
+func decodeNSEC(msg []byte, current int, end int) (RData, error) {
+	decoded, next, err := decodeName(msg, current)
+	if err != nil {
+		return nil, err
+	}
+	if end < next {
+		return nil, ErrBadRData
+	}
+	types, err := decodeTypeBitmap(msg[next:end])
+	if err != nil {
+		return nil, err
+	}
+	return NSEC{decoded.(Name), types}, nil
+}
+
+func parseNSEC(fields []string, origin string) (RData, error) {
+	if len(fields) < 1 {
+		return nil, fmt.Errorf("invalid format: %v", fields)
+	}
+	types, err := parseTypes(fields[1:])
+	if err != nil {
+		return nil, err
+	}
+	return NSEC{Name(absName(fields[0], origin)), types}, nil
+}
+
+// MarshalBinary does not compress the next domain name (RFC 4034 Section
+// 4.1.1).
+func (nsec NSEC) MarshalBinary(msg []byte) (data []byte, err error) {
+	data, err = encodeName(nsec.NextDomainName.String())
+	if err != nil {
+		return nil, err
+	}
+	return append(data, encodeTypeBitmap(nsec.Types)...), nil
+}
+
+func (nsec NSEC) String() string {
+	if len(nsec.Types) == 0 {
+		return nsec.NextDomainName.String()
+	}
+	return fmt.Sprintf("%v %v", nsec.NextDomainName, typesString(nsec.Types))
+}
+
+// NSEC3HashSHA1 is the only NSEC3 hash algorithm (RFC 5155 Section 11).
+const NSEC3HashSHA1 = 1
+
+// NSEC3FlagOptOut is the Opt-Out flag of NSEC3 records.
+const NSEC3FlagOptOut = 1
+
+// base32Hex is the encoding of hashed owner names, which are in uppercase
+// in the presentation format.
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// NSEC3Hash returns the hash of name for NSEC3 records (RFC 5155 Section
+// 5).
+func NSEC3Hash(name Name, algorithm uint8, iterations uint16, salt []byte) ([]byte, error) {
+	if algorithm != NSEC3HashSHA1 {
+		return nil, fmt.Errorf("unsupported NSEC3 hash algorithm: %v", algorithm)
+	}
+	encoded, err := encodeName(name.Canonical().String())
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	h.Write(encoded)
+	h.Write(salt)
+	digest := h.Sum(nil)
+	for i := 0; i < int(iterations); i++ {
+		h.Reset()
+		h.Write(digest)
+		h.Write(salt)
+		digest = h.Sum(digest[:0])
+	}
+	return digest, nil
+}
+
+func parseSalt(field string) ([]byte, error) {
+	if field == "-" {
+		return []byte{}, nil
+	}
+	salt, err := hex.DecodeString(field)
+	if err != nil {
+		return nil, err
+	}
+	if 255 < len(salt) {
+		return nil, fmt.Errorf("salt length")
+	}
+	return salt, nil
+}
+
+func saltString(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%X", salt)
+}
+
+type NSEC3PARAM struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+}
+
+func decodeNSEC3PARAM(msg []byte, current int, end int) (RData, error) {
+	param, next, err := decodeNSEC3Params(msg, current, end)
+	if err != nil {
+		return nil, err
+	}
+	if next != end {
+		return nil, ErrBadRData
+	}
+	return param, nil
+}
+
+// decodeNSEC3Params decodes the fields that NSEC3 and NSEC3PARAM records
+// share.
+func decodeNSEC3Params(msg []byte, current int, end int) (NSEC3PARAM, int, error) {
+	if end < current+5 {
+		return NSEC3PARAM{}, 0, ErrBadRData
+	}
+	saltLen := int(msg[current+4])
+	if end < current+5+saltLen {
+		return NSEC3PARAM{}, 0, ErrBadRData
+	}
+	return NSEC3PARAM{
+		msg[current],
+		msg[current+1],
+		binary.BigEndian.Uint16(msg[current+2:]),
+		msg[current+5 : current+5+saltLen],
+	}, current + 5 + saltLen, nil
+}
+
+func parseNSEC3PARAM(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 4); err != nil {
+		return nil, err
+	}
+	return parseNSEC3Params(fields)
+}
+
+func parseNSEC3Params(fields []string) (NSEC3PARAM, error) {
+	var values [3]uint64
+	for i, bitSize := range []int{8, 8, 16} {
+		v, err := strconv.ParseUint(fields[i], 10, bitSize)
+		if err != nil {
+			return NSEC3PARAM{}, err
+		}
+		values[i] = v
+	}
+	salt, err := parseSalt(fields[3])
+	if err != nil {
+		return NSEC3PARAM{}, err
+	}
+	return NSEC3PARAM{uint8(values[0]), uint8(values[1]), uint16(values[2]), salt}, nil
+}
+
+func (param NSEC3PARAM) MarshalBinary(msg []byte) (data []byte, err error) {
+	if 255 < len(param.Salt) {
+		return nil, fmt.Errorf("salt length")
+	}
+	data = []byte{param.HashAlgorithm, param.Flags}
+	data = binary.BigEndian.AppendUint16(data, param.Iterations)
+	data = append(data, byte(len(param.Salt)))
+	return append(data, param.Salt...), nil
+}
+
+func (param NSEC3PARAM) String() string {
+	return fmt.Sprintf("%v %v %v %v", param.HashAlgorithm, param.Flags, param.Iterations, saltString(param.Salt))
+}
+
+// Hash returns the hash of name with the parameters.
+func (param NSEC3PARAM) Hash(name Name) ([]byte, error) {
+	return NSEC3Hash(name, param.HashAlgorithm, param.Iterations, param.Salt)
+}
+
+// HashedOwnerName returns the owner name of the NSEC3 record for name in
+// zone, the hash of name in base32hex prepended to zone.
+func (param NSEC3PARAM) HashedOwnerName(name Name, zone Name) (Name, error) {
+	hash, err := param.Hash(name)
+	if err != nil {
+		return "", err
+	}
+	label := strings.ToLower(base32Hex.EncodeToString(hash))
+	if zone == "." {
+		return Name(label + "."), nil
+	}
+	return Name(label + "." + zone.String()), nil
+}
+
+// NSEC3 is the hashed authenticated denial of existence record (RFC 5155).
+type NSEC3 struct {
+	NSEC3PARAM
+	NextHashedOwnerName []byte
+	Types               []Type
+}
+
+func decodeNSEC3(msg []byte, current int, end int) (RData, error) {
+	param, next, err := decodeNSEC3Params(msg, current, end)
+	if err != nil {
+		return nil, err
+	}
+	if end < next+1 {
+		return nil, ErrBadRData
+	}
+	hashLen := int(msg[next])
+	if hashLen == 0 || end < next+1+hashLen {
+		return nil, ErrBadRData
+	}
+	hash := msg[next+1 : next+1+hashLen]
+	types, err := decodeTypeBitmap(msg[next+1+hashLen : end])
+	if err != nil {
+		return nil, err
+	}
+	return NSEC3{param, hash, types}, nil
+}
+
+func parseNSEC3(fields []string, origin string) (RData, error) {
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("invalid format: %v", fields)
+	}
+	param, err := parseNSEC3Params(fields)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := base32Hex.DecodeString(strings.ToUpper(fields[4]))
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) == 0 || 255 < len(hash) {
+		return nil, fmt.Errorf("hash length")
+	}
+	types, err := parseTypes(fields[5:])
+	if err != nil {
+		return nil, err
+	}
+	return NSEC3{param, hash, types}, nil
+}
+
+func (nsec3 NSEC3) MarshalBinary(msg []byte) (data []byte, err error) {
+	data, err = nsec3.NSEC3PARAM.MarshalBinary(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(nsec3.NextHashedOwnerName) == 0 || 255 < len(nsec3.NextHashedOwnerName) {
+		return nil, fmt.Errorf("hash length")
+	}
+	data = append(data, byte(len(nsec3.NextHashedOwnerName)))
+	data = append(data, nsec3.NextHashedOwnerName...)
+	return append(data, encodeTypeBitmap(nsec3.Types)...), nil
+}
+
+func (nsec3 NSEC3) String() string {
+	s := fmt.Sprintf("%v %v", nsec3.NSEC3PARAM, base32Hex.EncodeToString(nsec3.NextHashedOwnerName))
+	if len(nsec3.Types) != 0 {
+		s += " " + typesString(nsec3.Types)
+	}
+	return s
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNSECTypeBitmap(t *testing.T) {
+	// RFC 4034 Section 4.3
+	rdata, err := parseNSEC(strings.Fields("host.example.com. A MX RRSIG NSEC TYPE1234"), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := rdata.MarshalBinary(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "04686f7374076578616d706c6503636f6d00" +
+		"0006400100000003" + "041b" + strings.Repeat("00", 26) + "20"
+	if hex.EncodeToString(b) != expected {
+		t.Errorf("%x", b)
+	}
+	decoded, err := decodeNSEC(b, 0, len(b))
+	if err != nil || !reflect.DeepEqual(decoded, rdata) {
+		t.Error(decoded, err)
+	}
+	if s := decoded.String(); s != "host.example.com. A MX RRSIG NSEC TYPE1234" {
+		t.Error(s)
+	}
+
+	for _, v := range []string{
+		"0001010001" + "0001ff",           // window not in order
+		"000101" + "0100",                 // empty bitmap
+		"0021" + strings.Repeat("ff", 33), // bitmap too long
+		"000240",                          // truncated
+		"00",                              // no bitmap length
+	} {
+		data, _ := hex.DecodeString(v)
+		if _, err := decodeTypeBitmap(data); err == nil {
+			t.Error(v)
+		}
+	}
+	if _, err := parseNSEC([]string{"host.example.com.", "FOO"}, "example.com."); err == nil {
+		t.Error("FOO")
+	}
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	// RFC 5155 Appendix A
+	param := NSEC3PARAM{NSEC3HashSHA1, 0, 12, []byte{0xaa, 0xbb, 0xcc, 0xdd}}
+	data := map[Name]Name{
+		"example.":     "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom.example.",
+		"a.example.":   "35mthgpgcu1qg68fab165klnsnk3dpvl.example.",
+		"A.EXAMPLE.":   "35mthgpgcu1qg68fab165klnsnk3dpvl.example.",
+		"ns1.example.": "2t7b4g4vsa5smi47k61mv5bv1a22bojr.example.",
+	}
+	for k, v := range data {
+		name, err := param.HashedOwnerName(k, "example.")
+		if err != nil || name != v {
+			t.Error(k, name, err)
+		}
+	}
+	if _, err := NSEC3Hash("example.", 2, 0, nil); err == nil {
+		t.Error("algorithm 2")
+	}
+}
+
+func TestNSEC3(t *testing.T) {
+	data := []struct {
+		type_    Type
+		text     string
+		expected string
+	}{
+		{TypeNSEC3, "1 1 12 aabbccdd 2t7b4g4vsa5smi47k61mv5bv1a22bojr MX DNSKEY NS SOA NSEC3PARAM RRSIG",
+			"1 1 12 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR NS SOA MX RRSIG DNSKEY NSEC3PARAM"},
+		{TypeNSEC3, "1 0 0 - 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR", "1 0 0 - 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR"},
+		{TypeNSEC3PARAM, "1 0 12 aabbccdd", "1 0 12 AABBCCDD"},
+		{TypeNSEC3PARAM, "1 0 0 -", "1 0 0 -"},
+	}
+	for _, v := range data {
+		type_, rdata, err := parseRData(v.type_.String(), strings.Fields(v.text), "example.")
+		if err != nil || type_ != v.type_ {
+			t.Fatal(v.text, err)
+		}
+		if s := rdata.String(); s != v.expected {
+			t.Error(s)
+		}
+		b, err := rdata.MarshalBinary(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeRData(type_, b, 0, len(b))
+		if err != nil || decoded.String() != v.expected {
+			t.Error(v.text, decoded, err)
+		}
+		if _, err := decodeRData(type_, b, 0, len(b)-1); err == nil && type_ == TypeNSEC3PARAM {
+			t.Error(v.text)
+		}
+	}
+	for _, v := range []string{
+		"1 1 12 aabbccdd",
+		"1 1 12 aabbcc-d 2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+		"1 1 12 aabbccdd 2t7b4g4vsa5smi47k61mv5bv1a22boj!",
+		"1 1 65536 aabbccdd 2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+	} {
+		if _, err := parseNSEC3(strings.Fields(v), "example."); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+func TestSignedZoneRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.zone")
+	err := os.WriteFile(path, []byte(`$ORIGIN example.
+$TTL 3600
+@ IN NSEC a.example. NS SOA MX RRSIG NSEC DNSKEY TYPE65534
+@ IN NSEC3PARAM 1 0 12 aabbccdd
+0p9mhaveqvm6t7vbl5lop2u3t2rp3tom IN NSEC3 1 1 12 aabbccdd 2t7b4g4vsa5smi47k61mv5bv1a22bojr MX DNSKEY NS SOA NSEC3PARAM RRSIG
+0p9mhaveqvm6t7vbl5lop2u3t2rp3tom IN RRSIG NSEC3 7 2 3600 20150420235959 20051021000000 40430 example. OSgWSm26B+cS+dDL8b5QrWr/dEWhtCsKlwKL IBHYH6blRxK9rC0bMJPw Q4mLIuw85H2EY762BOCXJZMnpuwhpA==
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	question := Question{"example.", TypeNSEC, ClassIN}
+	res, err := MakeResponse(0, QR, question, zone.Records, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := res.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := new(Msg)
+	err = actual.Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.AnswerResourceRecords) != len(zone.Records) {
+		t.Fatal(actual.AnswerResourceRecords)
+	}
+	for i, rr := range actual.AnswerResourceRecords {
+		if rr.String() != zone.Records[i].String() {
+			t.Error(rr, zone.Records[i])
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// packer appends a message to buf from start. names maps the names, and
+// their suffixes, appended with compression so far to their offsets, which
+// are the only targets of compression pointers (RFC 1035 Section 4.1.4).
+type packer struct {
+	buf   []byte
+	start int
+	names map[string]int
+}
+
+// offset returns the offset of the next byte in the message.
+func (p *packer) offset() int {
+	return len(p.buf) - p.start
+}
+
+// msg returns the message packed so far.
+func (p *packer) msg() []byte {
+	return p.buf[p.start:]
+}
+
+// appendName appends name, replacing the longest known suffix with a pointer
+// if compress.
+func (p *packer) appendName(name string, compress bool) error {
+	labels, starts, err := splitName(name)
+	if err != nil {
+		return err
+	}
+	wireLen := 1 // root label
+	for _, label := range labels {
+		if labelLenMax < len(label) {
+			return fmt.Errorf("%s length", escapeLabel(label))
+		}
+		wireLen += 1 + len(label)
+	}
+	if 255 < wireLen {
+		return fmt.Errorf("%s length", name)
+	}
+
+	for i, label := range labels {
+		if compress {
+			suffix := name[starts[i]:]
+			if offset, ok := p.names[suffix]; ok {
+				p.buf = append(p.buf, 0xC0|byte(offset>>8), byte(offset))
+				return nil
+			}
+			if offset := p.offset(); offset < 0x4000 {
+				if p.names == nil {
+					p.names = make(map[string]int)
+				}
+				p.names[suffix] = offset
+			}
+		}
+		p.buf = append(p.buf, byte(len(label)))
+		p.buf = append(p.buf, label...)
+	}
+	p.buf = append(p.buf, 0)
+	return nil
+}
+
+func (p *packer) appendQuestion(q Question) error {
+	err := p.appendName(q.Name.String(), true)
+	if err != nil {
+		return err
+	}
+	p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(q.Type))
+	p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(q.Class))
+	return nil
+}
+
+// compressibleTypes are the types whose RDATA names may be compressed
+// (RFC 3597 Section 4).
+var compressibleTypes = map[Type]bool{
+	TypeNS:    true,
+	TypeCNAME: true,
+	TypeSOA:   true,
+	TypePTR:   true,
+	TypeMX:    true,
+}
+
+func (p *packer) appendRData(type_ Type, rdata RData) error {
+	if compressibleTypes[type_] {
+		switch rdata := rdata.(type) {
+		case Name:
+			return p.appendName(rdata.String(), true)
+		case SOA:
+			return rdata.appendTo(p, true)
+		case MX:
+			return rdata.appendTo(p, true)
+		}
+	}
+	data, err := encodeRData(type_, rdata, p.msg())
+	if err != nil {
+		return err
+	}
+	p.buf = append(p.buf, data...)
+	return nil
+}
+
+func (p *packer) appendResourceRecord(rr ResourceRecord) error {
+	err := p.appendName(rr.Name.String(), true)
+	if err != nil {
+		return err
+	}
+	class, ttl := rr.Class, rr.TTL
+	if opt, ok := rr.RData.(*OPT); ok {
+		class, ttl = Class(opt.UDPSize), opt.ttl()
+	}
+	p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(rr.Type))
+	p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(class))
+	p.buf = binary.BigEndian.AppendUint32(p.buf, uint32(ttl))
+	rdlength := len(p.buf)
+	p.buf = append(p.buf, 0, 0)
+	err = p.appendRData(rr.Type, rr.RData)
+	if err != nil {
+		return err
+	}
+	length := len(p.buf) - rdlength - 2
+	if 0xFFFF < length {
+		return fmt.Errorf("rdata length")
+	}
+	binary.BigEndian.PutUint16(p.buf[rdlength:], uint16(length))
+	return nil
+}
+
+// encodeName returns name in the wire format without compression.
+func encodeName(name string) ([]byte, error) {
+	var p packer
+	err := p.appendName(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return p.buf, nil
+}
+
+// PackBuffer appends the wire format of m to buf, which may hold a TCP length
+// prefix for example, and returns the extended buffer. The section counts in
+// the header are taken from the sections.
+func (m *Msg) PackBuffer(buf []byte) ([]byte, error) {
+	sections := [][]ResourceRecord{m.AnswerResourceRecords, m.AuthorityResourceRecords, m.AdditionalResourceRecords}
+	for _, v := range sections {
+		if 0xFFFF < len(v) {
+			return nil, fmt.Errorf("too many records")
+		}
+	}
+	if 0xFFFF < len(m.Questions) {
+		return nil, fmt.Errorf("too many questions")
+	}
+	header := m.Header
+	header.QDCount = uint16(len(m.Questions))
+	header.ANCount = uint16(len(m.AnswerResourceRecords))
+	header.NSCount = uint16(len(m.AuthorityResourceRecords))
+	header.ARCount = uint16(len(m.AdditionalResourceRecords))
+
+	p := packer{buf: buf, start: len(buf)}
+	p.buf = append(p.buf, header.Bytes()...)
+	for _, question := range m.Questions {
+		err := p.appendQuestion(question)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, rrs := range sections {
+		for _, rr := range rrs {
+			err := p.appendResourceRecord(rr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p.buf, nil
+}
+
+// minUDPSize is the UDP message size without EDNS (RFC 1035 Section 2.3.4).
+const minUDPSize = 512
+
+// MaxUDPSize returns the size of the UDP response to req, which is the EDNS
+// UDP payload size of req between 512 and UDPSize, or 512 without EDNS.
+func MaxUDPSize(req *Msg) int {
+	opt := req.IsEDNS0()
+	if opt == nil || int(opt.UDPSize) < minUDPSize {
+		return minUDPSize
+	}
+	if UDPSize < int(opt.UDPSize) {
+		return UDPSize
+	}
+	return int(opt.UDPSize)
+}
+
+// Truncate removes whole RRsets from the end of m so that m packs in size
+// bytes. The OPT record is kept. TC is set if answer or authority RRsets are
+// removed, as the additional section is optional.
+func (m *Msg) Truncate(size int) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	if len(packed) <= size {
+		return nil
+	}
+
+	var optRRs []ResourceRecord
+	additionals := make([]ResourceRecord, 0, len(m.AdditionalResourceRecords))
+	for _, rr := range m.AdditionalResourceRecords {
+		if rr.Type == TypeOPT {
+			optRRs = append(optRRs, rr)
+		} else {
+			additionals = append(additionals, rr)
+		}
+	}
+	m.AdditionalResourceRecords = additionals
+	reserved := 0
+	for _, rr := range optRRs {
+		b, err := rr.Bytes(nil)
+		if err != nil {
+			return err
+		}
+		reserved += len(b)
+	}
+
+	p := packer{buf: make([]byte, headerSize, size)}
+	for _, question := range m.Questions {
+		err := p.appendQuestion(question)
+		if err != nil {
+			return err
+		}
+	}
+	sections := []*[]ResourceRecord{&m.AnswerResourceRecords, &m.AuthorityResourceRecords, &m.AdditionalResourceRecords}
+	truncated := false
+	for i, section := range sections {
+		rrs := *section
+		if truncated {
+			*section = rrs[:0]
+			continue
+		}
+		n := 0
+		for n < len(rrs) {
+			end := n + 1
+			for end < len(rrs) && rrs[end].Name == rrs[n].Name && rrs[end].Type == rrs[n].Type && rrs[end].Class == rrs[n].Class {
+				end++
+			}
+			for _, rr := range rrs[n:end] {
+				err := p.appendResourceRecord(rr)
+				if err != nil {
+					return err
+				}
+			}
+			if size < len(p.buf)+reserved {
+				truncated = true
+				if i < 2 {
+					m.Header.SetFlag(TC, true)
+				}
+				break
+			}
+			n = end
+		}
+		*section = rrs[:n]
+	}
+	m.AdditionalResourceRecords = append(m.AdditionalResourceRecords, optRRs...)
+	m.Header.ANCount = uint16(len(m.AnswerResourceRecords))
+	m.Header.NSCount = uint16(len(m.AuthorityResourceRecords))
+	m.Header.ARCount = uint16(len(m.AdditionalResourceRecords))
+	return nil
+}
@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func TestPackerAppendName(t *testing.T) {
+	data := []struct {
+		name     string
+		expected []byte
+	}{
+		{"example.com.", []byte("\x07example\x03com\x00")},
+		{"www.example.com.", []byte("\x03www\xC0\x00")},
+		{"mx1.example.com.", []byte("\x03mx1\xC0\x00")},
+		{"www.example.com.", []byte("\xC0\x0D")},
+		{"com.", []byte("\xC0\x08")},
+		{"example.net.", []byte("\x07example\x03net\x00")},
+		{".", []byte("\x00")},
+	}
+
+	var p packer
+	for _, v := range data {
+		offset := len(p.buf)
+		err := p.appendName(v.name, true)
+		if err != nil {
+			t.Fatal(v, err)
+		}
+		if !bytes.Equal(p.buf[offset:], v.expected) {
+			t.Errorf("%v: %x", v.name, p.buf[offset:])
+		}
+	}
+}
+
+func TestPackBuffer(t *testing.T) {
+	// the RDATA of TXT looks like example.com. but must not be a target
+	m := &Msg{
+		Header: Header{ID: 1, Fields: QR},
+		Questions: []Question{
+			{"txt.example.net.", TypeTXT, ClassIN},
+		},
+		AnswerResourceRecords: []ResourceRecord{
+			{"txt.example.net.", TypeTXT, ClassIN, 300, TXT("example\x00com\x00")},
+			{"example.com.", TypeA, ClassIN, 300, A(netip.MustParseAddr("192.0.2.1"))},
+			{"example.com.", TypeMX, ClassIN, 300, MX{10, "mx.example.com."}},
+		},
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(packed, []byte("\x07example\x03com\x00")); n != 2 {
+		t.Errorf("%x", packed)
+	}
+	parsed := new(Msg)
+	err = parsed.Unpack(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rr := range parsed.AnswerResourceRecords {
+		if rr.String() != m.AnswerResourceRecords[i].String() {
+			t.Error(rr)
+		}
+	}
+
+	// appended after a TCP length prefix
+	buf, err := m.PackBuffer([]byte{0xFF, 0xFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:2], []byte{0xFF, 0xFF}) || !bytes.Equal(buf[2:], packed) {
+		t.Errorf("%x", buf)
+	}
+}
+
+func longTXTMsg(b *testing.B, n int) *Msg {
+	zone, err := ReadZonefile("testdata/zones/example.com.zone")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var long []ResourceRecord
+	for _, rr := range zone.Records {
+		if rr.Name == "long.example.com." && rr.Type == TypeTXT {
+			long = append(long, rr)
+		}
+	}
+	m := &Msg{Header: Header{Fields: QR}}
+	for i := 0; i < n; i++ {
+		rr := long[i%len(long)]
+		rr.Name = Name(fmt.Sprintf("long%v.example.com.", i/len(long)))
+		m.AnswerResourceRecords = append(m.AnswerResourceRecords, rr)
+	}
+	return m
+}
+
+func BenchmarkMsgPack(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		m := longTXTMsg(b, n)
+		b.Run(fmt.Sprint("records=", n), func(b *testing.B) {
+			b.ReportAllocs()
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				buf, err = m.PackBuffer(buf[:0])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	newMsg := func() *Msg {
+		m := &Msg{Header: Header{Fields: QR}}
+		for i := 0; i < 10; i++ {
+			m.AnswerResourceRecords = append(m.AnswerResourceRecords,
+				ResourceRecord{"long.example.com.", TypeTXT, ClassIN, 300, TXT(fmt.Sprintf("%050d", i))})
+		}
+		m.AdditionalResourceRecords = []ResourceRecord{
+			{"ns.example.com.", TypeA, ClassIN, 300, A(netip.MustParseAddr("192.0.2.1"))},
+		}
+		return m.SetEDNS0(UDPSize, false)
+	}
+	packed, err := newMsg().Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		size        int
+		tc          bool
+		answers     int
+		additionals int
+	}{
+		{len(packed), false, 10, 2},
+		{len(packed) - 1, false, 10, 1}, // glue dropped
+		{512, true, 0, 1},               // the TXT RRset does not fit
+	}
+	for _, v := range data {
+		m := newMsg()
+		err := m.Truncate(v.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.size < len(b) || m.Header.Flag(TC) != v.tc || m.IsEDNS0() == nil ||
+			len(m.AnswerResourceRecords) != v.answers || len(m.AdditionalResourceRecords) != v.additionals {
+			t.Error(v, len(b), m.Header.Flag(TC), len(m.AnswerResourceRecords), len(m.AdditionalResourceRecords))
+		}
+	}
+}
+
+func TestMaxUDPSize(t *testing.T) {
+	req := new(Msg)
+	if size := MaxUDPSize(req); size != 512 {
+		t.Error(size)
+	}
+	for _, v := range [][2]int{{4096, UDPSize}, {1232, 1232}, {100, 512}} {
+		req.SetEDNS0(uint16(v[0]), false)
+		if size := MaxUDPSize(req); size != v[1] {
+			t.Error(v, size)
+		}
+	}
+}
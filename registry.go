@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RDataCodec converts the RDATA of a record type between the wire and the
+// presentation format.
+type RDataCodec struct {
+	// Decode reads RDATA from msg[current:end]. msg holds the whole message
+	// so that compressed names can be followed.
+	Decode func(msg []byte, current int, end int) (RData, error)
+
+	// Encode returns the wire format of rdata. msg is the message built so
+	// far and may be used for name compression. If nil, rdata.MarshalBinary
+	// is used.
+	Encode func(rdata RData, msg []byte) ([]byte, error)
+
+	// Parse reads RDATA from the fields of a zone file line. Relative names
+	// are completed with origin.
+	Parse func(fields []string, origin string) (RData, error)
+
+	// Print returns the presentation format of rdata. If nil, rdata.String
+	// is used.
+	Print func(rdata RData) string
+}
+
+type typeEntry struct {
+	text  string
+	codec RDataCodec
+}
+
+var (
+	typeRegistryMu   sync.RWMutex
+	typeRegistry     = make(map[Type]*typeEntry)
+	typeTextRegistry = make(map[string]Type)
+)
+
+func init() {
+	builtins := []struct {
+		type_ Type
+		text  string
+		codec RDataCodec
+	}{
+		{TypeA, "A", RDataCodec{Decode: decodeA, Parse: parseA}},
+		{TypeNS, "NS", RDataCodec{Decode: decodeNameRData, Parse: parseNS}},
+		{TypeCNAME, "CNAME", RDataCodec{Decode: decodeNameRData, Parse: parseNameRData}},
+		{TypeSOA, "SOA", RDataCodec{Decode: decodeSOA, Parse: parseSOA}},
+		{TypePTR, "PTR", RDataCodec{Decode: decodeNameRData, Parse: parseNameRData}},
+		{TypeMX, "MX", RDataCodec{Decode: decodeMX, Parse: parseMX}},
+		{TypeTXT, "TXT", RDataCodec{Decode: decodeTXT, Parse: parseTXT}},
+		{TypeAAAA, "AAAA", RDataCodec{Decode: decodeAAAA, Parse: parseAAAA}},
+		{TypeSRV, "SRV", RDataCodec{Decode: decodeSRV, Parse: parseSRV}},
+		{TypeNAPTR, "NAPTR", RDataCodec{Decode: decodeNAPTR, Parse: parseNAPTR}},
+		{TypeOPT, "OPT", RDataCodec{}},
+		{TypeDS, "DS", RDataCodec{Decode: decodeDS, Parse: parseDS}},
+		{TypeSSHFP, "SSHFP", RDataCodec{Decode: decodeSSHFP, Parse: parseSSHFP}},
+		{TypeRRSIG, "RRSIG", RDataCodec{Decode: decodeRRSIG, Parse: parseRRSIG}},
+		{TypeNSEC, "NSEC", RDataCodec{Decode: decodeNSEC, Parse: parseNSEC}},
+		{TypeDNSKEY, "DNSKEY", RDataCodec{Decode: decodeDNSKEY, Parse: parseDNSKEY}},
+		{TypeNSEC3, "NSEC3", RDataCodec{Decode: decodeNSEC3, Parse: parseNSEC3}},
+		{TypeNSEC3PARAM, "NSEC3PARAM", RDataCodec{Decode: decodeNSEC3PARAM, Parse: parseNSEC3PARAM}},
+		{TypeTLSA, "TLSA", RDataCodec{Decode: decodeTLSA, Parse: parseTLSA}},
+		{TypeSVCB, "SVCB", RDataCodec{Decode: decodeSVCB, Parse: parseSVCB}},
+		{TypeHTTPS, "HTTPS", RDataCodec{Decode: decodeHTTPS, Parse: parseHTTPS}},
+		{TypeCAA, "CAA", RDataCodec{Decode: decodeCAA, Parse: parseCAA}},
+	}
+	for _, v := range builtins {
+		if err := RegisterType(v.type_, v.text, v.codec); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterType adds a record type with its mnemonic and codec, so that it
+// can be parsed and printed in messages and zone files.
+func RegisterType(type_ Type, text string, codec RDataCodec) error {
+	text = strings.ToUpper(text)
+	if text == "" {
+		return fmt.Errorf("empty type text")
+	}
+
+	typeRegistryMu.Lock()
+	defer typeRegistryMu.Unlock()
+	if _, ok := typeRegistry[type_]; ok {
+		return fmt.Errorf("type already registered: %v", uint16(type_))
+	}
+	if _, ok := typeTextRegistry[text]; ok {
+		return fmt.Errorf("type text already registered: %v", text)
+	}
+	typeRegistry[type_] = &typeEntry{text, codec}
+	typeTextRegistry[text] = type_
+	return nil
+}
+
+func lookupType(type_ Type) (*typeEntry, bool) {
+	typeRegistryMu.RLock()
+	defer typeRegistryMu.RUnlock()
+	entry, ok := typeRegistry[type_]
+	return entry, ok
+}
+
+func lookupTypeText(text string) (Type, bool) {
+	typeRegistryMu.RLock()
+	defer typeRegistryMu.RUnlock()
+	type_, ok := typeTextRegistry[text]
+	return type_, ok
+}
+
+// registeredTypes returns the registered types in ascending order.
+func registeredTypes() []Type {
+	typeRegistryMu.RLock()
+	defer typeRegistryMu.RUnlock()
+	types := make([]Type, 0, len(typeRegistry))
+	for type_ := range typeRegistry {
+		types = append(types, type_)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func decodeRData(type_ Type, msg []byte, current int, end int) (RData, error) {
+	entry, ok := lookupType(type_)
+	if !ok || entry.codec.Decode == nil {
+		return decodeUnknownRData(msg, current, end)
+	}
+	return entry.codec.Decode(msg, current, end)
+}
+
+func encodeRData(type_ Type, rdata RData, msg []byte) ([]byte, error) {
+	entry, ok := lookupType(type_)
+	if !ok || entry.codec.Encode == nil {
+		return rdata.MarshalBinary(msg)
+	}
+	return entry.codec.Encode(rdata, msg)
+}
+
+func parseRData(text string, fields []string, origin string) (Type, RData, error) {
+	type_, err := typeFromString(text)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", err, text)
+	}
+	entry, ok := lookupType(type_)
+	if 0 < len(fields) && fields[0] == `\#` {
+		// RFC 3597 generic format
+		data, err := parseUnknownRData(fields)
+		if err != nil {
+			return 0, nil, err
+		}
+		rdata, err := decodeRData(type_, data, 0, len(data))
+		if err != nil {
+			return 0, nil, err
+		}
+		return type_, rdata, nil
+	}
+	if !ok || entry.codec.Parse == nil {
+		return 0, nil, fmt.Errorf("unsupported type: %v", text)
+	}
+	rdata, err := entry.codec.Parse(fields, origin)
+	if err != nil {
+		return 0, nil, err
+	}
+	return type_, rdata, nil
+}
+
+func printRData(type_ Type, rdata RData) string {
+	entry, ok := lookupType(type_)
+	if !ok || entry.codec.Print == nil {
+		return rdata.String()
+	}
+	return entry.codec.Print(rdata)
+}
@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// privateRData is an RDATA of a private use type holding a single number.
+type privateRData uint32
+
+func (p privateRData) MarshalBinary(msg []byte) (data []byte, err error) {
+	return binary.BigEndian.AppendUint32(nil, uint32(p)), nil
+}
+
+func (p privateRData) String() string {
+	return fmt.Sprint(uint32(p))
+}
+
+const typePrivate Type = 65280
+
+func init() {
+	err := RegisterType(typePrivate, "PRIVATE", RDataCodec{
+		Decode: func(msg []byte, current int, end int) (RData, error) {
+			if end != current+4 {
+				return nil, ErrBadRData
+			}
+			return privateRData(binary.BigEndian.Uint32(msg[current:])), nil
+		},
+		Parse: func(fields []string, origin string) (RData, error) {
+			v, err := strconv.ParseUint(fields[0], 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			return privateRData(v), nil
+		},
+		Print: func(rdata RData) string {
+			return fmt.Sprintf("#%v", rdata)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	if err := RegisterType(TypeA, "A2", RDataCodec{}); err == nil {
+		t.Error("registered type twice")
+	}
+	if err := RegisterType(65281, "a", RDataCodec{}); err == nil {
+		t.Error("registered type text twice")
+	}
+	if typePrivate.String() != "PRIVATE" {
+		t.Error(typePrivate.String())
+	}
+	if type_, err := typeFromString("PRIVATE"); err != nil || type_ != typePrivate {
+		t.Error(type_, err)
+	}
+}
+
+func TestPrivateTypeMessage(t *testing.T) {
+	answers := []ResourceRecord{
+		{Name("example.com."), typePrivate, ClassIN, 3600, privateRData(42)},
+	}
+	res, err := MakeResponse(0, QR, Question{Name("example.com."), typePrivate, ClassIN}, answers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := res.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := new(Msg)
+	err = parsed.Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.AnswerResourceRecords[0] != answers[0] {
+		t.Error(parsed.AnswerResourceRecords[0])
+	}
+	if s := parsed.AnswerResourceRecords[0].String(); s != "example.com. 3600 IN PRIVATE #42" {
+		t.Error(s)
+	}
+}
+
+func TestPrivateTypeZonefile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	err := os.WriteFile(path, []byte("$ORIGIN example.com.\n$TTL 3600\n@ IN PRIVATE 42\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ResourceRecord{"example.com.", typePrivate, ClassIN, 3600, privateRData(42)}
+	if zone.Records[0] != expected {
+		t.Error(zone.Records[0])
+	}
+}
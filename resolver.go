@@ -1,7 +1,10 @@
 package dns
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/netip"
 	"time"
 )
 
@@ -11,7 +14,7 @@ var RootServers []ResourceRecord
 
 var rootServer string
 
-var rootDS *DS
+var rootDSs []DS
 
 func SetUpResolver(zone, rootAnchorsXML string) error {
 	var err error
@@ -19,7 +22,7 @@ func SetUpResolver(zone, rootAnchorsXML string) error {
 	if err != nil {
 		return err
 	}
-	rootDS, err = getRootAnchorDS(rootAnchorsXML)
+	rootDSs, err = getRootAnchorDSs(rootAnchorsXML, time.Now())
 	if err != nil {
 		return err
 	}
@@ -46,12 +49,254 @@ func LoadRootZone(path string) error {
 
 const QNameMinType = TypeNS
 
-func Resolve(question Question, qNameMin bool, dnssec bool, client Client, cache *Cache) (rrs []ResourceRecord, ad bool, err error) {
+// Client subnet (RFC 7871) settings of the resolver. The client subnet is
+// sent only to the name servers in ECSServers, truncated to ECSSourcePrefix4
+// or ECSSourcePrefix6 bits.
+var (
+	ECSServers       []netip.Prefix
+	ECSSourcePrefix4 = 24
+	ECSSourcePrefix6 = 56
+)
+
+// truncateClientSubnet returns subnet truncated to the source prefix length,
+// or the zero Prefix if no subnet should be sent.
+func truncateClientSubnet(subnet netip.Prefix) netip.Prefix {
+	if !subnet.IsValid() || subnet.Bits() == 0 {
+		return netip.Prefix{}
+	}
+	addr := subnet.Addr().Unmap()
+	bits := ECSSourcePrefix6
+	if addr.Is4() {
+		bits = ECSSourcePrefix4
+	}
+	if subnet.Bits() < bits {
+		bits = subnet.Bits()
+	}
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+func isECSServer(nameServer string) bool {
+	addr, err := netip.ParseAddr(nameServer)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range ECSServers {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryNameServer sends question to nameServer, with subnet if nameServer is
+// in ECSServers. It returns the scope prefix length of the response.
+func queryNameServer(client Client, nameServer string, question Question, dnssec bool, subnet netip.Prefix) (*Msg, uint8, error) {
 	edns := dnssec
+	req, err := newQuery(question, false, edns, dnssec)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !subnet.IsValid() || !isECSServer(nameServer) {
+		res, err := client.Exchange("udp", nameServer+":53", req)
+		return res, 0, err
+	}
+	req.SetEDNS0(UDPSize, dnssec)
+	ecs := NewEDNS0ClientSubnet(subnet)
+	req.IsEDNS0().SetOption(ecs)
+	res, err := client.Exchange("udp", nameServer+":53", req)
+	if err != nil {
+		return res, 0, err
+	}
+	var scope uint8
+	if opt := res.IsEDNS0(); opt != nil {
+		// ignore the option not matching the query
+		resECS, ok := opt.Option(EDNS0CodeClientSubnet).(EDNS0ClientSubnet)
+		if ok && resECS.SourcePrefix == ecs.SourcePrefix && resECS.Address == ecs.Address {
+			scope = resECS.ScopePrefix
+			if ecs.SourcePrefix < scope {
+				scope = ecs.SourcePrefix
+			}
+		}
+	}
+	return res, scope, nil
+}
+
+// clientSubnetCacheKey is the cache key of answers for the client subnet.
+type clientSubnetCacheKey struct {
+	Question
+	subnet netip.Prefix
+}
+
+// storeAnswers stores answers for the scope of subnet, or for all clients if
+// the scope is 0.
+func storeAnswers(rrSets RRSets, cache *Cache, now int64, subnet netip.Prefix, scope uint8) {
+	if scope == 0 {
+		storeRRSets(rrSets, cache, now)
+		return
+	}
+	subnet = netip.PrefixFrom(subnet.Addr(), int(scope)).Masked()
+	for k, v := range rrSets {
+		cache.Set(clientSubnetCacheKey{k, subnet}, v, now+int64(v.TTL))
+	}
+}
+
+// getClientSubnetCache returns the answer cached for a scope containing
+// subnet, longest first.
+func getClientSubnetCache(cache *Cache, question Question, subnet netip.Prefix, now int64) ([]ResourceRecord, uint8, bool) {
+	if !subnet.IsValid() {
+		return nil, 0, false
+	}
+	for bits := subnet.Bits(); 0 < bits; bits-- {
+		key := clientSubnetCacheKey{question.canonical(), netip.PrefixFrom(subnet.Addr(), bits).Masked()}
+		if val, ttl, ok := cache.Get(key, now); ok {
+			rrSet := *val.(*RRSet)
+			rrSet.TTL = TTL(ttl)
+			return rrSet.ResourceRecords(), uint8(bits), true
+		}
+	}
+	return nil, 0, false
+}
+
+// ResolveError is an error of Resolve with the RCODE and the extended DNS
+// error (RFC 8914) info-code to respond with.
+type ResolveError struct {
+	Rcode    uint16
+	InfoCode uint16
+	Err      error
+}
+
+func newResolveError(rcode uint16, infoCode uint16, err error) *ResolveError {
+	return &ResolveError{rcode, infoCode, err}
+}
+
+func (e *ResolveError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// ExtendedError returns the EDE option with the error message as the extra
+// text. ok is false for NXDOMAIN, which needs no explanation.
+func (e *ResolveError) ExtendedError() (ede EDNS0ExtendedError, ok bool) {
+	if e.Rcode == NXDOMAIN {
+		return ede, false
+	}
+	return EDNS0ExtendedError{e.InfoCode, e.Error()}, true
+}
+
+// queryError returns the error of the query to nameServer.
+func queryError(nameServer string, err error) error {
+	var resolveErr *ResolveError
+	if errors.As(err, &resolveErr) {
+		return err
+	}
+	infoCode := EDENetworkError
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		infoCode = EDENoReachableAuthority
+	}
+	return newResolveError(SERVFAIL, infoCode, fmt.Errorf("query to %v: %w", nameServer, err))
+}
+
+// rcodeError returns the error for the RCODE of res from nameServer, or nil.
+func rcodeError(nameServer string, res *Msg) error {
+	switch rcode := res.Rcode(); rcode {
+	case NOERROR:
+		return nil
+	case NXDOMAIN:
+		return newResolveError(NXDOMAIN, EDEOtherError, fmt.Errorf("%v: %v", nameServer, statusText(rcode)))
+	default:
+		return newResolveError(SERVFAIL, EDENoReachableAuthority, fmt.Errorf("%v: %v", nameServer, statusText(rcode)))
+	}
+}
+
+// lameError returns the error for a response of nameServer that neither
+// answers nor refers.
+func lameError(nameServer string, question Question) error {
+	return newResolveError(SERVFAIL, EDENoReachableAuthority, fmt.Errorf("lame delegation: %v for %v", nameServer, question))
+}
+
+func Resolve(question Question, qNameMin bool, dnssec bool, client Client, cache *Cache) (rrs []ResourceRecord, ad bool, err error) {
+	rrs, ad, _, err = ResolveWithClientSubnet(question, netip.Prefix{}, qNameMin, dnssec, client, cache)
+	return
+}
+
+// maxAliasChain is the number of SVCB AliasMode records followed.
+const maxAliasChain = 8
+
+// aliasTarget returns the target of the AliasMode record for question in
+// rrs, unless rrs has ServiceMode records for question (RFC 9460 Section
+// 2.4.2).
+func aliasTarget(question Question, rrs []ResourceRecord) (Name, bool) {
+	var target Name
+	for _, rr := range rrs {
+		if rr.Type != question.Type || !rr.Name.Equal(question.Name) {
+			continue
+		}
+		var svcb SVCB
+		switch rdata := rr.RData.(type) {
+		case SVCB:
+			svcb = rdata
+		case HTTPS:
+			svcb = rdata.SVCB
+		default:
+			continue
+		}
+		if !svcb.IsAlias() {
+			return "", false
+		}
+		if svcb.Target != "." {
+			target = Name(svcb.Target)
+		}
+	}
+	return target, target != ""
+}
+
+// ResolveWithClientSubnet is Resolve sending subnet in the client subnet
+// option to the name servers in ECSServers. It returns the scope prefix
+// length of the answer as well. For SVCB and HTTPS questions, AliasMode
+// records are followed like CNAME records, and the records in the chain are
+// returned; the chain followed so far is returned if following fails.
+func ResolveWithClientSubnet(question Question, subnet netip.Prefix, qNameMin bool, dnssec bool, client Client, cache *Cache) (rrs []ResourceRecord, ad bool, scope uint8, err error) {
+	rrs, ad, scope, err = resolve(question, subnet, qNameMin, dnssec, client, cache)
+	if err != nil || (question.Type != TypeSVCB && question.Type != TypeHTTPS) {
+		return
+	}
+	visited := map[Name]bool{question.Name.Canonical(): true}
+	for name := question.Name; ; {
+		target, ok := aliasTarget(Question{name, question.Type, question.Class}, rrs)
+		if !ok {
+			return
+		}
+		if maxAliasChain < len(visited) || visited[target.Canonical()] {
+			Log.Debugf("Resolve: alias chain too long or looping: %v", target)
+			return
+		}
+		visited[target.Canonical()] = true
+		Log.Debugf("Resolve: follow alias: %v", target)
+		next, nextAD, nextScope, err := resolve(Question{target, question.Type, question.Class}, subnet, qNameMin, dnssec, client, cache)
+		if err != nil {
+			Log.Debugf("Resolve: follow alias: %v", err)
+			return rrs, ad, scope, nil
+		}
+		rrs = append(rrs[:len(rrs):len(rrs)], next...)
+		ad = ad && nextAD
+		if scope < nextScope {
+			scope = nextScope
+		}
+		name = target
+	}
+}
+
+func resolve(question Question, subnet netip.Prefix, qNameMin bool, dnssec bool, client Client, cache *Cache) (rrs []ResourceRecord, ad bool, scope uint8, err error) {
+	subnet = truncateClientSubnet(subnet)
 	Log.Debugf("Resolve: question: %v", question)
 	nameServer := rootServer
 	var zoneName string
-	dnssecDSs := []DS{*rootDS}
+	dnssecDSs := rootDSs
+	insecure := false // the DS records have unsupported algorithms only
 	if client == nil {
 		client = &BasicClient{Limit: 20}
 	}
@@ -60,13 +305,17 @@ func Resolve(question Question, qNameMin bool, dnssec bool, client Client, cache
 	}
 
 	now := time.Now().Unix()
-	val, ttl, ok := cache.Get(question, now)
+	if rrs, scope, ok := getClientSubnetCache(cache, question, subnet, now); ok {
+		Log.Debugf("Resolve: cache hit: scope: %v", scope)
+		return rrs, false, scope, nil
+	}
+	val, ttl, ok := cache.Get(question.canonical(), now)
 	if ok {
 		// cache hit
 		Log.Debugf("Resolve: cache hit")
 		rrSet := *val.(*RRSet)
 		rrSet.TTL = TTL(ttl)
-		return rrSet.ResourceRecords(), false, nil
+		return rrSet.ResourceRecords(), false, 0, nil
 	} else {
 		// cache miss
 		Log.Debugf("Resolve: cache miss")
@@ -81,25 +330,35 @@ LOOP:
 			pquestion = Question{Name(pname), QNameMinType, ClassIN}
 		}
 		Log.Debugf("Resolve: send request: @%v %v", nameServer, pquestion)
-		res, err := client.Do("udp", nameServer+":53", pquestion, false, edns, dnssec)
+		res, scope, err := queryNameServer(client, nameServer, pquestion, dnssec, subnet)
 		if err != nil {
-			return nil, false, err
+			return nil, false, 0, queryError(nameServer, err)
+		}
+		if err := rcodeError(nameServer, res); err != nil {
+			return nil, false, 0, err
 		}
 		answerRRSets := NewRRSets(res.AnswerResourceRecords)
 		authorityRRSets := NewRRSets(res.AuthorityResourceRecords)
 		additionalRRSets := NewRRSets(res.AdditionalResourceRecords)
-		if dnssec {
-			dsRRSet, ok := authorityRRSets[Question{pquestion.Name, TypeDS, ClassIN}]
+		if dnssec && !insecure {
+			dsRRSet, ok := authorityRRSets.Get(Question{pquestion.Name, TypeDS, ClassIN})
 			if ok {
-				rrsigRRSet := authorityRRSets[Question{pquestion.Name, TypeRRSIG, ClassIN}]
-				zsk, err := getZSK(pquestion.Name.parent(), nameServer, dnssecDSs, client)
-				if err != nil {
-					return nil, false, fmt.Errorf("failed getZSK, pquestion: %v, %w", pquestion, err)
+				rrsigRRSet, ok := authorityRRSets.Get(Question{pquestion.Name, TypeRRSIG, ClassIN})
+				if !ok {
+					return nil, false, 0, newResolveError(SERVFAIL, EDERRSIGsMissing, fmt.Errorf("not found RRSIG of DS, pquestion: %v", pquestion))
 				}
-				Log.Debugf("Resolve: verifyRRSet: %v, %x,  %v, %v", pquestion, zsk, dsRRSet, rrsigRRSet.RDatas[0].(RRSIG))
-				err = verifyRRSet(zsk, dsRRSet, rrsigRRSet.RDatas[0].(RRSIG))
-				if err != nil {
-					return nil, false, fmt.Errorf("failed verifyRRSet, pquestion: %v, %w", pquestion, err)
+				keys, err := getDNSKEYs(pquestion.Name.parent(), nameServer, dnssecDSs, client)
+				if errors.Is(err, ErrUnsupportedAlgorithm) {
+					Log.Debugf("Resolve: insecure: %v, %v", pquestion, err)
+					insecure = true
+				} else if err != nil {
+					return nil, false, 0, fmt.Errorf("failed getDNSKEYs, pquestion: %v, %w", pquestion, err)
+				} else {
+					Log.Debugf("Resolve: verifyRRSet: %v, %v, %v", pquestion, dsRRSet, rrsigRRSet)
+					err = verifyRRSIGs(keys, dsRRSet, rrsigRRSet)
+					if err != nil {
+						return nil, false, 0, newResolveError(SERVFAIL, bogusInfoCode(err), fmt.Errorf("failed verifyRRSet, pquestion: %v, %w", pquestion, err))
+					}
 				}
 				dnssecDSs = nil
 				for _, v := range dsRRSet.RDatas {
@@ -107,17 +366,17 @@ LOOP:
 				}
 			}
 		}
-		storeRRSets(answerRRSets, cache, now)
+		storeAnswers(answerRRSets, cache, now, subnet, scope)
 		storeRRSets(authorityRRSets, cache, now)
 		storeRRSets(additionalRRSets, cache, now)
 
 		if len(res.AnswerResourceRecords) != 0 && pquestion == question {
-			return res.AnswerResourceRecords, false, nil
+			return res.AnswerResourceRecords, false, scope, nil
 		}
 
-		rrSet, ok := additionalRRSets[question]
+		rrSet, ok := additionalRRSets.Get(question)
 		if ok {
-			return rrSet.ResourceRecords(), false, nil
+			return rrSet.ResourceRecords(), false, 0, nil
 		}
 
 		if len(res.AuthorityResourceRecords) != 0 {
@@ -126,7 +385,7 @@ LOOP:
 				for _, v := range authorityRRSet.RDatas {
 					nsname, ok := v.(NS)
 					if ok {
-						rrSet, ok := additionalRRSets[Question{nsname, TypeA, ClassIN}]
+						rrSet, ok := additionalRRSets.Get(Question{nsname, TypeA, ClassIN})
 						if ok {
 							rrs := rrSet.ResourceRecords()
 							nameServer = rrs[0].RData.String()
@@ -143,55 +402,65 @@ LOOP:
 				if qNameMin {
 					continue
 				} else {
-					return nil, false, fmt.Errorf("ERR")
+					return nil, false, 0, lameError(nameServer, pquestion)
 				}
 			}
 			question := Question{nsname, TypeA, ClassIN}
 			rrs, _, err := Resolve(question, qNameMin, dnssec, client, cache)
-			if err != nil {
-				return nil, false, err
+			if err == nil && len(rrs) == 0 {
+				err = fmt.Errorf("no address")
 			}
-			if len(rrs) == 0 {
-				return nil, false, fmt.Errorf("ERR")
+			if err != nil {
+				return nil, false, 0, newResolveError(SERVFAIL, EDENoReachableAuthority, fmt.Errorf("name server %v: %w", nsname, err))
 			}
 			nameServer = rrs[0].RData.String()
 			zoneName = pname
 			continue
 		}
-		return nil, false, fmt.Errorf("ERR")
+		return nil, false, 0, lameError(nameServer, pquestion)
 	}
 
 	Log.Debugf("Resolve: send request: @%v %v", nameServer, question)
-	res, err := client.Do("udp", nameServer+":53", question, false, edns, dnssec)
+	res, scope, err := queryNameServer(client, nameServer, question, dnssec, subnet)
 	if err != nil {
-		return nil, false, err
+		return nil, false, 0, queryError(nameServer, err)
+	}
+	if err := rcodeError(nameServer, res); err != nil {
+		return nil, false, 0, err
 	}
 	if len(res.AnswerResourceRecords) != 0 {
 		answerRRSets := NewRRSets(res.AnswerResourceRecords)
 
-		if dnssec {
-			rrSet, ok := answerRRSets[question]
+		if dnssec && !insecure {
+			rrSet, ok := answerRRSets.Get(question)
 			if ok {
-				rrsigRRSet := answerRRSets[Question{question.Name, TypeRRSIG, question.Class}]
-				zsk, err := getZSK(Name(zoneName), nameServer, dnssecDSs, client)
-				if err != nil {
-					return nil, false, fmt.Errorf("failed getZSK, question: %v, %w", question, err)
+				rrsigRRSet, ok := answerRRSets.Get(Question{question.Name, TypeRRSIG, question.Class})
+				if !ok {
+					return nil, false, 0, newResolveError(SERVFAIL, EDERRSIGsMissing, fmt.Errorf("not found RRSIG, question: %v", question))
 				}
-				Log.Debugf("Resolve: verifyRRSet: %v, %x, %v,  %v", question, zsk, rrSet, rrsigRRSet.RDatas[0].(RRSIG))
-				err = verifyRRSet(zsk, rrSet, rrsigRRSet.RDatas[0].(RRSIG))
-				if err != nil {
-					return nil, false, fmt.Errorf("failed verifyRRSet, question: %v, %w", question, err)
+				keys, err := getDNSKEYs(Name(zoneName), nameServer, dnssecDSs, client)
+				if errors.Is(err, ErrUnsupportedAlgorithm) {
+					Log.Debugf("Resolve: insecure: %v, %v", question, err)
+				} else if err != nil {
+					return nil, false, 0, fmt.Errorf("failed getDNSKEYs, question: %v, %w", question, err)
+				} else {
+					Log.Debugf("Resolve: verifyRRSet: %v, %v, %v", question, rrSet, rrsigRRSet)
+					err = verifyRRSIGs(keys, rrSet, rrsigRRSet)
+					if err != nil {
+						return nil, false, 0, newResolveError(SERVFAIL, bogusInfoCode(err), fmt.Errorf("failed verifyRRSet, question: %v, %w", question, err))
+					}
+					ad = true
 				}
-				ad = true
 			}
 		}
 
-		storeRRSets(answerRRSets, cache, now)
+		storeAnswers(answerRRSets, cache, now, subnet, scope)
 		Log.Debugf("Resolve: return: %v", res.AnswerResourceRecords)
-		return res.AnswerResourceRecords, ad, nil
+		return res.AnswerResourceRecords, ad, scope, nil
 	}
 
-	return nil, false, fmt.Errorf("ERR2")
+	// no data
+	return nil, false, 0, nil
 }
 
 func storeRRSets(rrSets RRSets, cache *Cache, now int64) {
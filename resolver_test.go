@@ -1,10 +1,16 @@
 package dns
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net"
 	"net/netip"
+	"os"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 func resolverTestSetUp(t *testing.T) {
@@ -84,7 +90,8 @@ type MockClient struct {
 }
 
 func NewMockClient() *MockClient {
-	var data sync.Map
+	mock := new(MockClient)
+	data := &mock.data
 
 	storeData := func(address string, question Question, answers, authorities, additionals []ResourceRecord) {
 		response, _ := MakeResponse(0, 0, question, answers, authorities, additionals)
@@ -219,16 +226,201 @@ func NewMockClient() *MockClient {
 			{Name("ns2.jprs.co.jp."), TypeAAAA, ClassIN, 86400, AAAA(netip.MustParseAddr("2001:df0:8::a253"))},
 		})
 
-	mock := new(MockClient)
-	mock.data = data
 	return mock
 }
 
-func (c *MockClient) Do(network string, address string, question Question, rec bool, edns bool, dnssec bool) (*Response, error) {
-	key := MockClientDataKey{address, question}
+func (c *MockClient) Exchange(network string, address string, req *Msg) (*Msg, error) {
+	key := MockClientDataKey{address, req.Questions[0]}
 	val, ok := c.data.Load(key)
 	if ok {
-		return val.(*Response), nil
+		return val.(*Msg), nil
 	}
 	return nil, fmt.Errorf("not found: %v", key)
 }
+
+func TestResolveUnknownTypeFromCache(t *testing.T) {
+	resolverTestSetUp(t)
+
+	question := Question{Name("example.com."), 65534, ClassIN}
+	answers := []ResourceRecord{
+		{Name("example.com."), 65534, ClassIN, 3600, UnknownRData{10, 0, 0, 1}},
+	}
+	res, err := MakeResponse(0, QR, question, answers, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := res.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := new(Msg)
+	err = parsed.Unpack(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache()
+	storeRRSets(NewRRSets(parsed.AnswerResourceRecords), cache, time.Now().Unix())
+	rrs, _, err := Resolve(question, true, false, NewMockClient(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs[0].TTL = 3600
+	res, err = MakeResponse(0, QR, question, rrs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := res.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("%x", actual)
+	}
+}
+
+type funcClient func(network string, address string, req *Msg) (*Msg, error)
+
+func (f funcClient) Exchange(network string, address string, req *Msg) (*Msg, error) {
+	return f(network, address, req)
+}
+
+func TestResolveError(t *testing.T) {
+	resolverTestSetUp(t)
+
+	question := Question{Name("example.com."), TypeA, ClassIN}
+	reply := func(rcode uint16) funcClient {
+		return func(network string, address string, req *Msg) (*Msg, error) {
+			return new(Msg).SetRcode(req, rcode), nil
+		}
+	}
+	data := []struct {
+		client   Client
+		rcode    uint16
+		infoCode uint16
+	}{
+		{funcClient(func(network string, address string, req *Msg) (*Msg, error) {
+			return nil, &net.OpError{Op: "read", Net: network, Err: os.ErrDeadlineExceeded}
+		}), SERVFAIL, EDENoReachableAuthority},
+		{funcClient(func(network string, address string, req *Msg) (*Msg, error) {
+			return nil, fmt.Errorf("connection refused")
+		}), SERVFAIL, EDENetworkError},
+		{reply(NXDOMAIN), NXDOMAIN, EDEOtherError},
+		{reply(REFUSED), SERVFAIL, EDENoReachableAuthority},
+		{reply(NOERROR), SERVFAIL, EDENoReachableAuthority}, // lame
+	}
+	for _, v := range data {
+		_, _, err := Resolve(question, false, false, v.client, NewCache())
+		var resolveErr *ResolveError
+		if !errors.As(err, &resolveErr) {
+			t.Fatal(err)
+		}
+		if resolveErr.Rcode != v.rcode || resolveErr.InfoCode != v.infoCode {
+			t.Error(resolveErr.Rcode, resolveErr.InfoCode, err)
+		}
+		ede, ok := resolveErr.ExtendedError()
+		if ok != (v.rcode != NXDOMAIN) || (ok && ede.ExtraText == "") {
+			t.Error(ede, ok)
+		}
+	}
+}
+
+func TestResolveWithClientSubnet(t *testing.T) {
+	resolverTestSetUp(t)
+	defer func() { ECSServers = nil }()
+
+	question := Question{Name("example.com."), TypeA, ClassIN}
+	var sent []netip.Prefix
+	client := funcClient(func(network string, address string, req *Msg) (*Msg, error) {
+		res := new(Msg).SetReply(req)
+		res.AnswerResourceRecords = []ResourceRecord{
+			{question.Name, TypeA, ClassIN, 300, A(netip.MustParseAddr("192.0.2.1"))},
+		}
+		var prefix netip.Prefix
+		if opt := req.IsEDNS0(); opt != nil {
+			if ecs, ok := opt.Option(EDNS0CodeClientSubnet).(EDNS0ClientSubnet); ok {
+				prefix = ecs.Prefix()
+				ecs.ScopePrefix = 24
+				res.SetEDNS0(UDPSize, false)
+				res.IsEDNS0().SetOption(ecs)
+			}
+		}
+		sent = append(sent, prefix)
+		return res, nil
+	})
+
+	cache := NewCache()
+	data := []struct {
+		subnet   string
+		sent     string // empty if answered from the cache or without ECS
+		expected uint8
+	}{
+		{"198.51.100.1/32", "198.51.100.0/24", 24},
+		{"198.51.100.2/32", "", 24},
+		{"203.0.113.1/32", "203.0.113.0/24", 24},
+		{"203.0.113.0/16", "203.0.0.0/16", 16},
+	}
+	ECSServers = []netip.Prefix{netip.PrefixFrom(netip.MustParseAddr(rootServer), 32)}
+	for _, v := range data {
+		sent = nil
+		_, _, scope, err := ResolveWithClientSubnet(question, netip.MustParsePrefix(v.subnet), false, false, client, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scope != v.expected {
+			t.Error(v.subnet, scope)
+		}
+		if (v.sent == "" && len(sent) != 0) || (v.sent != "" && (len(sent) != 1 || sent[0] != netip.MustParsePrefix(v.sent))) {
+			t.Error(v.subnet, sent)
+		}
+	}
+
+	// not in the allowlist
+	ECSServers = nil
+	sent = nil
+	_, _, scope, err := ResolveWithClientSubnet(question, netip.MustParsePrefix("192.0.2.1/32"), false, false, client, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope != 0 || len(sent) != 1 || sent[0].IsValid() {
+		t.Error(scope, sent)
+	}
+}
+
+func TestResolveSVCBAlias(t *testing.T) {
+	resolverTestSetUp(t)
+
+	records := map[Name]RData{
+		"example.com.":     HTTPS{SVCB{0, "svc.example.net.", nil}},
+		"svc.example.net.": HTTPS{SVCB{1, ".", []SvcParam{SvcParamALPN{"h2"}}}},
+		"a.example.":       HTTPS{SVCB{0, "b.example.", nil}},
+		"b.example.":       HTTPS{SVCB{0, "a.example.", nil}},
+	}
+	client := funcClient(func(network string, address string, req *Msg) (*Msg, error) {
+		res := new(Msg).SetReply(req)
+		q := req.Questions[0]
+		res.AnswerResourceRecords = []ResourceRecord{{q.Name, q.Type, ClassIN, 300, records[q.Name]}}
+		return res, nil
+	})
+	data := []struct {
+		name     Name
+		expected []Name
+	}{
+		{"example.com.", []Name{"example.com.", "svc.example.net."}},
+		{"svc.example.net.", []Name{"svc.example.net."}},
+		{"a.example.", []Name{"a.example.", "b.example."}}, // loop
+	}
+	for _, v := range data {
+		rrs, _, err := Resolve(Question{v.name, TypeHTTPS, ClassIN}, false, false, client, NewCache())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []Name
+		for _, rr := range rrs {
+			names = append(names, rr.Name)
+		}
+		if !reflect.DeepEqual(names, v.expected) {
+			t.Error(v.name, names)
+		}
+	}
+}
@@ -28,12 +28,13 @@ func (rrSet *RRSet) String() string {
 	return strings.Join(result, "\n") + "\n"
 }
 
+// RRSets maps the questions with the canonical names to the RRsets.
 type RRSets map[Question]*RRSet
 
 func NewRRSets(rrs []ResourceRecord) RRSets {
 	rrSets := make(RRSets)
 	for _, v := range rrs {
-		key := Question{v.Name, v.Type, v.Class}
+		key := Question{v.Name.Canonical(), v.Type, v.Class}
 		rrSet, ok := rrSets[key]
 		if !ok {
 			rrSet = new(RRSet)
@@ -51,6 +52,12 @@ func NewRRSets(rrs []ResourceRecord) RRSets {
 	return rrSets
 }
 
+// Get returns the RRset for question, ignoring the case of the name.
+func (rrSets RRSets) Get(question Question) (*RRSet, bool) {
+	rrSet, ok := rrSets[question.canonical()]
+	return rrSet, ok
+}
+
 func (rrSets *RRSets) String() string {
 	var result []string
 	for _, rrSets := range *rrSets {
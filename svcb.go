@@ -0,0 +1,617 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SvcParamKey is the key of a service parameter (RFC 9460 Section 14.3).
+type SvcParamKey uint16
+
+const (
+	SvcParamKeyMandatory     SvcParamKey = 0
+	SvcParamKeyALPN          SvcParamKey = 1
+	SvcParamKeyNoDefaultALPN SvcParamKey = 2
+	SvcParamKeyPort          SvcParamKey = 3
+	SvcParamKeyIPv4Hint      SvcParamKey = 4
+	SvcParamKeyECH           SvcParamKey = 5
+	SvcParamKeyIPv6Hint      SvcParamKey = 6
+)
+
+var svcParamKeyTexts = []string{
+	"mandatory",
+	"alpn",
+	"no-default-alpn",
+	"port",
+	"ipv4hint",
+	"ech",
+	"ipv6hint",
+}
+
+func svcParamKeyFromString(s string) (SvcParamKey, error) {
+	for i, v := range svcParamKeyTexts {
+		if s == v {
+			return SvcParamKey(i), nil
+		}
+	}
+	if strings.HasPrefix(s, "key") {
+		v, err := strconv.ParseUint(s[3:], 10, 16)
+		if err == nil && v != 65535 {
+			return SvcParamKey(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid SvcParamKey: %v", s)
+}
+
+func (key SvcParamKey) String() string {
+	if int(key) < len(svcParamKeyTexts) {
+		return svcParamKeyTexts[key]
+	}
+	return fmt.Sprintf("key%v", uint16(key))
+}
+
+// SvcParam is a service parameter of SVCB and HTTPS records. String returns
+// the presentation format, key=value.
+type SvcParam interface {
+	Key() SvcParamKey
+	MarshalBinary() ([]byte, error)
+	String() string
+}
+
+func decodeSvcParam(key SvcParamKey, data []byte) (SvcParam, error) {
+	data = append([]byte{}, data...)
+	switch key {
+	case SvcParamKeyMandatory:
+		if len(data) == 0 || len(data)%2 != 0 {
+			return nil, ErrBadRData
+		}
+		var keys SvcParamMandatory
+		for i := 0; i < len(data); i += 2 {
+			keys = append(keys, SvcParamKey(binary.BigEndian.Uint16(data[i:])))
+		}
+		return keys, nil
+	case SvcParamKeyALPN:
+		ids, err := decodeTexts(data, 0, len(data))
+		if err != nil || len(ids) == 0 {
+			return nil, ErrBadRData
+		}
+		for _, v := range ids {
+			if v == "" {
+				return nil, ErrBadRData
+			}
+		}
+		return SvcParamALPN(ids), nil
+	case SvcParamKeyNoDefaultALPN:
+		if len(data) != 0 {
+			return nil, ErrBadRData
+		}
+		return SvcParamNoDefaultALPN{}, nil
+	case SvcParamKeyPort:
+		if len(data) != 2 {
+			return nil, ErrBadRData
+		}
+		return SvcParamPort(binary.BigEndian.Uint16(data)), nil
+	case SvcParamKeyIPv4Hint:
+		addrs, err := decodeAddrs(data, 4)
+		if err != nil {
+			return nil, err
+		}
+		return SvcParamIPv4Hint(addrs), nil
+	case SvcParamKeyECH:
+		return SvcParamECH(data), nil
+	case SvcParamKeyIPv6Hint:
+		addrs, err := decodeAddrs(data, 16)
+		if err != nil {
+			return nil, err
+		}
+		return SvcParamIPv6Hint(addrs), nil
+	}
+	return SvcParamUnknown{key, data}, nil
+}
+
+// decodeAddrs decodes a non-empty list of addresses of size bytes each.
+func decodeAddrs(data []byte, size int) ([]netip.Addr, error) {
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, ErrBadRData
+	}
+	var addrs []netip.Addr
+	for i := 0; i < len(data); i += size {
+		addr, _ := netip.AddrFromSlice(data[i : i+size])
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func parseSvcParam(field string) (SvcParam, error) {
+	k, value, hasValue := strings.Cut(field, "=")
+	key, err := svcParamKeyFromString(k)
+	if err != nil {
+		return nil, err
+	}
+	value = unquote(value)
+	if key == SvcParamKeyNoDefaultALPN {
+		if hasValue {
+			return nil, fmt.Errorf("value for %v: %v", key, field)
+		}
+		return SvcParamNoDefaultALPN{}, nil
+	}
+	if key <= SvcParamKeyIPv6Hint && value == "" {
+		return nil, fmt.Errorf("no value for %v: %v", key, field)
+	}
+	switch key {
+	case SvcParamKeyMandatory:
+		var keys SvcParamMandatory
+		for _, v := range strings.Split(value, ",") {
+			key, err := svcParamKeyFromString(v)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		return keys, nil
+	case SvcParamKeyALPN:
+		list, err := unescapeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := splitValueList(list)
+		if err != nil {
+			return nil, err
+		}
+		return SvcParamALPN(ids), nil
+	case SvcParamKeyPort:
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		return SvcParamPort(port), nil
+	case SvcParamKeyIPv4Hint, SvcParamKeyIPv6Hint:
+		var addrs []netip.Addr
+		for _, v := range strings.Split(value, ",") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			if addr.Is4() != (key == SvcParamKeyIPv4Hint) {
+				return nil, fmt.Errorf("invalid address for %v: %v", key, v)
+			}
+			addrs = append(addrs, addr)
+		}
+		if key == SvcParamKeyIPv4Hint {
+			return SvcParamIPv4Hint(addrs), nil
+		}
+		return SvcParamIPv6Hint(addrs), nil
+	case SvcParamKeyECH:
+		ech, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return SvcParamECH(ech), nil
+	}
+	data, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return SvcParamUnknown{key, []byte(data)}, nil
+}
+
+// unescapeValue resolves the \X and \DDD escapes of a character string.
+func unescapeValue(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if len(s) <= i+1 {
+			return "", fmt.Errorf("invalid escape: %v", s)
+		}
+		if '0' <= s[i+1] && s[i+1] <= '9' {
+			if len(s) < i+4 {
+				return "", fmt.Errorf("invalid escape: %v", s)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+4], 10, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape: %v", s)
+			}
+			b.WriteByte(byte(v))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i+1])
+		i++
+	}
+	return b.String(), nil
+}
+
+// escapeValue escapes the bytes of a character string that are not
+// printable, and the bytes special in master files.
+func escapeValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 0x21 || 0x7E < c:
+			fmt.Fprintf(&b, "\\%03d", c)
+		case strings.IndexByte(`\";()`, c) != -1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitValueList splits a comma-separated list, the character string
+// unescaped already, whose items may have commas and backslashes escaped
+// with backslashes again (RFC 9460 Appendix A.1).
+func splitValueList(s string) ([]string, error) {
+	var items []string
+	var item strings.Builder
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == ',' {
+			if item.Len() == 0 {
+				return nil, fmt.Errorf("empty item: %v", s)
+			}
+			items = append(items, item.String())
+			item.Reset()
+			continue
+		}
+		if s[i] == '\\' {
+			i++
+			if i == len(s) {
+				return nil, fmt.Errorf("invalid escape: %v", s)
+			}
+		}
+		item.WriteByte(s[i])
+	}
+	return items, nil
+}
+
+// joinValueList escapes the commas and backslashes of items and joins them
+// with commas, which is escaped as a character string again (RFC 9460
+// Appendix A.1).
+func joinValueList(items []string) string {
+	escaped := make([]string, len(items))
+	for i, v := range items {
+		escaped[i] = strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(v)
+	}
+	return escapeValue(strings.Join(escaped, ","))
+}
+
+// SvcParamMandatory lists the keys that clients must understand.
+type SvcParamMandatory []SvcParamKey
+
+func (keys SvcParamMandatory) Key() SvcParamKey {
+	return SvcParamKeyMandatory
+}
+
+func (keys SvcParamMandatory) MarshalBinary() ([]byte, error) {
+	sorted := append(SvcParamMandatory{}, keys...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var data []byte
+	for _, v := range sorted {
+		data = binary.BigEndian.AppendUint16(data, uint16(v))
+	}
+	return data, nil
+}
+
+func (keys SvcParamMandatory) String() string {
+	texts := make([]string, len(keys))
+	for i, v := range keys {
+		texts[i] = v.String()
+	}
+	return fmt.Sprintf("%v=%v", SvcParamKeyMandatory, strings.Join(texts, ","))
+}
+
+// SvcParamALPN lists the ALPN protocol IDs (RFC 7301) of the service.
+type SvcParamALPN []string
+
+func (alpn SvcParamALPN) Key() SvcParamKey {
+	return SvcParamKeyALPN
+}
+
+func (alpn SvcParamALPN) MarshalBinary() ([]byte, error) {
+	return encodeTexts(alpn)
+}
+
+func (alpn SvcParamALPN) String() string {
+	return fmt.Sprintf("%v=%v", SvcParamKeyALPN, joinValueList(alpn))
+}
+
+// SvcParamNoDefaultALPN tells that the default ALPN of the scheme is not
+// supported.
+type SvcParamNoDefaultALPN struct{}
+
+func (SvcParamNoDefaultALPN) Key() SvcParamKey {
+	return SvcParamKeyNoDefaultALPN
+}
+
+func (SvcParamNoDefaultALPN) MarshalBinary() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (SvcParamNoDefaultALPN) String() string {
+	return SvcParamKeyNoDefaultALPN.String()
+}
+
+// SvcParamPort is the alternative port of the service.
+type SvcParamPort uint16
+
+func (port SvcParamPort) Key() SvcParamKey {
+	return SvcParamKeyPort
+}
+
+func (port SvcParamPort) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint16(nil, uint16(port)), nil
+}
+
+func (port SvcParamPort) String() string {
+	return fmt.Sprintf("%v=%v", SvcParamKeyPort, uint16(port))
+}
+
+// SvcParamIPv4Hint lists IPv4 addresses that clients may use before the
+// target name is resolved.
+type SvcParamIPv4Hint []netip.Addr
+
+func (hint SvcParamIPv4Hint) Key() SvcParamKey {
+	return SvcParamKeyIPv4Hint
+}
+
+func (hint SvcParamIPv4Hint) MarshalBinary() ([]byte, error) {
+	return marshalAddrs(hint, true)
+}
+
+func (hint SvcParamIPv4Hint) String() string {
+	return fmt.Sprintf("%v=%v", SvcParamKeyIPv4Hint, joinAddrs(hint))
+}
+
+// SvcParamECH is an ECHConfigList for Encrypted Client Hello.
+type SvcParamECH []byte
+
+func (ech SvcParamECH) Key() SvcParamKey {
+	return SvcParamKeyECH
+}
+
+func (ech SvcParamECH) MarshalBinary() ([]byte, error) {
+	return []byte(ech), nil
+}
+
+func (ech SvcParamECH) String() string {
+	return fmt.Sprintf("%v=%v", SvcParamKeyECH, base64.StdEncoding.EncodeToString(ech))
+}
+
+// SvcParamIPv6Hint lists IPv6 addresses that clients may use before the
+// target name is resolved.
+type SvcParamIPv6Hint []netip.Addr
+
+func (hint SvcParamIPv6Hint) Key() SvcParamKey {
+	return SvcParamKeyIPv6Hint
+}
+
+func (hint SvcParamIPv6Hint) MarshalBinary() ([]byte, error) {
+	return marshalAddrs(hint, false)
+}
+
+func (hint SvcParamIPv6Hint) String() string {
+	return fmt.Sprintf("%v=%v", SvcParamKeyIPv6Hint, joinAddrs(hint))
+}
+
+func marshalAddrs(addrs []netip.Addr, is4 bool) ([]byte, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address")
+	}
+	var data []byte
+	for _, v := range addrs {
+		if !v.IsValid() || v.Is4() != is4 {
+			return nil, fmt.Errorf("invalid address: %v", v)
+		}
+		data = append(data, v.AsSlice()...)
+	}
+	return data, nil
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	texts := make([]string, len(addrs))
+	for i, v := range addrs {
+		texts[i] = v.String()
+	}
+	return strings.Join(texts, ",")
+}
+
+// SvcParamUnknown is a parameter without a specific type, presented as
+// keyNNNNN=value.
+type SvcParamUnknown struct {
+	ParamKey SvcParamKey
+	Value    []byte
+}
+
+func (u SvcParamUnknown) Key() SvcParamKey {
+	return u.ParamKey
+}
+
+func (u SvcParamUnknown) MarshalBinary() ([]byte, error) {
+	return u.Value, nil
+}
+
+func (u SvcParamUnknown) String() string {
+	if len(u.Value) == 0 {
+		return u.ParamKey.String()
+	}
+	return fmt.Sprintf("%v=%v", u.ParamKey, escapeValue(string(u.Value)))
+}
+
+// SVCB is the service binding record (RFC 9460). Priority 0 is AliasMode,
+// and the others are ServiceMode. Target "." is the owner name in
+// ServiceMode, and means that the service is unavailable in AliasMode.
+type SVCB struct {
+	Priority uint16
+	Target   string
+	Params   []SvcParam
+}
+
+// HTTPS is the SVCB record for the https and http schemes.
+type HTTPS struct {
+	SVCB
+}
+
+func decodeSVCB(msg []byte, current int, end int) (RData, error) {
+	if end < current+2 {
+		return nil, ErrBadRData
+	}
+	priority := binary.BigEndian.Uint16(msg[current:])
+	target, next, err := decodeName(msg, current+2)
+	if err != nil {
+		return nil, err
+	}
+	svcb := SVCB{Priority: priority, Target: target.String()}
+	for next < end {
+		if end < next+4 {
+			return nil, ErrBadRData
+		}
+		key := SvcParamKey(binary.BigEndian.Uint16(msg[next:]))
+		length := int(binary.BigEndian.Uint16(msg[next+2:]))
+		next += 4
+		if end < next+length {
+			return nil, ErrBadRData
+		}
+		if 0 < len(svcb.Params) && key <= svcb.Params[len(svcb.Params)-1].Key() {
+			// keys must be in strictly increasing order
+			return nil, ErrBadRData
+		}
+		param, err := decodeSvcParam(key, msg[next:next+length])
+		if err != nil {
+			return nil, err
+		}
+		svcb.Params = append(svcb.Params, param)
+		next += length
+	}
+	return svcb, nil
+}
+
+func decodeHTTPS(msg []byte, current int, end int) (RData, error) {
+	svcb, err := decodeSVCB(msg, current, end)
+	if err != nil {
+		return nil, err
+	}
+	return HTTPS{svcb.(SVCB)}, nil
+}
+
+func parseSVCB(fields []string, origin string) (RData, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid format: %v", fields)
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	svcb := SVCB{Priority: uint16(priority), Target: absName(fields[1], origin)}
+	for _, v := range fields[2:] {
+		param, err := parseSvcParam(v)
+		if err != nil {
+			return nil, err
+		}
+		svcb.Params = append(svcb.Params, param)
+	}
+	sort.SliceStable(svcb.Params, func(i, j int) bool { return svcb.Params[i].Key() < svcb.Params[j].Key() })
+	err = svcb.check()
+	if err != nil {
+		return nil, err
+	}
+	return svcb, nil
+}
+
+func parseHTTPS(fields []string, origin string) (RData, error) {
+	svcb, err := parseSVCB(fields, origin)
+	if err != nil {
+		return nil, err
+	}
+	return HTTPS{svcb.(SVCB)}, nil
+}
+
+// check checks that the sorted parameters are self-consistent (RFC 9460
+// Section 8).
+func (svcb SVCB) check() error {
+	for i := 1; i < len(svcb.Params); i++ {
+		if svcb.Params[i-1].Key() == svcb.Params[i].Key() {
+			return fmt.Errorf("duplicate SvcParamKey: %v", svcb.Params[i].Key())
+		}
+	}
+	if mandatory, ok := svcb.Param(SvcParamKeyMandatory).(SvcParamMandatory); ok {
+		for i, v := range mandatory {
+			if v == SvcParamKeyMandatory {
+				return fmt.Errorf("mandatory lists itself")
+			}
+			for _, w := range mandatory[:i] {
+				if v == w {
+					return fmt.Errorf("duplicate mandatory key: %v", v)
+				}
+			}
+			if svcb.Param(v) == nil {
+				return fmt.Errorf("missing mandatory key: %v", v)
+			}
+		}
+	}
+	if svcb.Param(SvcParamKeyNoDefaultALPN) != nil && svcb.Param(SvcParamKeyALPN) == nil {
+		return fmt.Errorf("no-default-alpn without alpn")
+	}
+	return nil
+}
+
+// Param returns the parameter with key, or nil.
+func (svcb SVCB) Param(key SvcParamKey) SvcParam {
+	for _, param := range svcb.Params {
+		if param.Key() == key {
+			return param
+		}
+	}
+	return nil
+}
+
+// IsAlias reports whether svcb is in AliasMode.
+func (svcb SVCB) IsAlias() bool {
+	return svcb.Priority == 0
+}
+
+// MarshalBinary does not compress the target (RFC 9460 Section 2.2).
+func (svcb SVCB) MarshalBinary(msg []byte) (data []byte, err error) {
+	var p packer
+	p.buf = binary.BigEndian.AppendUint16(p.buf, svcb.Priority)
+	err = p.appendName(svcb.Target, false)
+	if err != nil {
+		return nil, err
+	}
+	params := append([]SvcParam{}, svcb.Params...)
+	sort.SliceStable(params, func(i, j int) bool { return params[i].Key() < params[j].Key() })
+	for i, param := range params {
+		if 0 < i && param.Key() == params[i-1].Key() {
+			return nil, fmt.Errorf("duplicate SvcParamKey: %v", param.Key())
+		}
+		b, err := param.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if 0xFFFF < len(b) {
+			return nil, fmt.Errorf("SvcParam length")
+		}
+		p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(param.Key()))
+		p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(len(b)))
+		p.buf = append(p.buf, b...)
+	}
+	return p.buf, nil
+}
+
+func (svcb SVCB) String() string {
+	texts := []string{strconv.Itoa(int(svcb.Priority)), svcb.Target}
+	for _, param := range svcb.Params {
+		texts = append(texts, param.String())
+	}
+	return strings.Join(texts, " ")
+}
@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSVCB(t *testing.T) {
+	// RFC 9460 Appendix D
+	data := []struct {
+		text     string
+		expected string // if different from text
+		wire     string
+	}{
+		{"0 foo.example.com.", "", "000003666f6f076578616d706c6503636f6d00"},
+		{"1 .", "", "000100"},
+		{"16 foo.example.com. port=53", "", "001003666f6f076578616d706c6503636f6d00000300020035"},
+		{"1 foo.example.com. key667=hello", "", "000103666f6f076578616d706c6503636f6d00029b000568656c6c6f"},
+		{`1 foo.example.com. key667="hello\210qoo"`, `1 foo.example.com. key667=hello\210qoo`, "000103666f6f076578616d706c6503636f6d00029b000968656c6c6fd2716f6f"},
+		{"1 foo.example.com. ipv6hint=2001:db8::1,2001:db8::53:1", "",
+			"000103666f6f076578616d706c6503636f6d00000600202001" +
+				"0db8000000000000000000000001" + "20010db8000000000000000000530001"},
+		{"16 foo.example.org. alpn=h2,h3-19 mandatory=ipv4hint,alpn ipv4hint=192.0.2.1",
+			"16 foo.example.org. mandatory=alpn,ipv4hint alpn=h2,h3-19 ipv4hint=192.0.2.1",
+			"001003666f6f076578616d706c65036f726700" +
+				"0000000400010004" + "000100090268320568332d3139" + "00040004c0000201"},
+		{"1 . alpn=h2 no-default-alpn ech=AEX+DQA= key65000", "", ""},
+		{`1 . alpn="f\\\\oo\\,bar,h2"`, `1 . alpn=f\\\\oo\\,bar,h2`, "000100" + "0001000c" + "08665c6f6f2c626172" + "026832"},
+		{`1 . alpn=f\\\092oo\092,bar,h2`, `1 . alpn=f\\\\oo\\,bar,h2`, "000100" + "0001000c" + "08665c6f6f2c626172" + "026832"},
+	}
+	for _, v := range data {
+		rdata, err := parseSVCB(strings.Fields(v.text), "example.com.")
+		if err != nil {
+			t.Fatal(v.text, err)
+		}
+		expected := v.expected
+		if expected == "" {
+			expected = v.text
+		}
+		if s := rdata.String(); s != expected {
+			t.Error(s)
+		}
+		b, err := rdata.MarshalBinary(nil)
+		if err != nil {
+			t.Fatal(v.text, err)
+		}
+		if v.wire != "" && hex.EncodeToString(b) != v.wire {
+			t.Errorf("%v: %x", v.text, b)
+		}
+		decoded, err := decodeSVCB(b, 0, len(b))
+		if err != nil || !reflect.DeepEqual(decoded, rdata) {
+			t.Error(v.text, decoded, err)
+		}
+	}
+}
+
+func TestSVCBInvalid(t *testing.T) {
+	// RFC 9460 Appendix D.3
+	for _, v := range []string{
+		"1 foo.example.com. key123=abc key123=def",
+		"1 foo.example.com. mandatory",
+		"1 foo.example.com. alpn",
+		"1 foo.example.com. port",
+		"1 foo.example.com. ipv4hint",
+		"1 foo.example.com. ipv6hint",
+		"1 foo.example.com. no-default-alpn=abc",
+		"1 foo.example.com. mandatory=key123",
+		"1 foo.example.com. mandatory=mandatory",
+		"1 foo.example.com. no-default-alpn",
+		"1 foo.example.com. ipv4hint=2001:db8::1",
+		"1 foo.example.com. key65535=abc",
+		"1",
+	} {
+		if _, err := parseSVCB(strings.Fields(v), "example.com."); err == nil {
+			t.Error(v)
+		}
+	}
+	for _, v := range []string{
+		"000100" + "0003000200350001000100", // keys not in order
+		"000100" + "000300030035",           // port length
+		"000100" + "00040005c000020101",     // ipv4hint length
+		"000100" + "000200010000",           // no-default-alpn with a value
+		"000100" + "0001000100",             // empty alpn
+		"000100" + "000300",                 // truncated
+	} {
+		b, _ := hex.DecodeString(v)
+		if _, err := decodeSVCB(b, 0, len(b)); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	type_, rdata, err := parseRData("HTTPS", []string{"1", ".", "alpn=h3,h2"}, "example.com.")
+	if err != nil || type_ != TypeHTTPS {
+		t.Fatal(err)
+	}
+	if _, ok := rdata.(HTTPS); !ok {
+		t.Fatalf("%T", rdata)
+	}
+	b, err := rdata.MarshalBinary(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeRData(TypeHTTPS, b, 0, len(b))
+	if err != nil || !reflect.DeepEqual(decoded, rdata) {
+		t.Error(decoded, err)
+	}
+	if s := printRData(TypeHTTPS, decoded); s != "1 . alpn=h3,h2" {
+		t.Error(s)
+	}
+}
@@ -25,6 +25,10 @@ test_go() {
         status=1
         ((++fails))
     fi
+    if ! go test ./idna; then
+        status=1
+        ((++fails))
+    fi
     for each in `ls cmd` ; do
         if ! go test "./cmd/${each}"; then
             status=1
@@ -65,7 +69,7 @@ test_lookup() {
 test_authoritative_server() {
     local CMD="dig @127.0.0.1 -p ${DNS_PORT}"
 
-    bin/serv -address=0.0.0.0:${DNS_PORT} -mode=authoritative -zone=testdata/zones/example.com.zone 2> /dev/null &
+    bin/serv -address=0.0.0.0:${DNS_PORT} -config=testdata/serv.conf 2> /dev/null &
 
     # TODO: for each in test/*.sh ; do
     for each in test/*example.com*.sh ; do
@@ -0,0 +1,19 @@
+args='_sip._udp.example.com SRV'
+expected=$(cat << EOS
+;; QUESTION SECTION:
+;_sip._udp.example.com. IN SRV
+
+;; ANSWER SECTION:
+_sip._udp.example.com. 3600 IN SRV 10 60 5060 sip.example.com.
+
+;; AUTHORITY SECTION:
+example.com. 3600 IN NS ns1.example.com.
+example.com. 3600 IN NS ns2.example.com.
+
+;; ADDITIONAL SECTION:
+sip.example.com. 3600 IN A 192.0.2.5
+sip.example.com. 3600 IN AAAA 2001:db8::5
+EOS
+)
+actual=$(${CMD} ${args} | grep -Fx -A $(echo "$expected" | wc -l) ';; QUESTION SECTION:')
+assert_equals "${expected}" "${actual}"
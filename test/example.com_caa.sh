@@ -0,0 +1,11 @@
+args='example.com CAA'
+expected=$(cat << EOS
+;; QUESTION SECTION:
+;example.com. IN CAA
+
+;; ANSWER SECTION:
+example.com. 3600 IN CAA 0 issue "ca.example.net; account=230123"
+EOS
+)
+actual=$(${CMD} ${args} | grep -Fx -A $(echo "$expected" | wc -l) ';; QUESTION SECTION:')
+assert_equals "${expected}" "${actual}"
@@ -1,9 +1,10 @@
 package dns
 
 import (
-	"crypto/sha256"
+	"crypto"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"net/netip"
 	"strconv"
@@ -14,50 +15,104 @@ import (
 type Type uint16
 
 const (
-	TypeA      Type = 1
-	TypeNS     Type = 2
-	TypeCNAME  Type = 5
-	TypeSOA    Type = 6
-	TypePTR    Type = 12
-	TypeMX     Type = 15
-	TypeTXT    Type = 16
-	TypeAAAA   Type = 28
-	TypeOPT    Type = 41
-	TypeDS     Type = 43
-	TypeRRSIG  Type = 46
-	TypeNSEC   Type = 47
-	TypeDNSKEY Type = 48
-	TypeNSEC3  Type = 50
+	TypeA          Type = 1
+	TypeNS         Type = 2
+	TypeCNAME      Type = 5
+	TypeSOA        Type = 6
+	TypePTR        Type = 12
+	TypeMX         Type = 15
+	TypeTXT        Type = 16
+	TypeAAAA       Type = 28
+	TypeSRV        Type = 33
+	TypeNAPTR      Type = 35
+	TypeOPT        Type = 41
+	TypeDS         Type = 43
+	TypeSSHFP      Type = 44
+	TypeRRSIG      Type = 46
+	TypeNSEC       Type = 47
+	TypeDNSKEY     Type = 48
+	TypeNSEC3      Type = 50
+	TypeNSEC3PARAM Type = 51
+	TypeTLSA       Type = 52
+	TypeSVCB       Type = 64
+	TypeHTTPS      Type = 65
+	TypeCAA        Type = 257
 )
 
-var typeTexts = map[Type]string{
-	TypeA:      "A",
-	TypeNS:     "NS",
-	TypeCNAME:  "CNAME",
-	TypeSOA:    "SOA",
-	TypePTR:    "PTR",
-	TypeMX:     "MX",
-	TypeTXT:    "TXT",
-	TypeAAAA:   "AAAA",
-	TypeOPT:    "OPT",
-	TypeDS:     "DS",
-	TypeRRSIG:  "RRSIG",
-	TypeNSEC:   "NSEC",
-	TypeDNSKEY: "DNSKEY",
-	TypeNSEC3:  "NSEC3",
-}
-
 func typeFromString(s string) (Type, error) {
-	for type_, text := range typeTexts {
-		if s == text {
-			return type_, nil
+	if type_, ok := lookupTypeText(s); ok {
+		return type_, nil
+	}
+	if strings.HasPrefix(s, "TYPE") {
+		// RFC 3597
+		v, err := strconv.ParseUint(s[4:], 10, 16)
+		if err == nil {
+			return Type(v), nil
 		}
 	}
 	return 0, fmt.Errorf("invalid type text")
 }
 
 func (t Type) String() string {
-	return typeTexts[t]
+	if entry, ok := lookupType(t); ok {
+		return entry.text
+	}
+	return fmt.Sprintf("TYPE%v", uint16(t))
+}
+
+// checkFields checks that the zone file fields hold exactly n values.
+func checkFields(fields []string, n int) error {
+	if len(fields) != n {
+		return fmt.Errorf("invalid format: %v", fields)
+	}
+	return nil
+}
+
+// joinTail joins the fields from the n-th on, for RDATA whose last value may
+// be split with spaces such as base64 or hex strings.
+func joinTail(fields []string, n int) ([]string, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("invalid format: %v", fields)
+	}
+	joined := append([]string{}, fields[:n-1]...)
+	return append(joined, strings.Join(fields[n-1:], " ")), nil
+}
+
+// absName completes a relative name in a zone file with origin.
+func absName(name string, origin string) string {
+	if name == "@" {
+		return origin
+	} else if !isAbsolute(name) && origin == "." {
+		return name + "."
+	} else if !isAbsolute(name) {
+		return name + "." + origin
+	}
+	return name
+}
+
+func decodeNameRData(msg []byte, current int, end int) (RData, error) {
+	decoded, next, err := decodeName(msg, current)
+	if err != nil {
+		return nil, err
+	}
+	if next != end {
+		return nil, ErrBadRData
+	}
+	return decoded.(Name), nil
+}
+
+func parseNameRData(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 1); err != nil {
+		return nil, err
+	}
+	return Name(absName(fields[0], origin)), nil
+}
+
+func parseNS(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 1); err != nil {
+		return nil, err
+	}
+	return NS(strings.ToLower(absName(fields[0], origin))), nil
 }
 
 type A netip.Addr
@@ -66,6 +121,28 @@ func mustParseA(s string) A {
 	return A(netip.MustParseAddr(s))
 }
 
+func decodeA(msg []byte, current int, end int) (RData, error) {
+	ip, ok := netip.AddrFromSlice(msg[current:end])
+	if !ok || !ip.Is4() {
+		return nil, ErrBadRData
+	}
+	return A(ip), nil
+}
+
+func parseA(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 1); err != nil {
+		return nil, err
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if !addr.Is4() {
+		return nil, fmt.Errorf("invalid IPv4 addr")
+	}
+	return A(addr), nil
+}
+
 func (a A) MarshalBinary(msg []byte) (data []byte, err error) {
 	return netip.Addr(a).AsSlice(), nil
 }
@@ -120,24 +197,68 @@ func newSOA(fields []string) (*SOA, error) {
 	}, nil
 }
 
-func (soa SOA) MarshalBinary(msg []byte) (data []byte, err error) {
-	mname, err := encodeName(soa.mname.String(), msg)
+func decodeSOA(msg []byte, current int, end int) (RData, error) {
+	mname, next, err := decodeName(msg, current)
 	if err != nil {
 		return nil, err
 	}
-	rname, err := encodeName(soa.rname.String(), msg)
+	rname, next, err := decodeName(msg, next)
 	if err != nil {
 		return nil, err
 	}
-	data = make([]byte, 20+len(mname)+len(rname))
-	copy(data, mname)
-	copy(data[len(mname):], rname)
-	binary.BigEndian.PutUint32(data[len(mname)+len(rname):], soa.serial)
-	binary.BigEndian.PutUint32(data[len(mname)+len(rname)+4:], soa.refresh)
-	binary.BigEndian.PutUint32(data[len(mname)+len(rname)+8:], soa.retry)
-	binary.BigEndian.PutUint32(data[len(mname)+len(rname)+12:], soa.expire)
-	binary.BigEndian.PutUint32(data[len(mname)+len(rname)+16:], soa.minimum)
-	return
+	if next+20 != end {
+		return nil, ErrBadRData
+	}
+	serial := binary.BigEndian.Uint32(msg[next:])
+	refresh := binary.BigEndian.Uint32(msg[next+4:])
+	retry := binary.BigEndian.Uint32(msg[next+8:])
+	expire := binary.BigEndian.Uint32(msg[next+12:])
+	minimum := binary.BigEndian.Uint32(msg[next+16:])
+	return SOA{mname.(Name), rname.(Name), serial, refresh, retry, expire, minimum}, nil
+}
+
+func parseSOA(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 7); err != nil {
+		return nil, err
+	}
+	fields = append([]string{absName(fields[0], origin), absName(fields[1], origin)}, fields[2:]...)
+	// the timers may have units as TTLs
+	for i := 3; i < len(fields); i++ {
+		v, err := parseTTL(fields[i])
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = strconv.Itoa(v)
+	}
+	soa, err := newSOA(fields)
+	if err != nil {
+		return nil, err
+	}
+	return *soa, nil
+}
+
+func (soa SOA) appendTo(p *packer, compress bool) error {
+	err := p.appendName(soa.mname.String(), compress)
+	if err != nil {
+		return err
+	}
+	err = p.appendName(soa.rname.String(), compress)
+	if err != nil {
+		return err
+	}
+	for _, v := range []uint32{soa.serial, soa.refresh, soa.retry, soa.expire, soa.minimum} {
+		p.buf = binary.BigEndian.AppendUint32(p.buf, v)
+	}
+	return nil
+}
+
+func (soa SOA) MarshalBinary(msg []byte) (data []byte, err error) {
+	var p packer
+	err = soa.appendTo(&p, false)
+	if err != nil {
+		return nil, err
+	}
+	return p.buf, nil
 }
 
 func (soa SOA) String() string {
@@ -149,15 +270,44 @@ type MX struct {
 	Exchange   string
 }
 
+func decodeMX(msg []byte, current int, end int) (RData, error) {
+	if end < current+2 {
+		return nil, ErrBadRData
+	}
+	preference := binary.BigEndian.Uint16(msg[current:])
+	exchange, next, err := decodeName(msg, current+2)
+	if err != nil {
+		return nil, err
+	}
+	if next != end {
+		return nil, ErrBadRData
+	}
+	return MX{preference, exchange.String()}, nil
+}
+
+func parseMX(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 2); err != nil {
+		return nil, err
+	}
+	preference, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	return MX{uint16(preference), absName(fields[1], origin)}, nil
+}
+
+func (mx MX) appendTo(p *packer, compress bool) error {
+	p.buf = binary.BigEndian.AppendUint16(p.buf, mx.Preference)
+	return p.appendName(mx.Exchange, compress)
+}
+
 func (mx MX) MarshalBinary(msg []byte) (data []byte, err error) {
-	name, err := encodeName(mx.Exchange, msg)
+	var p packer
+	err = mx.appendTo(&p, false)
 	if err != nil {
 		return nil, err
 	}
-	data = make([]byte, 2+len(name))
-	binary.BigEndian.PutUint16(data, mx.Preference)
-	copy(data[2:], name)
-	return
+	return p.buf, nil
 }
 
 func (mx MX) String() string {
@@ -170,12 +320,36 @@ func newTxt(fields []string) TXT {
 	return TXT(strings.Join(fields, "\x00"))
 }
 
+func decodeTXT(msg []byte, current int, end int) (RData, error) {
+	texts, err := decodeTexts(msg, current, end)
+	if err != nil {
+		return nil, err
+	}
+	return newTxt(texts), nil
+}
+
+func parseTXT(fields []string, origin string) (RData, error) {
+	texts := make([]string, len(fields))
+	for i, v := range fields {
+		text, err := unescapeValue(unquote(v))
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = text
+	}
+	return newTxt(texts), nil
+}
+
 func (txt TXT) MarshalBinary(msg []byte) (data []byte, err error) {
 	return encodeTexts(strings.Split(string(txt), "\x00"))
 }
 
 func (txt TXT) String() string {
-	return strings.ReplaceAll(string(txt), "\x00", " ")
+	texts := strings.Split(string(txt), "\x00")
+	for i, v := range texts {
+		texts[i] = quoteText(v)
+	}
+	return strings.Join(texts, " ")
 }
 
 type AAAA netip.Addr
@@ -192,6 +366,25 @@ func newAAAA(fields []string) (*AAAA, error) {
 	return &aaaa, nil
 }
 
+func decodeAAAA(msg []byte, current int, end int) (RData, error) {
+	ip, ok := netip.AddrFromSlice(msg[current:end])
+	if !ok || !ip.Is6() {
+		return nil, ErrBadRData
+	}
+	return AAAA(ip), nil
+}
+
+func parseAAAA(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 1); err != nil {
+		return nil, err
+	}
+	aaaa, err := newAAAA(fields)
+	if err != nil {
+		return nil, err
+	}
+	return *aaaa, nil
+}
+
 func (aaaa AAAA) MarshalBinary(msg []byte) (data []byte, err error) {
 	return netip.Addr(aaaa).AsSlice(), nil
 }
@@ -200,6 +393,188 @@ func (aaaa AAAA) String() string {
 	return netip.Addr(aaaa).String()
 }
 
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+func decodeSRV(msg []byte, current int, end int) (RData, error) {
+	if end < current+6 {
+		return nil, ErrBadRData
+	}
+	priority := binary.BigEndian.Uint16(msg[current:])
+	weight := binary.BigEndian.Uint16(msg[current+2:])
+	port := binary.BigEndian.Uint16(msg[current+4:])
+	target, next, err := decodeName(msg, current+6)
+	if err != nil {
+		return nil, err
+	}
+	if next != end {
+		return nil, ErrBadRData
+	}
+	return SRV{priority, weight, port, target.String()}, nil
+}
+
+func parseSRV(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 4); err != nil {
+		return nil, err
+	}
+	var values [3]uint16
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i], 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = uint16(v)
+	}
+	return SRV{values[0], values[1], values[2], absName(fields[3], origin)}, nil
+}
+
+// MarshalBinary does not compress the target (RFC 2782).
+func (srv SRV) MarshalBinary(msg []byte) (data []byte, err error) {
+	var p packer
+	p.buf = binary.BigEndian.AppendUint16(p.buf, srv.Priority)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, srv.Weight)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, srv.Port)
+	err = p.appendName(srv.Target, false)
+	if err != nil {
+		return nil, err
+	}
+	return p.buf, nil
+}
+
+func (srv SRV) String() string {
+	return fmt.Sprintf("%v %v %v %v", srv.Priority, srv.Weight, srv.Port, srv.Target)
+}
+
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Services    string
+	Regexp      string
+	Replacement string
+}
+
+func decodeNAPTR(msg []byte, current int, end int) (RData, error) {
+	if end < current+4 {
+		return nil, ErrBadRData
+	}
+	order := binary.BigEndian.Uint16(msg[current:])
+	preference := binary.BigEndian.Uint16(msg[current+2:])
+	next := current + 4
+	var texts [3]string
+	for i := range texts {
+		if end <= next || end < next+1+int(msg[next]) {
+			return nil, ErrBadRData
+		}
+		texts[i] = string(msg[next+1 : next+1+int(msg[next])])
+		next += 1 + int(msg[next])
+	}
+	replacement, next, err := decodeName(msg, next)
+	if err != nil {
+		return nil, err
+	}
+	if next != end {
+		return nil, ErrBadRData
+	}
+	return NAPTR{order, preference, texts[0], texts[1], texts[2], replacement.String()}, nil
+}
+
+// unquote removes the double quotes around a character string in a zone
+// file.
+func unquote(field string) string {
+	if 2 <= len(field) && strings.HasPrefix(field, `"`) && strings.HasSuffix(field, `"`) {
+		return field[1 : len(field)-1]
+	}
+	return field
+}
+
+// quoteText returns s as a quoted character string, escaping the bytes that
+// are not printable.
+func quoteText(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 0x20 || 0x7E < c:
+			fmt.Fprintf(&b, "\\%03d", c)
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// parseHex decodes a hex string, which may be split with spaces.
+func parseHex(s string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty hex string")
+	}
+	return data, nil
+}
+
+func parseNAPTR(fields []string, origin string) (RData, error) {
+	if err := checkFields(fields, 6); err != nil {
+		return nil, err
+	}
+	order, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	preference, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	var texts [3]string
+	for i := range texts {
+		texts[i], err = unescapeValue(unquote(fields[2+i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NAPTR{
+		uint16(order),
+		uint16(preference),
+		texts[0],
+		texts[1],
+		texts[2],
+		absName(fields[5], origin),
+	}, nil
+}
+
+// MarshalBinary does not compress the replacement (RFC 3403).
+func (naptr NAPTR) MarshalBinary(msg []byte) (data []byte, err error) {
+	var p packer
+	p.buf = binary.BigEndian.AppendUint16(p.buf, naptr.Order)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, naptr.Preference)
+	texts, err := encodeTexts([]string{naptr.Flags, naptr.Services, naptr.Regexp})
+	if err != nil {
+		return nil, err
+	}
+	p.buf = append(p.buf, texts...)
+	err = p.appendName(naptr.Replacement, false)
+	if err != nil {
+		return nil, err
+	}
+	return p.buf, nil
+}
+
+func (naptr NAPTR) String() string {
+	return fmt.Sprintf("%v %v %v %v %v %v", naptr.Order, naptr.Preference, quoteText(naptr.Flags), quoteText(naptr.Services), quoteText(naptr.Regexp), naptr.Replacement)
+}
+
 type DS struct {
 	keyTag     uint16
 	algo       byte
@@ -234,6 +609,29 @@ func newDS(fields []string) (*DS, error) {
 	}, nil
 }
 
+func decodeDS(msg []byte, current int, end int) (RData, error) {
+	if end < current+4 {
+		return nil, ErrBadRData
+	}
+	keyTag := binary.BigEndian.Uint16(msg[current:])
+	algo := msg[current+2]
+	digestType := msg[current+3]
+	digest := msg[current+4 : end]
+	return DS{keyTag, algo, digestType, digest}, nil
+}
+
+func parseDS(fields []string, origin string) (RData, error) {
+	fields, err := joinTail(fields, 4)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := newDS(fields)
+	if err != nil {
+		return nil, err
+	}
+	return *ds, nil
+}
+
 func mustParseDS(s string) DS {
 	ds, _ := newDS(strings.SplitN(s, " ", 4))
 	return *ds
@@ -252,6 +650,47 @@ func (ds DS) String() string {
 	return fmt.Sprintf("%v %v %v %X", ds.keyTag, ds.algo, ds.digestType, ds.digest)
 }
 
+type SSHFP struct {
+	Algorithm   byte
+	Type        byte
+	Fingerprint []byte
+}
+
+func decodeSSHFP(msg []byte, current int, end int) (RData, error) {
+	if end < current+3 {
+		return nil, ErrBadRData
+	}
+	return SSHFP{msg[current], msg[current+1], msg[current+2 : end]}, nil
+}
+
+func parseSSHFP(fields []string, origin string) (RData, error) {
+	fields, err := joinTail(fields, 3)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	type_, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := parseHex(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	return SSHFP{byte(algo), byte(type_), fingerprint}, nil
+}
+
+func (sshfp SSHFP) MarshalBinary(msg []byte) (data []byte, err error) {
+	return append([]byte{sshfp.Algorithm, sshfp.Type}, sshfp.Fingerprint...), nil
+}
+
+func (sshfp SSHFP) String() string {
+	return fmt.Sprintf("%v %v %X", sshfp.Algorithm, sshfp.Type, sshfp.Fingerprint)
+}
+
 type RRSIG struct {
 	TypeCovered         Type
 	Algo                byte
@@ -312,6 +751,43 @@ func newRRSIG(fields []string) (*RRSIG, error) {
 	}, nil
 }
 
+func decodeRRSIG(msg []byte, current int, end int) (RData, error) {
+	if end < current+18 {
+		return nil, ErrBadRData
+	}
+	typeCovered, _, err := readType(msg, current)
+	if err != nil {
+		return nil, err
+	}
+	algo := msg[current+2]
+	labels := msg[current+3]
+	originalTtl := binary.BigEndian.Uint32(msg[current+4:])
+	signatureExpiration := binary.BigEndian.Uint32(msg[current+8:])
+	signatureInception := binary.BigEndian.Uint32(msg[current+12:])
+	keyTag := binary.BigEndian.Uint16(msg[current+16:])
+	signerName, next, err := decodeName(msg, current+18)
+	if err != nil {
+		return nil, err
+	}
+	signature := msg[next:end]
+	return RRSIG{typeCovered.(Type), algo, labels, originalTtl,
+		signatureExpiration, signatureInception,
+		keyTag, signerName.(Name), signature}, nil
+}
+
+func parseRRSIG(fields []string, origin string) (RData, error) {
+	fields, err := joinTail(fields, 9)
+	if err != nil {
+		return nil, err
+	}
+	fields[7] = absName(fields[7], origin)
+	rrsig, err := newRRSIG(fields)
+	if err != nil {
+		return nil, err
+	}
+	return *rrsig, nil
+}
+
 func mustParseRRSIG(s string) RRSIG {
 	rrsig, _ := newRRSIG(strings.SplitN(s, " ", 9))
 	return *rrsig
@@ -327,7 +803,7 @@ func (rrsig RRSIG) MarshalBinary(msg []byte) (data []byte, err error) {
 }
 
 func (rrsig RRSIG) MarshalBinaryWithoutSig() (data []byte, err error) {
-	signerName, err := encodeName(rrsig.SignerName.String(), nil)
+	signerName, err := encodeName(rrsig.SignerName.String())
 	if err != nil {
 		return nil, err
 	}
@@ -352,26 +828,11 @@ func (rrsig RRSIG) String() string {
 		base64.StdEncoding.EncodeToString(rrsig.Signature))
 }
 
-type NSEC struct {
-	nextDomainName string
-	typeTexts      string
-}
-
-func newNSEC(fields []string) (*NSEC, error) {
-	return &NSEC{
-		fields[0],
-		fields[1],
-	}, nil
-}
-
-func (nsec NSEC) MarshalBinary(msg []byte) (data []byte, err error) {
-	// TODO
-	return
-}
-
-func (nsec NSEC) String() string {
-	return fmt.Sprintf("%v %v", nsec.nextDomainName, nsec.typeTexts)
-}
+// DNSKEY flags (RFC 4034 Section 2.1.1).
+const (
+	DNSKEYFlagZone uint16 = 0x0100
+	DNSKEYFlagSEP  uint16 = 0x0001
+)
 
 type DNSKEY struct {
 	Flags uint16
@@ -405,6 +866,32 @@ func newDNSKEY(fields []string) (*DNSKEY, error) {
 	}, nil
 }
 
+func decodeDNSKEY(msg []byte, current int, end int) (RData, error) {
+	if end < current+4 {
+		return nil, ErrBadRData
+	}
+	flags := binary.BigEndian.Uint16(msg[current:])
+	proto := msg[current+2]
+	if proto != 3 {
+		return nil, fmt.Errorf("DNSKEY proto: %v", proto)
+	}
+	algo := msg[current+3]
+	key := msg[current+4 : end]
+	return DNSKEY{flags, proto, algo, key}, nil
+}
+
+func parseDNSKEY(fields []string, origin string) (RData, error) {
+	fields, err := joinTail(fields, 4)
+	if err != nil {
+		return nil, err
+	}
+	dnskey, err := newDNSKEY(fields)
+	if err != nil {
+		return nil, err
+	}
+	return *dnskey, nil
+}
+
 func mustParseDNSKEY(s string) DNSKEY {
 	dnskey, _ := newDNSKEY(strings.SplitN(s, " ", 4))
 	return *dnskey
@@ -423,8 +910,34 @@ func (dnskey DNSKEY) String() string {
 	return fmt.Sprintf("%v %v %v %v", dnskey.Flags, dnskey.Proto, dnskey.Algo, base64.StdEncoding.EncodeToString(dnskey.Key))
 }
 
-func (dnskey DNSKEY) Digest(name string) ([]byte, error) {
-	namebytes, err := encodeName(name, nil)
+// DS digest types (RFC 4509, RFC 6605).
+const (
+	DigestSHA1   uint8 = 1
+	DigestSHA256 uint8 = 2
+	DigestSHA384 uint8 = 4
+)
+
+// digestHash returns the hash function of digestType.
+func digestHash(digestType uint8) (crypto.Hash, bool) {
+	switch digestType {
+	case DigestSHA1:
+		return crypto.SHA1, true
+	case DigestSHA256:
+		return crypto.SHA256, true
+	case DigestSHA384:
+		return crypto.SHA384, true
+	}
+	return 0, false
+}
+
+// Digest returns the digest of dnskey owned by name for its DS record (RFC
+// 4034 Section 5.1.4).
+func (dnskey DNSKEY) Digest(name string, digestType uint8) ([]byte, error) {
+	hash, ok := digestHash(digestType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest type: %v", digestType)
+	}
+	namebytes, err := encodeName(Name(name).Canonical().String())
 	if err != nil {
 		return nil, err
 	}
@@ -432,6 +945,147 @@ func (dnskey DNSKEY) Digest(name string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	sum := sha256.Sum256(append(namebytes, dnskeybytes...))
-	return sum[:], nil
+	return hashMessage(hash, append(namebytes, dnskeybytes...)), nil
+}
+
+// KeyTag returns the key tag of dnskey (RFC 4034 Appendix B).
+func (dnskey DNSKEY) KeyTag() uint16 {
+	if dnskey.Algo == 1 {
+		// RSA/MD5, the most significant 16 of the least significant 24 bits
+		// of the modulus
+		if len(dnskey.Key) < 3 {
+			return 0
+		}
+		return binary.BigEndian.Uint16(dnskey.Key[len(dnskey.Key)-3:])
+	}
+	rdata, _ := dnskey.MarshalBinary(nil)
+	var ac uint32
+	for i, v := range rdata {
+		if i&1 == 1 {
+			ac += uint32(v)
+		} else {
+			ac += uint32(v) << 8
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac)
+}
+
+// IsZoneKey reports whether dnskey has the Zone Key flag, which the keys
+// signing the zone data must have.
+func (dnskey DNSKEY) IsZoneKey() bool {
+	return dnskey.Flags&DNSKEYFlagZone != 0
+}
+
+// ToDS returns the DS record of dnskey owned by name.
+func (dnskey DNSKEY) ToDS(name string, digestType uint8) (DS, error) {
+	digest, err := dnskey.Digest(name, digestType)
+	if err != nil {
+		return DS{}, err
+	}
+	return DS{dnskey.KeyTag(), dnskey.Algo, digestType, digest}, nil
+}
+
+type TLSA struct {
+	Usage        byte
+	Selector     byte
+	MatchingType byte
+	Certificate  []byte
+}
+
+func decodeTLSA(msg []byte, current int, end int) (RData, error) {
+	if end < current+4 {
+		return nil, ErrBadRData
+	}
+	return TLSA{msg[current], msg[current+1], msg[current+2], msg[current+3 : end]}, nil
+}
+
+func parseTLSA(fields []string, origin string) (RData, error) {
+	fields, err := joinTail(fields, 4)
+	if err != nil {
+		return nil, err
+	}
+	var values [3]byte
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i], 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = byte(v)
+	}
+	certificate, err := parseHex(fields[3])
+	if err != nil {
+		return nil, err
+	}
+	return TLSA{values[0], values[1], values[2], certificate}, nil
+}
+
+func (tlsa TLSA) MarshalBinary(msg []byte) (data []byte, err error) {
+	return append([]byte{tlsa.Usage, tlsa.Selector, tlsa.MatchingType}, tlsa.Certificate...), nil
+}
+
+func (tlsa TLSA) String() string {
+	return fmt.Sprintf("%v %v %v %X", tlsa.Usage, tlsa.Selector, tlsa.MatchingType, tlsa.Certificate)
+}
+
+// CAA is the certification authority authorization record (RFC 8659). The
+// tag is kept in the original case.
+type CAA struct {
+	Flags byte
+	Tag   string
+	Value string
+}
+
+// CAAFlagCritical is the issuer critical flag.
+const CAAFlagCritical = 128
+
+func decodeCAA(msg []byte, current int, end int) (RData, error) {
+	if end < current+2 {
+		return nil, ErrBadRData
+	}
+	flags := msg[current]
+	tagLen := int(msg[current+1])
+	if tagLen == 0 || end < current+2+tagLen {
+		return nil, ErrBadRData
+	}
+	tag := string(msg[current+2 : current+2+tagLen])
+	value := string(msg[current+2+tagLen : end])
+	return CAA{flags, tag, value}, nil
+}
+
+func parseCAA(fields []string, origin string) (RData, error) {
+	fields, err := joinTail(fields, 3)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	tag := fields[1]
+	if len(tag) == 0 || 15 < len(tag) {
+		return nil, fmt.Errorf("invalid CAA tag: %v", tag)
+	}
+	for _, c := range tag {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return nil, fmt.Errorf("invalid CAA tag: %v", tag)
+		}
+	}
+	value, err := unescapeValue(unquote(fields[2]))
+	if err != nil {
+		return nil, err
+	}
+	return CAA{byte(flags), tag, value}, nil
+}
+
+func (caa CAA) MarshalBinary(msg []byte) (data []byte, err error) {
+	if len(caa.Tag) == 0 || 255 < len(caa.Tag) {
+		return nil, fmt.Errorf("invalid CAA tag: %v", caa.Tag)
+	}
+	data = append([]byte{caa.Flags, byte(len(caa.Tag))}, caa.Tag...)
+	return append(data, caa.Value...), nil
+}
+
+func (caa CAA) String() string {
+	return fmt.Sprintf("%v %v %v", caa.Flags, caa.Tag, quoteText(caa.Value))
 }
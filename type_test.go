@@ -2,6 +2,7 @@ package dns
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -26,7 +27,7 @@ func TestDNSKeyDigest(t *testing.T) {
 		if err != nil {
 			t.FailNow()
 		}
-		sum, err := dnskey.Digest(".")
+		sum, err := dnskey.Digest(".", DigestSHA256)
 		if err != nil {
 			t.FailNow()
 		}
@@ -42,7 +43,7 @@ func TestDNSKeyDigest(t *testing.T) {
 		if err != nil {
 			t.FailNow()
 		}
-		sum, err := dnskey.Digest("com.")
+		sum, err := dnskey.Digest("com.", DigestSHA256)
 		if err != nil {
 			t.FailNow()
 		}
@@ -51,3 +52,195 @@ func TestDNSKeyDigest(t *testing.T) {
 		}
 	}
 }
+
+func TestDNSKEYKeyTag(t *testing.T) {
+	data := []struct {
+		dnskey string
+		keyTag uint16
+	}{
+		// root KSK
+		{"257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=", 20326},
+		// com KSK
+		{"257 3 8 AQPDzldNmMvZFX4NcNJ0uEnKDg7tmv/F3MyQR0lpBmVcNcsIszxNFxsBfKNW9JYCYqpik8366LE7VbIcNRzfp2h9OO8HRl+H+E08zauK8k7evWEmu/6od+2boggPoiEfGNyvNPaSI7FOIroDsnw/taggzHRX1Z7SOiOiPWPNIwSUyWOZ79VmcQ1GLkC6NlYvG3HwYmynQv6oFwGv/KELSw7ZSdrbTQ0HXvZbqMUI7BaMskmvgm1G7oKZ1YiF7O9ioVNc0+7ASbqmZN7Z98EGU/Qh2K/BgUe8Hs0XVcdPKrtyYnoQHd2ynKPcMMlTEih2/2HDHjRPJ2aywIpKNnv4oPo/", 30909},
+		// RFC 4034 Section 5.4
+		{"256 3 5 AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==", 60485},
+	}
+	for _, v := range data {
+		dnskey := mustParseDNSKEY(v.dnskey)
+		if keyTag := dnskey.KeyTag(); keyTag != v.keyTag {
+			t.Error(keyTag, v.keyTag)
+		}
+	}
+}
+
+func TestDSDigestTypes(t *testing.T) {
+	data := []struct {
+		name       string
+		dnskey     string
+		digestType uint8
+		ds         string
+	}{
+		// RFC 4034 Section 5.4
+		{"dskey.example.com.", "256 3 5 AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==",
+			DigestSHA1, "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118"},
+		// RFC 6605 Section 6.2
+		{"example.net.", "257 3 14 xKYaNhWdGOfJ+nPrL8/arkwf2EY3MDJ+SErKivBVSum1w/egsXvSADtNJhyem5RCOpgQ6K8X1DRSEkrbYQ+OB+v8/uX45NBwY8rp65F6Glur8I/mlVNgF6W/qTI37m40",
+			DigestSHA384, "10771 14 4 72D7B62976CE06438E9C0BF319013CF801F09ECC84B8D7E9495F27E305C6A9B0563A9B5F4D288405C3008A946DF983D6"},
+	}
+	for _, v := range data {
+		ds, err := mustParseDNSKEY(v.dnskey).ToDS(strings.ToUpper(v.name), v.digestType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := ds.String(); s != v.ds {
+			t.Error(s)
+		}
+	}
+	if _, err := mustParseDNSKEY(data[0].dnskey).Digest(data[0].name, 3); err == nil {
+		t.Error("digest type 3")
+	}
+}
+
+func TestTypeString(t *testing.T) {
+	if s := TypeA.String(); s != "A" {
+		t.Error(s)
+	}
+	if s := Type(65534).String(); s != "TYPE65534" {
+		t.Error(s)
+	}
+	data := map[string]Type{
+		"A":         TypeA,
+		"TYPE1":     TypeA,
+		"TYPE65534": 65534,
+	}
+	for k, v := range data {
+		actual, err := typeFromString(k)
+		if err != nil || actual != v {
+			t.Error(k, actual, err)
+		}
+	}
+	for _, v := range []string{"FOO", "TYPE", "TYPE65536", "TYPE-1"} {
+		if _, err := typeFromString(v); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+func TestSRVAndNAPTR(t *testing.T) {
+	data := []struct {
+		type_  Type
+		fields string
+		text   string
+	}{
+		{TypeSRV, "10 60 5060 sip", "10 60 5060 sip.example.com."},
+		{TypeSRV, "0 0 0 .", "0 0 0 ."},
+		{TypeNAPTR, `100 10 "S" "SIP+D2U" "" _sip._udp`, `100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`},
+		{TypeNAPTR, `100 50 "a" "z3950+N2L+N2C" "!^.*$!cidserver.example.com!" .`, `100 50 "a" "z3950+N2L+N2C" "!^.*$!cidserver.example.com!" .`},
+	}
+	for _, v := range data {
+		type_, rdata, err := parseRData(v.type_.String(), strings.Fields(v.fields), "example.com.")
+		if err != nil || type_ != v.type_ {
+			t.Fatal(v.fields, err)
+		}
+		if s := rdata.String(); s != v.text {
+			t.Error(s)
+		}
+		b, err := rdata.MarshalBinary(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeRData(type_, b, 0, len(b))
+		if err != nil || decoded != rdata {
+			t.Error(v.fields, decoded, err)
+		}
+		if _, err := decodeRData(type_, b, 0, len(b)-1); err == nil {
+			t.Error(v.fields)
+		}
+	}
+	for _, v := range []string{"10 60 sip", "10 60 65536 sip", "a 60 5060 sip"} {
+		if _, _, err := parseRData("SRV", strings.Fields(v), "example.com."); err == nil {
+			t.Error(v)
+		}
+	}
+	if _, _, err := parseRData("NAPTR", strings.Fields(`100 10 "S" "" .`), "example.com."); err == nil {
+		t.Error("NAPTR")
+	}
+}
+
+func TestNAPTRRoundTrip(t *testing.T) {
+	text := `100 10 "u" "E2U+sip" "!^.*$!sip:\\1@example.com!" .`
+	_, rdata, err := parseRData("NAPTR", strings.Fields(text), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regexp := rdata.(NAPTR).Regexp; regexp != `!^.*$!sip:\1@example.com!` {
+		t.Error(regexp)
+	}
+	for i := 0; i < 2; i++ {
+		if s := rdata.String(); s != text {
+			t.Fatal(s)
+		}
+		_, rdata, err = parseRData("NAPTR", strings.Fields(rdata.String()), "example.com.")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCAATLSASSHFP(t *testing.T) {
+	data := []struct {
+		type_    Type
+		fields   string
+		text     string
+		expected RData
+	}{
+		{TypeCAA, `0 issue "ca.example.net; account=230123"`, `0 issue "ca.example.net; account=230123"`, CAA{0, "issue", "ca.example.net; account=230123"}},
+		{TypeCAA, `128 tbs "Unknown"`, `128 tbs "Unknown"`, CAA{CAAFlagCritical, "tbs", "Unknown"}},
+		{TypeCAA, `0 issue ";"`, `0 issue ";"`, CAA{0, "issue", ";"}},
+		{TypeCAA, `0 iodef "mailto:\"sec\"@example.com"`, `0 iodef "mailto:\"sec\"@example.com"`, CAA{0, "iodef", `mailto:"sec"@example.com`}},
+		{TypeTLSA, "3 1 1 0123456789abcdef 0123456789ABCDEF", "3 1 1 0123456789ABCDEF0123456789ABCDEF",
+			TLSA{3, 1, 1, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}}},
+		{TypeSSHFP, "4 2 123456789abcdef0", "4 2 123456789ABCDEF0", SSHFP{4, 2, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}}},
+	}
+	for _, v := range data {
+		type_, rdata, err := parseRData(v.type_.String(), strings.Fields(v.fields), "example.com.")
+		if err != nil || type_ != v.type_ {
+			t.Fatal(v.fields, err)
+		}
+		if !reflect.DeepEqual(rdata, v.expected) {
+			t.Errorf("%#v", rdata)
+		}
+		if s := rdata.String(); s != v.text {
+			t.Error(s)
+		}
+		b, err := rdata.MarshalBinary(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeRData(type_, b, 0, len(b))
+		if err != nil || !reflect.DeepEqual(decoded, rdata) {
+			t.Error(v.fields, decoded, err)
+		}
+	}
+	for _, v := range []struct {
+		type_  Type
+		fields string
+	}{
+		{TypeCAA, `0 is-sue "ca.example.net"`},
+		{TypeCAA, `0 abcdefghijklmnop "ca.example.net"`},
+		{TypeCAA, `256 issue "ca.example.net"`},
+		{TypeCAA, `0 issue`},
+		{TypeTLSA, "3 1 1"},
+		{TypeTLSA, "3 1 1 xyz"},
+		{TypeSSHFP, "4 2 123"},
+	} {
+		if _, _, err := parseRData(v.type_.String(), strings.Fields(v.fields), "example.com."); err == nil {
+			t.Error(v.fields)
+		}
+	}
+	for _, v := range [][]byte{{0, 0}, {0, 6, 'i', 's', 's', 'u', 'e'}} {
+		if _, err := decodeRData(TypeCAA, v, 0, len(v)); err == nil {
+			t.Error(v)
+		}
+	}
+}
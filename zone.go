@@ -1,12 +1,17 @@
 package dns
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"net/netip"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+
+	"try/dns/idna"
 )
 
 type Zone struct {
@@ -15,153 +20,608 @@ type Zone struct {
 	Records []ResourceRecord
 }
 
+// maxIncludeDepth limits the nesting of $INCLUDE to stop include loops.
+const maxIncludeDepth = 8
+
+// maxGenerate limits the records of a $GENERATE, enough for a /16 reverse
+// zone.
+const maxGenerate = 65536
+
+// ReadZonefile reads a master file (RFC 1035 Section 5). The errors have
+// the path and the line number where they occurred.
 func ReadZonefile(path string) (*Zone, error) {
-	f, err := os.Open(path)
+	return ReadZonefileWithOrigin(path, "")
+}
+
+// ReadZonefileWithOrigin reads a master file with origin as the initial
+// origin, for the files without $ORIGIN such as those of NSD.
+func ReadZonefileWithOrigin(path string, origin string) (*Zone, error) {
+	p := &zoneParser{zone: new(Zone), defaultTTL: -1, ttl: -1}
+	if origin != "" {
+		name, err := idna.ToASCII(origin)
+		if err != nil {
+			return nil, err
+		}
+		if !isAbsolute(name) {
+			name += "."
+		}
+		p.origin, p.zone.Origin = name, name
+	}
+	err := p.readFile(path, 0)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	return p.zone, nil
+}
+
+// blobTypes maps the types whose RDATA ends with a base64 or hex string,
+// which may be split with spaces, to the number of the fields before it.
+var blobTypes = map[Type]int{
+	TypeDS:     3,
+	TypeSSHFP:  2,
+	TypeRRSIG:  8,
+	TypeDNSKEY: 3,
+	TypeTLSA:   3,
+}
 
-	zone := new(Zone)
+// blobLineLen is the length of the lines that long base64 or hex strings
+// are split into.
+const blobLineLen = 56
 
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		line := sc.Text()
-		fields := strings.Fields(line)
-		if len(fields) == 0 || strings.HasPrefix(fields[0], ";") {
-			continue
+// WriteTo writes z as a master file that ReadZonefile reads back. The
+// records are sorted in the canonical order (RFC 4034 Section 6) with the
+// SOA record first, and the owner names are relative to the origin.
+func (z *Zone) WriteTo(w io.Writer) (int64, error) {
+	records := append([]ResourceRecord{}, z.Records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return compareRecords(records[i], records[j], Name(z.Origin)) < 0
+	})
+
+	var buf bytes.Buffer
+	if z.Origin != "" {
+		fmt.Fprintf(&buf, "$ORIGIN %v\n", z.Origin)
+	}
+	if z.TTL != 0 {
+		fmt.Fprintf(&buf, "$TTL %v\n", z.TTL)
+	}
+
+	owners := make([]string, len(records))
+	var ownerWidth, ttlWidth, typeWidth int
+	for i, rr := range records {
+		owners[i] = relativeName(rr.Name, Name(z.Origin))
+		if 0 < i && rr.Name.Equal(records[i-1].Name) {
+			owners[i] = ""
+		}
+		ownerWidth = max(ownerWidth, len(owners[i]))
+		ttlWidth = max(ttlWidth, len(strconv.Itoa(int(rr.TTL))))
+		typeWidth = max(typeWidth, len(rr.Type.String()))
+	}
+	for i, rr := range records {
+		head := fmt.Sprintf("%-*s %*d %v %-*s ", ownerWidth, owners[i], ttlWidth, rr.TTL, rr.Class, typeWidth, rr.Type)
+		buf.WriteString(head)
+		buf.WriteString(formatRData(rr.Type, printRData(rr.Type, rr.RData), len(head)))
+		buf.WriteByte('\n')
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func max(a, b int) int {
+	if a < b {
+		return b
+	}
+	return a
+}
+
+// compareRecords compares a and b in the order of WriteTo, and returns -1,
+// 0 or 1.
+func compareRecords(a, b ResourceRecord, origin Name) int {
+	aSOA := a.Type == TypeSOA && a.Name.Equal(origin)
+	bSOA := b.Type == TypeSOA && b.Name.Equal(origin)
+	switch {
+	case aSOA && !bSOA:
+		return -1
+	case !aSOA && bSOA:
+		return 1
+	}
+	if c := a.Name.CompareCanonical(b.Name); c != 0 {
+		return c
+	}
+	switch {
+	case a.Type < b.Type:
+		return -1
+	case a.Type > b.Type:
+		return 1
+	}
+	aData, _ := CanonicalRData(a.RData).MarshalBinary(nil)
+	bData, _ := CanonicalRData(b.RData).MarshalBinary(nil)
+	return bytes.Compare(aData, bData)
+}
+
+// relativeName returns name relative to origin, @ for origin itself, or
+// name as it is if it is not in origin.
+func relativeName(name Name, origin Name) string {
+	if origin == "" || !name.IsSubdomainOf(origin) {
+		return name.String()
+	}
+	if name.Equal(origin) {
+		return "@"
+	}
+	if origin == "." {
+		return strings.TrimSuffix(name.String(), ".")
+	}
+	_, starts, _ := splitName(name.String())
+	return name.String()[:starts[len(starts)-origin.CountLabels()]-1]
+}
+
+// formatRData splits the base64 or hex string at the end of long RDATA into
+// lines in parentheses, indented by indent.
+func formatRData(type_ Type, text string, indent int) string {
+	n, ok := blobTypes[type_]
+	if !ok || len(text) <= blobLineLen {
+		return text
+	}
+	fields := strings.SplitN(text, " ", n+1)
+	if len(fields) != n+1 {
+		return text
+	}
+	blob := fields[n]
+	var b strings.Builder
+	b.WriteString(strings.Join(fields[:n], " "))
+	b.WriteString(" (")
+	for 0 < len(blob) {
+		line := blob
+		if blobLineLen < len(line) {
+			line = line[:blobLineLen]
 		}
-		if fields[0] == "$ORIGIN" {
-			if !strings.HasSuffix(fields[1], ".") {
-				return nil, fmt.Errorf("$ORIGIN: invalid format")
+		blob = blob[len(line):]
+		b.WriteString("\n")
+		b.WriteString(strings.Repeat(" ", indent))
+		b.WriteString(line)
+	}
+	b.WriteString(" )")
+	return b.String()
+}
+
+// zoneParser holds the state that carries over the entries of master files.
+type zoneParser struct {
+	zone   *Zone
+	origin string
+	owner  string // the owner of the last record, for blank owners
+
+	// the TTL of $TTL and the last explicit TTL, or -1
+	defaultTTL int
+	ttl        int
+}
+
+func (p *zoneParser) readFile(path string, depth int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	l := &zoneLexer{path: path, data: data, line: 1}
+	for {
+		entry, err := l.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.tokens[0] == "$INCLUDE" && !entry.blankOwner {
+			err = p.include(path, entry.tokens[1:], depth)
+		} else {
+			err = p.parseEntry(entry, depth)
+		}
+		if err != nil {
+			return fmt.Errorf("%v:%v: %w", path, entry.line, err)
+		}
+	}
+}
+
+// include reads the file of $INCLUDE, whose path is relative to the
+// directory of the including file. The origin is restored afterwards.
+func (p *zoneParser) include(path string, args []string, depth int) error {
+	if len(args) < 1 || 2 < len(args) {
+		return fmt.Errorf("$INCLUDE: invalid format: %v", args)
+	}
+	if maxIncludeDepth <= depth {
+		return fmt.Errorf("$INCLUDE: too deep: %v", args[0])
+	}
+	origin := p.origin
+	defer func() { p.origin = origin }()
+	if len(args) == 2 {
+		name, err := p.parseName(args[1])
+		if err != nil {
+			return fmt.Errorf("$INCLUDE: %w", err)
+		}
+		p.origin = name
+	}
+	included := unquote(args[0])
+	if !filepath.IsAbs(included) {
+		included = filepath.Join(filepath.Dir(path), included)
+	}
+	return p.readFile(included, depth+1)
+}
+
+func (p *zoneParser) parseEntry(entry zoneEntry, depth int) error {
+	tokens := entry.tokens
+	if !entry.blankOwner {
+		switch tokens[0] {
+		case "$ORIGIN":
+			if len(tokens) != 2 {
+				return fmt.Errorf("$ORIGIN: invalid format")
 			}
-			zone.Origin = fields[1]
-		} else if fields[0] == "$TTL" {
-			zone.TTL, err = strconv.Atoi(fields[1])
+			name, err := p.parseName(tokens[1])
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("$ORIGIN: %w", err)
 			}
-		} else {
-			var (
-				name  string
-				ttl   int
-				class class = ClassIN
-			)
-
-			// name
-			if fields[0] == "@" {
-				name = zone.Origin
-			} else if strings.HasSuffix(fields[0], ".") {
-				name = fields[0]
-			} else {
-				name = fields[0] + "." + zone.Origin
-			}
-			fields = fields[1:]
-
-			// TTL
-			ttl, err := strconv.Atoi(fields[0])
+			p.origin = name
+			if depth == 0 {
+				p.zone.Origin = name
+			}
+			return nil
+		case "$TTL":
+			if len(tokens) != 2 {
+				return fmt.Errorf("$TTL: invalid format")
+			}
+			ttl, err := parseTTL(tokens[1])
 			if err != nil {
-				ttl = zone.TTL
-			} else {
-				fields = fields[1:]
+				return fmt.Errorf("$TTL: %w", err)
 			}
+			p.zone.TTL, p.defaultTTL = ttl, ttl
+			return nil
+		case "$GENERATE":
+			return p.generate(entry, depth)
+		}
+		if strings.HasPrefix(tokens[0], "$") {
+			return fmt.Errorf("unknown directive: %v", tokens[0])
+		}
+		name, err := p.parseName(tokens[0])
+		if err != nil {
+			return fmt.Errorf("invalid name: %w", err)
+		}
+		p.owner = name
+		tokens = tokens[1:]
+	} else if p.owner == "" {
+		return fmt.Errorf("no owner name")
+	}
 
-			// class
-			if fields[0] == "IN" {
-				class = ClassIN
-				fields = fields[1:]
+	// TTL and class in either order
+	ttl := -1
+	class, hasClass := ClassIN, false
+	for len(tokens) != 0 {
+		if v, err := parseTTL(tokens[0]); err == nil && ttl == -1 {
+			ttl = v
+		} else if c, ok := classOf[strings.ToUpper(tokens[0])]; ok && !hasClass {
+			class, hasClass = c, true
+		} else {
+			break
+		}
+		tokens = tokens[1:]
+	}
+	switch {
+	case ttl != -1:
+		p.ttl = ttl
+	case p.defaultTTL != -1:
+		ttl = p.defaultTTL
+	case p.ttl != -1:
+		// the last explicit TTL (RFC 2308 Section 4)
+		ttl = p.ttl
+	default:
+		return fmt.Errorf("no TTL")
+	}
+
+	if len(tokens) == 0 {
+		return fmt.Errorf("no type")
+	}
+	type_, rdata, err := parseRData(strings.ToUpper(tokens[0]), tokens[1:], p.origin)
+	if err != nil {
+		return fmt.Errorf("invalid format: %v: %w", tokens, err)
+	}
+	if type_ == TypeSOA && depth == 0 && p.zone.Origin == "" {
+		p.zone.Origin = p.owner
+	}
+	p.zone.Records = append(p.zone.Records, ResourceRecord{
+		Name(p.owner),
+		type_,
+		class,
+		TTL(ttl),
+		rdata,
+	})
+	return nil
+}
+
+// generate adds the records of $GENERATE, which BIND supports:
+//
+//	$GENERATE start-stop[/step] lhs [ttl] [class] type rhs
+//
+// In lhs and rhs, $ is replaced with the iterator, which may be modified
+// as ${offset,width,base}.
+func (p *zoneParser) generate(entry zoneEntry, depth int) error {
+	if len(entry.tokens) < 4 {
+		return fmt.Errorf("$GENERATE: invalid format")
+	}
+	start, stop, step, err := parseGenerateRange(entry.tokens[1])
+	if err != nil {
+		return fmt.Errorf("$GENERATE: %w", err)
+	}
+	for i := start; i <= stop; i += step {
+		generated := zoneEntry{line: entry.line}
+		for _, v := range entry.tokens[2:] {
+			token, err := expandGenerate(v, i)
+			if err != nil {
+				return fmt.Errorf("$GENERATE: %w", err)
 			}
+			generated.tokens = append(generated.tokens, token)
+		}
+		if err := p.parseEntry(generated, depth); err != nil {
+			return fmt.Errorf("$GENERATE: %v: %w", i, err)
+		}
+	}
+	return nil
+}
 
-			var (
-				type_ Type
-				rdata RData
-			)
+func parseGenerateRange(s string) (start, stop, step int, err error) {
+	s, stepText, hasStep := strings.Cut(s, "/")
+	startText, stopText, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid range: %v", s)
+	}
+	values := make([]int, 3)
+	for i, v := range []string{startText, stopText, stepText} {
+		if i == 2 && !hasStep {
+			values[i] = 1
+			break
+		}
+		n, err := strconv.ParseUint(v, 10, 31)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid range: %v", s)
+		}
+		values[i] = int(n)
+	}
+	if values[1] < values[0] || values[2] == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid range: %v", s)
+	}
+	if n := (values[1]-values[0])/values[2] + 1; maxGenerate < n {
+		return 0, 0, 0, fmt.Errorf("too many records: %v", n)
+	}
+	return values[0], values[1], values[2], nil
+}
 
-			if fields[0] == "A" && len(fields) == 2 {
-				addr, err := netip.ParseAddr(fields[1])
-				if err != nil {
-					return nil, err
-				}
-				if !addr.Is4() {
-					return nil, fmt.Errorf("invalid IPv4 addr")
+// expandGenerate replaces $ and ${offset,width,base} in s with i. The base
+// is d, o, x, X, or n and N for nibbles in the reverse order as in
+// ip6.arpa.
+func expandGenerate(s string, i int) (string, error) {
+	var b strings.Builder
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			b.WriteByte(s[j])
+			if j+1 < len(s) {
+				j++
+				b.WriteByte(s[j])
+			}
+			continue
+		case '$':
+		default:
+			b.WriteByte(s[j])
+			continue
+		}
+		offset, width, base := 0, 0, "d"
+		if j+1 < len(s) && s[j+1] == '{' {
+			end := strings.IndexByte(s[j:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("invalid modifier: %v", s)
+			}
+			modifier := strings.Split(s[j+2:j+end], ",")
+			if 3 < len(modifier) {
+				return "", fmt.Errorf("invalid modifier: %v", s)
+			}
+			var err error
+			if offset, err = strconv.Atoi(modifier[0]); err != nil {
+				return "", fmt.Errorf("invalid modifier: %v", s)
+			}
+			if 1 < len(modifier) {
+				if width, err = strconv.Atoi(modifier[1]); err != nil || width < 0 || 255 < width {
+					return "", fmt.Errorf("invalid modifier: %v", s)
 				}
-				type_ = TypeA
-				rdata = A(addr)
-			} else if fields[0] == "MX" && len(fields) == 3 {
-				preference, err := strconv.Atoi(fields[1])
-				if err != nil {
-					return nil, err
+			}
+			if 2 < len(modifier) {
+				base = modifier[2]
+			}
+			j += end
+		}
+		v := i + offset
+		if v < 0 {
+			return "", fmt.Errorf("negative value: %v", s)
+		}
+		switch base {
+		case "d", "o", "x", "X":
+			fmt.Fprintf(&b, "%0*"+base, width, v)
+		case "n", "N":
+			b.WriteString(nibbles(v, width, base == "N"))
+		default:
+			return "", fmt.Errorf("invalid base: %v", s)
+		}
+	}
+	return b.String(), nil
+}
 
-				}
-				exchange := fields[2]
-				if !strings.HasSuffix(exchange, ".") {
-					exchange = fields[2] + "." + zone.Origin
-				}
-				type_ = TypeMX
-				rdata = MX{
-					uint16(preference),
-					exchange,
-				}
-			} else if fields[0] == "SOA" {
-				// TODO
-				continue
-			} else if fields[0] == "NS" && len(fields) == 2 {
-				name := fields[1]
-				if !strings.HasSuffix(name, ".") {
-					name = fields[1] + "." + zone.Origin
-				}
-				type_ = TypeNS
-				rdata = NS(strings.ToLower(name))
-			} else if fields[0] == "CNAME" && len(fields) == 2 {
-				name := fields[1]
-				if name == "@" {
-					name = zone.Origin
-				} else if !strings.HasSuffix(name, ".") {
-					name = fields[1] + "." + zone.Origin
-				}
-				type_ = TypeCNAME
-				rdata = CNAME(name)
-			} else if fields[0] == "TXT" {
-				type_ = TypeTXT
-				rdata = newTxt(fields[1:])
-			} else if fields[0] == "AAAA" && len(fields) == 2 {
-				type_ = TypeAAAA
-				aaaa, err := newAAAA(fields[1:])
-				if err != nil {
-					return nil, err
-				}
-				rdata = *aaaa
-			} else {
-				m := map[string]struct {
-					Type
-					fn func([]string) (RData, error)
-				}{
-					"DS":     {TypeDS, func(s []string) (RData, error) { return newDS(s) }},
-					"RRSIG":  {TypeRRSIG, func(s []string) (RData, error) { return newRRSIG(s) }},
-					"DNSKEY": {TypeDNSKEY, func(s []string) (RData, error) { return newDNSKEY(s) }},
-					"NSEC":   {TypeNSEC, func(s []string) (RData, error) { return newNSEC(s) }},
-				}
-				v, ok := m[fields[0]]
-				if !ok {
-					return nil, fmt.Errorf("invalid format: %v", fields)
-				}
-				type_ = v.Type
-				data, err := v.fn(fields[1:])
-				if err != nil {
-					return nil, fmt.Errorf("invalid format: %v", fields)
-				}
-				rdata = data
+// nibbles returns the hex digits of v from the lowest, separated with dots
+// and padded with zeros to at least width bytes.
+func nibbles(v int, width int, upper bool) string {
+	digits := "0123456789abcdef"
+	if upper {
+		digits = "0123456789ABCDEF"
+	}
+	var b []byte
+	for {
+		b = append(b, digits[v&0xF])
+		v >>= 4
+		if v == 0 && len(b) >= width {
+			return string(b)
+		}
+		b = append(b, '.')
+	}
+}
+
+// parseName returns the absolute name of s, which may have U-labels.
+func (p *zoneParser) parseName(s string) (string, error) {
+	name, err := idna.ToASCII(s)
+	if err != nil {
+		return "", err
+	}
+	if name != "@" && isAbsolute(name) {
+		return name, nil
+	}
+	if p.origin == "" {
+		return "", fmt.Errorf("relative name without $ORIGIN: %v", s)
+	}
+	return absName(name, p.origin), nil
+}
+
+// parseTTL parses a TTL in seconds, or with the units of BIND such as 1h30m.
+func parseTTL(s string) (int, error) {
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		if math.MaxInt32 < v {
+			return 0, fmt.Errorf("TTL out of range: %v", s)
+		}
+		return int(v), nil
+	}
+	s = lowerASCII(s)
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 60 * 60, 'd': 24 * 60 * 60, 'w': 7 * 24 * 60 * 60}
+	var ttl uint64
+	start := 0
+	for i := 0; i < len(s); i++ {
+		unit, ok := units[s[i]]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(s[start:i], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid TTL: %v", s)
+		}
+		ttl += v * unit
+		if math.MaxInt32 < ttl {
+			return 0, fmt.Errorf("TTL out of range: %v", s)
+		}
+		start = i + 1
+	}
+	if start == 0 || start != len(s) {
+		return 0, fmt.Errorf("invalid TTL: %v", s)
+	}
+	return int(ttl), nil
+}
+
+// zoneEntry is a line of a master file, or lines joined with parentheses,
+// split into tokens.
+type zoneEntry struct {
+	tokens     []string
+	line       int  // the line where the entry starts
+	blankOwner bool // starts with a space, for the owner of the last record
+}
+
+// zoneLexer splits master files into entries. Comments are removed, and the
+// tokens keep the quotes and the escapes.
+type zoneLexer struct {
+	path string
+	data []byte
+	pos  int
+	line int
+}
+
+// next returns the next entry with tokens, or io.EOF.
+func (l *zoneLexer) next() (zoneEntry, error) {
+	for l.pos < len(l.data) {
+		entry, err := l.entry()
+		if err != nil || len(entry.tokens) != 0 {
+			return entry, err
+		}
+	}
+	return zoneEntry{}, io.EOF
+}
+
+// entry reads the tokens until the end of the line out of parentheses.
+func (l *zoneLexer) entry() (zoneEntry, error) {
+	entry := zoneEntry{line: l.line}
+	entry.blankOwner = l.data[l.pos] == ' ' || l.data[l.pos] == '\t'
+	parens := 0
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; c {
+		case '\n':
+			l.pos++
+			l.line++
+			if parens == 0 {
+				return entry, nil
+			}
+		case ' ', '\t', '\r':
+			l.pos++
+		case ';':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+		case '(':
+			parens++
+			l.pos++
+		case ')':
+			if parens == 0 {
+				return entry, l.errorf(l.line, "unbalanced parenthesis")
+			}
+			parens--
+			l.pos++
+		default:
+			token, err := l.token()
+			if err != nil {
+				return entry, err
 			}
+			entry.tokens = append(entry.tokens, token)
+		}
+	}
+	if parens != 0 {
+		return entry, l.errorf(entry.line, "unclosed parenthesis")
+	}
+	return entry, nil
+}
 
-			zone.Records = append(zone.Records, ResourceRecord{
-				Name(strings.ToLower(name)),
-				type_,
-				class,
-				TTL(ttl),
-				rdata,
-			})
+// token reads a token, which is a quoted string or ends before a space or a
+// special character.
+func (l *zoneLexer) token() (string, error) {
+	start := l.pos
+	// a quoted string, or a token with quoted parts such as key="value"
+	quoted := l.data[l.pos] == '"'
+	inQuote := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == '\n':
+			if inQuote {
+				return "", l.errorf(l.line, "unclosed quote")
+			}
+			return string(l.data[start:l.pos]), nil
+		case c == '\\':
+			l.pos++
+			if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+				l.line++
+			}
+		case c == '"':
+			if quoted && l.pos != start {
+				l.pos++
+				return string(l.data[start:l.pos]), nil
+			}
+			inQuote = !inQuote
+		case !inQuote && strings.IndexByte(" \t\r;()", c) != -1:
+			return string(l.data[start:l.pos]), nil
 		}
+		l.pos++
 	}
+	if inQuote {
+		return "", l.errorf(l.line, "unclosed quote")
+	}
+	l.pos = len(l.data)
+	return string(l.data[start:]), nil
+}
 
-	return zone, nil
+func (l *zoneLexer) errorf(line int, format string, a ...any) error {
+	return fmt.Errorf("%v:%v: %v", l.path, line, fmt.Sprintf(format, a...))
 }
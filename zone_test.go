@@ -1,7 +1,11 @@
 package dns
 
 import (
+	"fmt"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,13 +22,17 @@ func TestReadZonefile(t *testing.T) {
 	}
 
 	var data = map[int]ResourceRecord{
-		0:  {"example.com.", TypeNS, ClassIN, 3600, NS("ns1.example.com.")},
-		2:  {"example.com.", TypeA, ClassIN, 600, A(netip.MustParseAddr("192.0.2.1"))},
-		4:  {"www.example.com.", TypeCNAME, ClassIN, 3600, CNAME("example.com.")},
-		5:  {"mx1.example.com.", TypeA, ClassIN, 3600, A(netip.MustParseAddr("192.0.2.3"))},
-		7:  {"example.com.", TypeMX, ClassIN, 3600, MX{10, "mx1.example.com."}},
-		9:  {"example.com.", TypeTXT, ClassIN, 3600, TXT("foo\x00bar")},
-		10: {"example.com.", TypeAAAA, ClassIN, 600, AAAA(netip.MustParseAddr("2001:db8::1"))},
+		0:  {"example.com.", TypeSOA, ClassIN, 3600, SOA{"ns1.example.com.", "hostmaster.example.com.", 2016020202, 7200, 1800, 1209600, 86400}},
+		1:  {"example.com.", TypeNS, ClassIN, 3600, NS("ns1.example.com.")},
+		3:  {"example.com.", TypeA, ClassIN, 600, A(netip.MustParseAddr("192.0.2.1"))},
+		5:  {"www.example.com.", TypeCNAME, ClassIN, 3600, CNAME("example.com.")},
+		6:  {"mx1.example.com.", TypeA, ClassIN, 3600, A(netip.MustParseAddr("192.0.2.3"))},
+		8:  {"example.com.", TypeMX, ClassIN, 3600, MX{10, "mx1.example.com."}},
+		10: {"example.com.", TypeTXT, ClassIN, 3600, TXT("foo\x00bar")},
+		11: {"example.com.", TypeAAAA, ClassIN, 600, AAAA(netip.MustParseAddr("2001:db8::1"))},
+		27: {"_sip._udp.example.com.", TypeSRV, ClassIN, 3600, SRV{10, 60, 5060, "sip.example.com."}},
+		28: {"example.com.", TypeNAPTR, ClassIN, 3600, NAPTR{100, 10, "S", "SIP+D2U", "", "_sip._udp.example.com."}},
+		32: {"example.com.", TypeCAA, ClassIN, 3600, CAA{0, "issue", "ca.example.net; account=230123"}},
 	}
 	for k, v := range data {
 		if v != zone.Records[k] {
@@ -39,3 +47,364 @@ func TestReadRootZoneFile(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReadZonefileUnknownType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	err := os.WriteFile(path, []byte(`$ORIGIN example.com.
+$TTL 3600
+@ IN TYPE65534 \# 4 0a000001
+@ IN TYPE1 \# 4 c0000201
+@ IN TYPE65533 \# 0
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`example.com. 3600 IN TYPE65534 \# 4 0a000001`,
+		`example.com. 3600 IN A 192.0.2.1`,
+		`example.com. 3600 IN TYPE65533 \# 0`,
+	}
+	for i, v := range expected {
+		if s := zone.Records[i].String(); s != v {
+			t.Error(s)
+		}
+	}
+}
+
+func TestReadZonefileIDN(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jp.zone")
+	err := os.WriteFile(path, []byte(`$ORIGIN 日本語.jp.
+$TTL 3600
+@ IN A 192.0.2.1
+ラーメン IN A 192.0.2.2
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`xn--wgv71a119e.jp. 3600 IN A 192.0.2.1`,
+		`xn--4dkp5a8a.xn--wgv71a119e.jp. 3600 IN A 192.0.2.2`,
+	}
+	for i, v := range expected {
+		if s := zone.Records[i].String(); s != v {
+			t.Error(s)
+		}
+	}
+}
+
+func TestReadZonefileSyntax(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "hosts.inc"), []byte(`host1 A 192.0.2.11 ; included
+host2 A 192.0.2.12
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "example.com.zone")
+	err = os.WriteFile(path, []byte(`$ORIGIN example.com.
+$TTL 1h
+@ IN SOA ns1 hostmaster (
+        2016020202 ; serial
+        2h         ; refresh
+        30M        ; retry
+        2w         ; expire
+        1d )       ; minimum
+  NS ns1 ; blank owner
+ns1 IN 600 A 192.0.2.1
+    300 IN AAAA 2001:db8::1
+txt TXT "foo bar" "semi;colon" "quote\"d" plain "" "\065\066"
+$INCLUDE hosts.inc sub
+host3 1h30m A 192.0.2.13
+Mixed.Case. 60 in a 192.0.2.14
+svc HTTPS 1 . alpn="h2,h3" port=8443
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Origin != "example.com." || zone.TTL != 3600 {
+		t.Error(zone.Origin, zone.TTL)
+	}
+	expected := []string{
+		`example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2016020202 7200 1800 1209600 86400`,
+		`example.com. 3600 IN NS ns1.example.com.`,
+		`ns1.example.com. 600 IN A 192.0.2.1`,
+		`ns1.example.com. 300 IN AAAA 2001:db8::1`,
+		`txt.example.com. 3600 IN TXT "foo bar" "semi;colon" "quote\"d" "plain" "" "AB"`,
+		`host1.sub.example.com. 3600 IN A 192.0.2.11`,
+		`host2.sub.example.com. 3600 IN A 192.0.2.12`,
+		`host3.example.com. 5400 IN A 192.0.2.13`,
+		`Mixed.Case. 60 IN A 192.0.2.14`,
+		`svc.example.com. 3600 IN HTTPS 1 . alpn=h2,h3 port=8443`,
+	}
+	if len(zone.Records) != len(expected) {
+		t.Fatal(zone.Records)
+	}
+	for i, v := range expected {
+		if s := zone.Records[i].String(); s != v {
+			t.Error(s)
+		}
+	}
+}
+
+func TestReadZonefileNoDefaultTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	err := os.WriteFile(path, []byte(`$ORIGIN example.com.
+@ 300 IN A 192.0.2.1
+www IN A 192.0.2.2
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Records[1].TTL != 300 {
+		t.Error(zone.Records[1])
+	}
+}
+
+func TestReadZonefileError(t *testing.T) {
+	data := []struct {
+		text string
+		line int
+	}{
+		{"$TTL 3600\nwww A 192.0.2.1\n", 2},                               // relative name without $ORIGIN
+		{"$ORIGIN example.com.\n$TTL 3600\n\n@ IN A 192.0.2.256\n", 4},    // invalid RDATA
+		{"$ORIGIN example.com.\n$TTL 3600\n@ IN SOA ns1 hm ( 1 2 3\n", 3}, // unclosed parenthesis
+		{"$ORIGIN example.com.\n$TTL 3600\n@ IN TXT \"foo\n", 3},          // unclosed quote
+		{"$ORIGIN example.com.\n$TTL 3600\n@ IN A 192.0.2.1 )\n", 3},      // unbalanced parenthesis
+		{"$ORIGIN example.com.\n@ IN A 192.0.2.1\n", 2},                   // no TTL
+		{"$ORIGIN example.com.\n$TTL 1x\n", 2},                            // invalid TTL
+		{"$ORIGIN example.com.\n$TTL 3600\n$INCLUDE missing.inc\n", 3},    // missing file
+		{"$ORIGIN example.com.\n$TTL 3600\n$FOO\n", 3},                    // unknown directive
+		{"$ORIGIN example.com.\n$TTL 3600\n  A 192.0.2.1\n", 3},           // no owner
+		{"$ORIGIN example.com.\n$TTL 3600\n@ IN\n", 3},                    // no type
+	}
+	for _, v := range data {
+		path := filepath.Join(t.TempDir(), "example.com.zone")
+		if err := os.WriteFile(path, []byte(v.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ReadZonefile(path)
+		if err == nil || !strings.HasPrefix(err.Error(), fmt.Sprintf("%v:%v: ", path, v.line)) {
+			t.Errorf("%q: %v", v.text, err)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	data := map[string]int{
+		"0":          0,
+		"3600":       3600,
+		"1h":         3600,
+		"1H30M":      5400,
+		"1w2d3h4m5s": 788645,
+		"2147483647": 2147483647,
+	}
+	for k, v := range data {
+		if ttl, err := parseTTL(k); err != nil || ttl != v {
+			t.Error(k, ttl, err)
+		}
+	}
+	for _, v := range []string{"", "h", "1x", "1h30", "-1", "2147483648", "4294967296", "1h1"} {
+		if _, err := parseTTL(v); err == nil {
+			t.Error(v)
+		}
+	}
+}
+
+func TestReadZonefileGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	err := os.WriteFile(path, []byte(`$ORIGIN 2.0.192.in-addr.arpa.
+$TTL 3600
+$GENERATE 1-3 $ PTR host-$.example.com.
+$GENERATE 10-14/2 ${-9,3} 300 IN PTR host-${0,2,x}.example.com. ; comment
+$GENERATE 255-255 ${0,0,X} CNAME ${0,3,n}.\$
+$GENERATE 1-1 @ MX 10 mx$
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`1.2.0.192.in-addr.arpa. 3600 IN PTR host-1.example.com.`,
+		`2.2.0.192.in-addr.arpa. 3600 IN PTR host-2.example.com.`,
+		`3.2.0.192.in-addr.arpa. 3600 IN PTR host-3.example.com.`,
+		`001.2.0.192.in-addr.arpa. 300 IN PTR host-0a.example.com.`,
+		`003.2.0.192.in-addr.arpa. 300 IN PTR host-0c.example.com.`,
+		`005.2.0.192.in-addr.arpa. 300 IN PTR host-0e.example.com.`,
+		`FF.2.0.192.in-addr.arpa. 3600 IN CNAME f.f.\$.2.0.192.in-addr.arpa.`,
+		`2.0.192.in-addr.arpa. 3600 IN MX 10 mx1.2.0.192.in-addr.arpa.`,
+	}
+	if len(zone.Records) != len(expected) {
+		t.Fatal(zone.Records)
+	}
+	for i, v := range expected {
+		if s := zone.Records[i].String(); s != v {
+			t.Error(s)
+		}
+	}
+
+	for _, v := range []string{
+		"$GENERATE 3-1 $ PTR host-$.example.com.",
+		"$GENERATE 1-3/0 $ PTR host-$.example.com.",
+		"$GENERATE 1 $ PTR host-$.example.com.",
+		"$GENERATE 1-3 ${-2} PTR host-$.example.com.",
+		"$GENERATE 1-3 ${0,2,z} PTR host-$.example.com.",
+		"$GENERATE 1-3 ${0,2 PTR host-$.example.com.",
+		"$GENERATE 1-3 $ PTR",
+		"$GENERATE 0-4294967295 $ PTR host-$.example.com.",
+		"$GENERATE 0-2147483647 $ PTR host-$.example.com.",
+		"$GENERATE 0-65536 $ PTR host-$.example.com.",
+	} {
+		if err := os.WriteFile(path, []byte("$ORIGIN example.com.\n$TTL 3600\n"+v+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadZonefile(path); err == nil || !strings.HasPrefix(err.Error(), path+":3: $GENERATE") {
+			t.Error(v, err)
+		}
+	}
+}
+
+func TestNibbles(t *testing.T) {
+	data := []struct {
+		v     int
+		width int
+		upper bool
+		s     string
+	}{
+		{0, 0, false, "0"},
+		{0x1a, 0, false, "a.1"},
+		{0x1a, 0, true, "A.1"},
+		{0x1a, 7, false, "a.1.0.0"},
+		{0xabc, 3, false, "c.b.a"},
+	}
+	for _, v := range data {
+		if s := nibbles(v.v, v.width, v.upper); s != v.s {
+			t.Error(v, s)
+		}
+	}
+}
+
+func TestZoneWriteTo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	err := os.WriteFile(path, []byte(`$ORIGIN example.com.
+$TTL 3600
+www IN A 192.0.2.2
+@ IN NS ns1
+@ IN SOA ns1 hostmaster 1 7200 1800 1209600 86400
+a.b IN A 192.0.2.1
+www IN A 192.0.2.1
+@ 86400 IN DNSKEY 257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=
+other.example. IN CNAME www
+@ 3600 IN RRSIG MX 15 2 3600 20150819220000 20150729220000 3613 example.com. oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QLs3fx8A4M3e23mRZ9VrbpMngwcrqNAg==
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, err := ReadZonefile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	n, err := zone.WriteTo(&b)
+	if err != nil || n != int64(b.Len()) {
+		t.Fatal(n, err)
+	}
+	expected := `$ORIGIN example.com.
+$TTL 3600
+@               3600 IN SOA    ns1.example.com. hostmaster.example.com. 1 7200 1800 1209600 86400
+                3600 IN NS     ns1.example.com.
+                3600 IN RRSIG  MX 15 2 3600 20150819220000 20150729220000 3613 example.com. (
+                               oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QL
+                               s3fx8A4M3e23mRZ9VrbpMngwcrqNAg== )
+               86400 IN DNSKEY 257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=
+a.b             3600 IN A      192.0.2.1
+www             3600 IN A      192.0.2.1
+                3600 IN A      192.0.2.2
+other.example.  3600 IN CNAME  www.example.com.
+`
+	if b.String() != expected {
+		t.Error(b.String())
+	}
+}
+
+func TestZoneWriteToRoundTrip(t *testing.T) {
+	escaped := filepath.Join(t.TempDir(), "escaped.zone")
+	err := os.WriteFile(escaped, []byte(`$ORIGIN example.com.
+$TTL 3600
+@ SOA ns1 hostmaster 1 7200 1800 1209600 86400
+txt TXT "a\255b\001c" "\"\\" "caf\195\169"
+svc HTTPS 1 . alpn="a\\,b,h2" key65000="x;y (z)"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"testdata/zones/example.com.zone", "root_files/root.zone", escaped} {
+		zone, err := ReadZonefile(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), filepath.Base(v))
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = zone.WriteTo(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		written, err := ReadZonefile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written.Origin != zone.Origin || written.TTL != zone.TTL || len(written.Records) != len(zone.Records) {
+			t.Fatal(v, written.Origin, written.TTL, len(written.Records))
+		}
+		count := make(map[string]int)
+		for _, rr := range zone.Records {
+			count[rr.String()]++
+		}
+		for _, rr := range written.Records {
+			count[rr.String()]--
+		}
+		for k, c := range count {
+			if c != 0 {
+				t.Error(v, k, c)
+			}
+		}
+	}
+}
+
+func TestReadZonefileWithOrigin(t *testing.T) {
+	zone, err := ReadZonefileWithOrigin("testdata/zones/2.0.192.in-addr.arpa.zone", "2.0.192.in-addr.arpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Origin != "2.0.192.in-addr.arpa." {
+		t.Error(zone.Origin)
+	}
+	if s := zone.Records[3].String(); s != "1.2.0.192.in-addr.arpa. 3600 IN PTR example.com." {
+		t.Error(s)
+	}
+	if _, err := ReadZonefile("testdata/zones/2.0.192.in-addr.arpa.zone"); err == nil {
+		t.Error("no origin")
+	}
+}